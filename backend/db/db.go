@@ -37,7 +37,37 @@ func Connect() {
 }
 
 func Migrate() {
-	files := []string{"sql/schema.sql", "sql/phase6_offline.sql", "sql/phase7_lifecycle.sql", "sql/cms.sql"}
+	files := []string{
+		"sql/schema.sql",
+		"sql/phase6_offline.sql",
+		"sql/phase7_lifecycle.sql",
+		"sql/cms.sql",
+		"sql/invoice_sequences.sql",
+		"sql/sale_payments.sql",
+		"sql/taxes.sql",
+		"sql/product_variants.sql",
+		"sql/branches.sql",
+		"sql/stock_transfers.sql",
+		"sql/sale_returns.sql",
+		"sql/purchase_returns.sql",
+		"sql/goods_receipts.sql",
+		"sql/costing.sql",
+		"sql/stock_counts.sql",
+		"sql/lots.sql",
+		"sql/serials.sql",
+		"sql/reorder.sql",
+		"sql/units.sql",
+		"sql/kits.sql",
+		"sql/manufacturing.sql",
+		"sql/customers.sql",
+		"sql/loyalty.sql",
+		"sql/quotations.sql",
+		"sql/offline_conflicts.sql",
+		"sql/sale_origin.sql",
+		"sql/pos_devices.sql",
+		"sql/pos_catalog.sql",
+		"sql/stock_ledger_ref_types.sql",
+	}
 	for _, f := range files {
 		content, err := os.ReadFile(f)
 		if err != nil {
@@ -42,7 +42,7 @@ func SeedDemoData() {
 func insertProd(tid, name, sku string, price float64, stock int) {
 	var pid string
 	DB.QueryRow("INSERT INTO products (tenant_id, name, sku, price, is_active) VALUES ($1, $2, $3, $4, true) RETURNING id", tid, name, sku, price).Scan(&pid)
-	DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, $3)", tid, pid, stock)
+	DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, branch_id, quantity) SELECT $1, $2, id, $3 FROM branches WHERE tenant_id=$1 AND is_default", tid, pid, stock)
 }
 
 func SeedCMS() {
@@ -116,8 +116,7 @@ func AdminGetTenant(c *gin.Context) {
 	db.DB.QueryRow("SELECT COALESCE(SUM(final_amount), 0), COUNT(*) FROM sales WHERE tenant_id = $1", id).Scan(&stats.Revenue, &stats.Orders)
 	db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE tenant_id = $1", id).Scan(&stats.Users)
 
-	// Mock branches count since we might not have branch table yet (assuming 1 if no table)
-	stats.Branches = 1
+	db.DB.QueryRow("SELECT COUNT(*) FROM branches WHERE tenant_id = $1 AND is_active", id).Scan(&stats.Branches)
 
 	c.JSON(200, gin.H{
 		"id":         t.ID,
@@ -39,6 +39,13 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	// Every tenant starts with one (default) branch that holds its stock
+	_, err = tx.Exec("INSERT INTO branches (tenant_id, name, code, is_default) VALUES ($1, 'Main Branch', 'MAIN', true)", tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create branch"})
+		return
+	}
+
 	hashedPassword, _ := utils.HashPassword(req.Password)
 	var userID string
 	// Defaults to 'owner' role
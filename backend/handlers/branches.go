@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+const branchColumns = `id, name, code, COALESCE(address, ''), COALESCE(phone, ''), COALESCE(invoice_prefix, ''), is_default, is_active, created_at`
+
+func scanBranch(row interface{ Scan(...interface{}) error }, b *models.Branch) error {
+	return row.Scan(&b.ID, &b.Name, &b.Code, &b.Address, &b.Phone, &b.InvoicePrefix, &b.IsDefault, &b.IsActive, &b.CreatedAt)
+}
+
+func ListBranches(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query("SELECT "+branchColumns+" FROM branches WHERE tenant_id=$1 ORDER BY is_default DESC, name", tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var branches []models.Branch
+	for rows.Next() {
+		var b models.Branch
+		scanBranch(rows, &b)
+		branches = append(branches, b)
+	}
+	if branches == nil {
+		branches = []models.Branch{}
+	}
+	c.JSON(200, branches)
+}
+
+// ListMyBranches returns the active branches the current user can work in (POS branch picker)
+func ListMyBranches(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	role := c.GetString("role")
+
+	rows, err := db.DB.Query(`SELECT `+branchColumns+`, COALESCE((SELECT ub.is_primary FROM user_branches ub WHERE ub.user_id=$2 AND ub.branch_id=b.id), false)
+        FROM branches b
+        WHERE b.tenant_id=$1 AND b.is_active AND (
+            $3 = 'owner'
+            OR EXISTS(SELECT 1 FROM user_branches ub WHERE ub.user_id=$2 AND ub.branch_id=b.id)
+            OR (b.is_default AND NOT EXISTS(SELECT 1 FROM user_branches ub WHERE ub.user_id=$2)))
+        ORDER BY b.is_default DESC, b.name`, tenantID, userID, role)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var branches []models.Branch
+	for rows.Next() {
+		var b models.Branch
+		rows.Scan(&b.ID, &b.Name, &b.Code, &b.Address, &b.Phone, &b.InvoicePrefix, &b.IsDefault, &b.IsActive, &b.CreatedAt, &b.IsPrimary)
+		branches = append(branches, b)
+	}
+	if branches == nil {
+		branches = []models.Branch{}
+	}
+	c.JSON(200, gin.H{"current_branch_id": c.GetString("branchID"), "branches": branches})
+}
+
+func CreateBranch(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.BranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	if isActive {
+		if err := checkBranchAllowance(tx, tenantID); err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	if branchCodeTaken(tx, tenantID, code, "") {
+		c.JSON(409, gin.H{"error": "Branch code already in use"})
+		return
+	}
+	if invoicePrefixTaken(tx, tenantID, req.InvoicePrefix, code, "") {
+		c.JSON(409, gin.H{"error": "Invoice prefix already in use"})
+		return
+	}
+
+	var id string
+	err = tx.QueryRow(`INSERT INTO branches (tenant_id, name, code, address, phone, invoice_prefix, is_active)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7) RETURNING id`,
+		tenantID, req.Name, code, req.Address, req.Phone, req.InvoicePrefix, isActive).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": id})
+}
+
+// UpdateBranch edits a branch. Deactivating keeps its stock and history; the
+// default branch cannot be deactivated and reactivating counts against the plan.
+func UpdateBranch(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.BranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var isDefault, wasActive bool
+	err = tx.QueryRow("SELECT is_default, is_active FROM branches WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).Scan(&isDefault, &wasActive)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Branch not found"})
+		return
+	}
+
+	isActive := wasActive
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	if isDefault && !isActive {
+		c.JSON(400, gin.H{"error": "The default branch cannot be deactivated"})
+		return
+	}
+	if isActive && !wasActive {
+		if err := checkBranchAllowance(tx, tenantID); err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	if branchCodeTaken(tx, tenantID, code, id) {
+		c.JSON(409, gin.H{"error": "Branch code already in use"})
+		return
+	}
+	if invoicePrefixTaken(tx, tenantID, req.InvoicePrefix, code, id) {
+		c.JSON(409, gin.H{"error": "Invoice prefix already in use"})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE branches SET name=$1, code=$2, address=$3, phone=$4, invoice_prefix=NULLIF($5, ''), is_active=$6, updated_at=now()
+        WHERE id=$7 AND tenant_id=$8`,
+		req.Name, code, req.Address, req.Phone, req.InvoicePrefix, isActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+// GetUserBranches lists the branches a tenant user is assigned to
+func GetUserBranches(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.Param("id")
+
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND tenant_id=$2)", userID, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT b.id, b.name, b.code, COALESCE(b.address, ''), COALESCE(b.phone, ''), COALESCE(b.invoice_prefix, ''), b.is_default, b.is_active, b.created_at, ub.is_primary
+        FROM user_branches ub JOIN branches b ON b.id = ub.branch_id
+        WHERE ub.user_id=$1 AND ub.tenant_id=$2 ORDER BY ub.is_primary DESC, b.name`, userID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var branches []models.Branch
+	for rows.Next() {
+		var b models.Branch
+		rows.Scan(&b.ID, &b.Name, &b.Code, &b.Address, &b.Phone, &b.InvoicePrefix, &b.IsDefault, &b.IsActive, &b.CreatedAt, &b.IsPrimary)
+		branches = append(branches, b)
+	}
+	if branches == nil {
+		branches = []models.Branch{}
+	}
+	c.JSON(200, branches)
+}
+
+// SetUserBranches replaces a user's branch assignments. An empty list leaves the
+// user on the default branch only.
+func SetUserBranches(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.Param("id")
+	var req models.UserBranchesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	primary := req.PrimaryBranchID
+	if primary == "" && len(req.BranchIDs) > 0 {
+		primary = req.BranchIDs[0]
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND tenant_id=$2)", userID, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_branches WHERE user_id=$1 AND tenant_id=$2", userID, tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	primarySet := false
+	for _, branchID := range req.BranchIDs {
+		// Tenant check via INSERT ... SELECT so a foreign branch id inserts nothing
+		res, err := tx.Exec(`INSERT INTO user_branches (tenant_id, user_id, branch_id, is_primary)
+            SELECT $1, $2, id, $3 FROM branches WHERE id::text=$4 AND tenant_id=$1
+            ON CONFLICT DO NOTHING`,
+			tenantID, userID, branchID == primary, branchID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(400, gin.H{"error": "Branch not found: " + branchID})
+			return
+		}
+		primarySet = primarySet || branchID == primary
+	}
+	if len(req.BranchIDs) > 0 && !primarySet {
+		c.JSON(400, gin.H{"error": "primary_branch_id must be one of branch_ids"})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Branches assigned"})
+}
+
+// checkBranchAllowance enforces the plan before one more branch becomes active:
+// any branch beyond the first needs the multi-branch feature, and the total must
+// stay within the branch limit. Locks the tenant row so concurrent creates count
+// consistently.
+func checkBranchAllowance(tx *sql.Tx, tenantID string) error {
+	limits, features, err := services.GetTenantEntitlements(tenantID)
+	if err != nil {
+		return err
+	}
+
+	var locked string
+	if err := tx.QueryRow("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID).Scan(&locked); err != nil {
+		return err
+	}
+	var active int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM branches WHERE tenant_id=$1 AND is_active", tenantID).Scan(&active); err != nil {
+		return err
+	}
+
+	if active >= 1 && !features.MultiBranch {
+		return &planRestrictionError{Code: "PLAN_FEATURE_REQUIRED", Feature: "multi_branch", msg: "Your plan does not include multiple branches"}
+	}
+	if active >= limits.BranchLimit {
+		return &planRestrictionError{Code: "PLAN_LIMIT_REACHED", Feature: "branch_limit", msg: fmt.Sprintf("Your plan allows %d active branches", limits.BranchLimit)}
+	}
+	return nil
+}
+
+func branchCodeTaken(tx *sql.Tx, tenantID, code, exceptID string) bool {
+	var taken bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM branches WHERE tenant_id=$1 AND code=$2 AND id::text <> $3)", tenantID, code, exceptID).Scan(&taken)
+	return taken
+}
+
+// invoicePrefixTaken reports whether a branch would number invoices like another
+// sequence. A branch's prefix is its own, else the tenant default + code + "-";
+// it must differ from the tenant default and from every other branch's.
+func invoicePrefixTaken(tx *sql.Tx, tenantID, prefix, code, exceptID string) bool {
+	var taken bool
+	tx.QueryRow(`WITH t AS (SELECT COALESCE(invoice_prefix_default, 'INV-') AS prefix FROM tenants WHERE id=$1),
+            p AS (SELECT COALESCE(NULLIF($2, ''), t.prefix || $3 || '-') AS prefix FROM t)
+        SELECT EXISTS(SELECT 1 FROM t, p WHERE t.prefix = p.prefix)
+            OR EXISTS(SELECT 1 FROM branches b, t, p WHERE b.tenant_id=$1 AND b.id::text <> $4
+                AND COALESCE(NULLIF(b.invoice_prefix, ''), t.prefix || b.code || '-') = p.prefix)`,
+		tenantID, prefix, code, exceptID).Scan(&taken)
+	return taken
+}
+
+// defaultPrefixTaken reports whether prefix, as the tenant's default, would number
+// like a branch: one with that prefix of its own, or one without whose generated
+// prefix (prefix + code + "-") another branch already uses
+func defaultPrefixTaken(tenantID, prefix string) bool {
+	var taken bool
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM branches WHERE tenant_id=$1 AND invoice_prefix = $2)
+        OR EXISTS(SELECT 1 FROM branches b JOIN branches o ON o.tenant_id = b.tenant_id AND o.id <> b.id
+            WHERE b.tenant_id=$1 AND NULLIF(b.invoice_prefix, '') IS NULL AND o.invoice_prefix = $2 || b.code || '-')`,
+		tenantID, prefix).Scan(&taken)
+	return taken
+}
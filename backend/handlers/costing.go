@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// moveCost is how a stock move was valued and what it leaves the item at
+type moveCost struct {
+	UnitCost   float64
+	Amount     float64 // Signed like the quantity change
+	StockValue float64 // Value of the stock after the move
+	AvgCost    float64
+}
+
+type costLayer struct {
+	id        string
+	remaining float64
+	unitCost  float64
+}
+
+// roundCost rounds unit costs and stock values to 4 decimals
+func roundCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+// costMove values a stock move. Incoming units open a cost layer at the given
+// cost (or the item's current cost); outgoing units consume layers oldest first and
+// are costed from those layers (FIFO) or at the running average (weighted_average).
+// The caller holds the inventory_stock row lock.
+func costMove(tx *sql.Tx, tenantID string, m stockMove, currentQty float64) (*moveCost, error) {
+	var method string
+	if err := tx.QueryRow("SELECT costing_method FROM tenants WHERE id=$1", tenantID).Scan(&method); err != nil {
+		return nil, err
+	}
+
+	// Stock never costed reads at the product's cost price
+	var value, avg float64
+	err := tx.QueryRow(`SELECT COALESCE(i.stock_value, i.quantity * COALESCE(p.cost_price, 0)), COALESCE(NULLIF(i.avg_cost, 0), p.cost_price, 0)
+        FROM inventory_stock i JOIN products p ON p.id = i.product_id
+        WHERE i.product_id=$1 AND i.tenant_id=$2 AND i.variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND i.branch_id=$4`,
+		m.ProductID, tenantID, m.VariantID, m.BranchID).Scan(&value, &avg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &moveCost{UnitCost: avg, StockValue: value, AvgCost: avg}
+	switch {
+	case m.QtyChange > 0:
+		if m.UnitCost != nil {
+			c.UnitCost = *m.UnitCost
+		}
+		c.UnitCost = roundCost(c.UnitCost)
+		c.Amount = roundCost(c.UnitCost * m.QtyChange)
+		c.StockValue = roundCost(value + c.Amount)
+		if currentQty+m.QtyChange > 0 { // Not when filling in negative stock
+			c.AvgCost = roundCost(c.StockValue / (currentQty + m.QtyChange))
+		}
+
+		_, err := tx.Exec(`INSERT INTO cost_layers (tenant_id, product_id, variant_id, branch_id, ref_type, ref_id, unit_cost, quantity, quantity_remaining)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, NULLIF($6, '')::uuid, $7, $8, $8)`,
+			tenantID, m.ProductID, m.VariantID, m.BranchID, m.RefType, m.RefID, c.UnitCost, m.QtyChange)
+		if err != nil {
+			return nil, err
+		}
+
+	case m.QtyChange < 0:
+		qty := -m.QtyChange
+		layerCost, err := consumeCostLayers(tx, tenantID, m, qty, avg)
+		if err != nil {
+			return nil, err
+		}
+
+		amount := avg * qty
+		if method == "fifo" {
+			amount = layerCost
+		}
+		if currentQty == qty {
+			amount = value // Selling out takes whatever value is left
+		}
+		amount = roundCost(amount)
+
+		c.Amount = -amount
+		c.UnitCost = roundCost(amount / qty)
+		c.StockValue = roundCost(value - amount)
+		if currentQty > qty {
+			c.AvgCost = roundCost(c.StockValue / (currentQty - qty))
+		}
+	}
+	return c, nil
+}
+
+// consumeCostLayers takes qty units off the open layers of an item, oldest first,
+// and returns their cost. Units not covered by a layer are costed at fallback.
+func consumeCostLayers(tx *sql.Tx, tenantID string, m stockMove, qty, fallback float64) (float64, error) {
+	rows, err := tx.Query(`SELECT id, quantity_remaining, unit_cost FROM cost_layers
+        WHERE tenant_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND branch_id=$4 AND quantity_remaining > 0
+        ORDER BY created_at, id FOR UPDATE`, tenantID, m.ProductID, m.VariantID, m.BranchID)
+	if err != nil {
+		return 0, err
+	}
+	var layers []costLayer
+	for rows.Next() {
+		var l costLayer
+		rows.Scan(&l.id, &l.remaining, &l.unitCost)
+		layers = append(layers, l)
+	}
+	rows.Close()
+
+	var cost float64
+	left := qty
+	for _, l := range layers {
+		if left <= 0 {
+			break
+		}
+		take := l.remaining
+		if take > left {
+			take = left
+		}
+		if _, err := tx.Exec("UPDATE cost_layers SET quantity_remaining = quantity_remaining - $1 WHERE id=$2", take, l.id); err != nil {
+			return 0, err
+		}
+		cost += take * l.unitCost
+		left = roundQty(left - take)
+	}
+	return cost + left*fallback, nil
+}
+
+// GetInventoryValuation values the stock held at the end of ?as_of (YYYY-MM-DD,
+// a day in the tenant's timezone; default now): today's value less every costed
+// movement booked after that moment. Optional ?branch_id limits it to one branch.
+func GetInventoryValuation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	branchID := c.Query("branch_id")
+
+	var method, timezone string
+	db.DB.QueryRow("SELECT costing_method, COALESCE(timezone, 'UTC') FROM tenants WHERE id=$1", tenantID).Scan(&method, &timezone)
+
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		day, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "as_of must be a date (YYYY-MM-DD)"})
+			return
+		}
+		asOf = day.AddDate(0, 0, 1)
+	}
+
+	rows, err := db.DB.Query(`SELECT i.product_id, COALESCE(i.variant_id::text, ''), i.branch_id, p.name, COALESCE(v.name, ''), b.name,
+            i.quantity - COALESCE(l.qty, 0),
+            COALESCE(i.stock_value, i.quantity * COALESCE(p.cost_price, 0)) - COALESCE(l.value, 0)
+        FROM inventory_stock i
+        JOIN products p ON p.id = i.product_id
+        JOIN branches b ON b.id = i.branch_id
+        LEFT JOIN product_variants v ON v.id = i.variant_id
+        LEFT JOIN LATERAL (
+            SELECT SUM(sl.qty_change) AS qty, SUM(COALESCE(sl.cost_amount, sl.qty_change * COALESCE(p.cost_price, 0))) AS value
+            FROM stock_ledger sl
+            WHERE sl.tenant_id = i.tenant_id AND sl.product_id = i.product_id AND sl.branch_id = i.branch_id
+              AND sl.variant_id IS NOT DISTINCT FROM i.variant_id AND sl.created_at >= $2
+        ) l ON true
+        WHERE i.tenant_id=$1 AND (NULLIF($3, '') IS NULL OR i.branch_id = NULLIF($3, '')::uuid)
+        ORDER BY p.name, v.name, b.name`, tenantID, asOf, branchID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	valuation := models.InventoryValuation{CostingMethod: method, AsOf: asOf, Items: []models.ValuationItem{}}
+	for rows.Next() {
+		var it models.ValuationItem
+		rows.Scan(&it.ProductID, &it.VariantID, &it.BranchID, &it.ProductName, &it.VariantName, &it.BranchName, &it.Quantity, &it.Value)
+		if it.Quantity == 0 && it.Value == 0 {
+			continue
+		}
+		it.Value = roundMoney(it.Value)
+		if it.Quantity > 0 {
+			it.UnitCost = roundCost(it.Value / it.Quantity)
+		}
+		valuation.TotalQuantity += it.Quantity
+		valuation.TotalValue += it.Value
+		valuation.Items = append(valuation.Items, it)
+	}
+	valuation.TotalQuantity = roundQty(valuation.TotalQuantity)
+	valuation.TotalValue = roundMoney(valuation.TotalValue)
+	c.JSON(200, valuation)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/lib/pq"
+)
+
+// customerSelect reads customers with their sales figures and points balance.
+// Void sales do not count; refunds come off the lifetime value.
+const customerSelect = `SELECT c.id, c.name, COALESCE(c.phone, ''), COALESCE(c.email, ''), COALESCE(c.address, ''), c.tags, COALESCE(c.notes, ''), c.is_active, c.created_at,
+        COUNT(s.id), COALESCE(SUM(s.final_amount), 0) - COALESCE((SELECT SUM(r.refund_amount) FROM sale_returns r JOIN sales rs ON rs.id = r.sale_id WHERE rs.customer_id = c.id), 0),
+        MAX(s.created_at),
+        COALESCE((SELECT SUM(lp.remaining) FROM loyalty_point_entries lp
+            WHERE lp.customer_id = c.id AND lp.remaining > 0 AND (lp.expires_at IS NULL OR lp.expires_at > now())), 0)
+    FROM customers c LEFT JOIN sales s ON s.customer_id = c.id AND COALESCE(s.status, 'completed') <> 'void'`
+
+func scanCustomer(row interface{ Scan(...interface{}) error }, cu *models.Customer) error {
+	err := row.Scan(&cu.ID, &cu.Name, &cu.Phone, &cu.Email, &cu.Address, pq.Array(&cu.Tags), &cu.Notes, &cu.IsActive, &cu.CreatedAt,
+		&cu.SalesCount, &cu.LifetimeValue, &cu.LastPurchaseAt, &cu.PointsBalance)
+	cu.LifetimeValue = roundMoney(cu.LifetimeValue)
+	if cu.Tags == nil {
+		cu.Tags = []string{}
+	}
+	return err
+}
+
+// ListCustomers searches active customers by ?search (name, phone or email) and
+// ?tag; ?include_inactive=true lists deactivated ones too
+func ListCustomers(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	search := strings.TrimSpace(c.Query("search"))
+	if search != "" {
+		search = "%" + search + "%"
+	}
+
+	rows, err := db.DB.Query(customerSelect+`
+        WHERE c.tenant_id=$1 AND (c.is_active OR $4)
+          AND (NULLIF($2, '') IS NULL OR c.name ILIKE $2 OR c.phone ILIKE $2 OR c.email ILIKE $2)
+          AND (NULLIF($3, '') IS NULL OR $3 = ANY(c.tags))
+        GROUP BY c.id ORDER BY c.name LIMIT 100`, tenantID, search, c.Query("tag"), c.Query("include_inactive") == "true")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	customers := []models.Customer{}
+	for rows.Next() {
+		var cu models.Customer
+		scanCustomer(rows, &cu)
+		customers = append(customers, cu)
+	}
+	c.JSON(200, customers)
+}
+
+func GetCustomer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var cu models.Customer
+	err := scanCustomer(db.DB.QueryRow(customerSelect+" WHERE c.id=$1 AND c.tenant_id=$2 GROUP BY c.id", c.Param("id"), tenantID), &cu)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	c.JSON(200, cu)
+}
+
+// CreateCustomer adds a customer; also used by the POS for quick creation at the
+// till. A phone number already on file returns 409 with that customer's id.
+func CreateCustomer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	normalizeCustomer(&req)
+
+	if existing := customerByPhone(tenantID, req.Phone, ""); existing != "" {
+		c.JSON(409, gin.H{"error": "A customer with this phone number already exists", "customer_id": existing})
+		return
+	}
+
+	var id string
+	err := db.DB.QueryRow(`INSERT INTO customers (tenant_id, name, phone, email, address, tags, notes)
+        VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7) RETURNING id`,
+		tenantID, req.Name, req.Phone, req.Email, req.Address, pq.Array(req.Tags), req.Notes).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id})
+}
+
+func UpdateCustomer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	normalizeCustomer(&req)
+
+	if existing := customerByPhone(tenantID, req.Phone, id); existing != "" {
+		c.JSON(409, gin.H{"error": "A customer with this phone number already exists", "customer_id": existing})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	res, err := db.DB.Exec(`UPDATE customers SET name=$1, phone=NULLIF($2, ''), email=NULLIF($3, ''), address=$4, tags=$5, notes=$6, is_active=$7, updated_at=now()
+        WHERE id=$8 AND tenant_id=$9`,
+		req.Name, req.Phone, req.Email, req.Address, pq.Array(req.Tags), req.Notes, isActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+// DeleteCustomer deactivates a customer; their sales keep pointing at them
+func DeleteCustomer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	res, err := db.DB.Exec("UPDATE customers SET is_active=false, updated_at=now() WHERE id=$1 AND tenant_id=$2", c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Customer deactivated"})
+}
+
+// GetCustomerSales returns a customer's purchase history, newest first
+func GetCustomerSales(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	customerID := c.Param("id")
+
+	var name string
+	if err := db.DB.QueryRow("SELECT name FROM customers WHERE id=$1 AND tenant_id=$2", customerID, tenantID).Scan(&name); err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT id, COALESCE(branch_id::text, ''), invoice_number, total_amount, discount_amount, COALESCE(tax_amount, 0), final_amount,
+            payment_method, COALESCE(status, 'completed'), COALESCE(payment_received, 0), COALESCE(change_due, 0), created_at
+        FROM sales WHERE customer_id=$1 AND tenant_id=$2 ORDER BY created_at DESC LIMIT 100`, customerID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	sales := []models.Sale{}
+	for rows.Next() {
+		s := models.Sale{CustomerID: customerID, CustomerName: name}
+		rows.Scan(&s.ID, &s.BranchID, &s.InvoiceNumber, &s.TotalAmount, &s.DiscountAmount, &s.TaxAmount, &s.FinalAmount,
+			&s.PaymentMethod, &s.Status, &s.PaymentReceived, &s.ChangeDue, &s.CreatedAt)
+		sales = append(sales, s)
+	}
+	c.JSON(200, sales)
+}
+
+func normalizeCustomer(req *models.CustomerRequest) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Phone = strings.TrimSpace(req.Phone)
+	req.Email = strings.TrimSpace(req.Email)
+	tags := []string{}
+	for _, t := range req.Tags {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	req.Tags = tags
+}
+
+// customerByPhone returns the customer (other than exceptID) holding a phone number, or ""
+func customerByPhone(tenantID, phone, exceptID string) string {
+	if phone == "" {
+		return ""
+	}
+	var id string
+	db.DB.QueryRow("SELECT id FROM customers WHERE tenant_id=$1 AND phone=$2 AND id::text <> $3", tenantID, phone, exceptID).Scan(&id)
+	return id
+}
+
+// checkSaleCustomer validates the customer a sale is made to. A deactivated
+// customer still qualifies, so sales rung up offline before that sync cleanly.
+func checkSaleCustomer(tx *sql.Tx, tenantID, customerID string) error {
+	if customerID == "" {
+		return nil
+	}
+	var exists bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE id::text=$1 AND tenant_id=$2)", customerID, tenantID).Scan(&exists)
+	if !exists {
+		return &validationError{"Customer " + customerID + " not found"}
+	}
+	return nil
+}
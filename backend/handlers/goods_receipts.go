@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// receiveGoods books a goods receipt (GRN) against a purchase: it raises the
+// received quantities of the lines, adds the stock at the branch and moves the
+// purchase to partially_received or received. No items receives everything
+// still outstanding. Quantities are in the unit the line was ordered in; stock
+// is added in stock units. Lot-tracked lines go into the lot named on the line.
+// The caller locks the purchase and commits.
+func receiveGoods(tx *sql.Tx, tenantID, userID, purchaseID, branchID string, items []models.GoodsReceiptItemRequest, notes string) (receiptID, receiptNumber, status string, err error) {
+	if len(items) == 0 {
+		rows, err := tx.Query("SELECT id, quantity - quantity_received FROM purchase_items WHERE purchase_id=$1 AND quantity > quantity_received ORDER BY id", purchaseID)
+		if err != nil {
+			return "", "", "", err
+		}
+		for rows.Next() {
+			var it models.GoodsReceiptItemRequest
+			rows.Scan(&it.PurchaseItemID, &it.Quantity)
+			items = append(items, it)
+		}
+		rows.Close()
+		if len(items) == 0 {
+			return "", "", "", &validationError{"Nothing left to receive on this purchase"}
+		}
+	}
+
+	// Serialise numbering per tenant
+	var locked string
+	if err := tx.QueryRow("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID).Scan(&locked); err != nil {
+		return "", "", "", err
+	}
+	var count int
+	tx.QueryRow("SELECT COUNT(*) FROM goods_receipts WHERE tenant_id=$1", tenantID).Scan(&count)
+	receiptNumber = fmt.Sprintf("GRN-%06d", count+1)
+
+	err = tx.QueryRow(`INSERT INTO goods_receipts (tenant_id, purchase_id, branch_id, receipt_number, notes, created_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid) RETURNING id`,
+		tenantID, purchaseID, branchID, receiptNumber, notes, userID).Scan(&receiptID)
+	if err != nil {
+		return "", "", "", fmt.Errorf("receipt insert failed: %w", err)
+	}
+
+	for _, it := range items {
+		var productID, variantID string
+		var outstanding, factor float64
+		var unitCost float64 // Per stock unit, net of tax, which is recovered rather than stocked
+		err := tx.QueryRow(`SELECT product_id, COALESCE(variant_id::text, ''), quantity - quantity_received, unit_factor,
+                COALESCE(net_amount / NULLIF(quantity, 0), cost_price, 0) / unit_factor
+            FROM purchase_items WHERE id::text=$1 AND purchase_id=$2 FOR UPDATE`, it.PurchaseItemID, purchaseID).
+			Scan(&productID, &variantID, &outstanding, &factor, &unitCost)
+		if err != nil {
+			return "", "", "", &validationError{"Purchase item not found: " + it.PurchaseItemID}
+		}
+		if it.Quantity > outstanding {
+			return "", "", "", &validationError{fmt.Sprintf("Cannot receive %g of item %s; %g outstanding", it.Quantity, it.PurchaseItemID, outstanding)}
+		}
+
+		stockQty := roundQty(it.Quantity * factor)
+		unitCost = roundCost(unitCost)
+
+		lotID, err := receiptLot(tx, tenantID, productID, variantID, branchID, it.LotNumber, it.ExpiryDate)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		_, err = tx.Exec(`INSERT INTO goods_receipt_items (tenant_id, receipt_id, purchase_item_id, product_id, variant_id, quantity, lot_id)
+            VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, NULLIF($7, '')::uuid)`,
+			tenantID, receiptID, it.PurchaseItemID, productID, variantID, it.Quantity, lotID)
+		if err != nil {
+			return "", "", "", fmt.Errorf("receipt item insert failed: %w", err)
+		}
+		if err := receiveSerials(tx, tenantID, userID, productID, variantID, branchID, receiptID, it.Serials, stockQty); err != nil {
+			return "", "", "", err
+		}
+		if _, err := tx.Exec("UPDATE purchase_items SET quantity_received = quantity_received + $1 WHERE id=$2", it.Quantity, it.PurchaseItemID); err != nil {
+			return "", "", "", err
+		}
+
+		err = updateStockHelper(tx, tenantID, stockMove{
+			ProductID: productID,
+			VariantID: variantID,
+			BranchID:  branchID,
+			QtyChange: stockQty,
+			RefType:   "goods_receipt",
+			RefID:     receiptID,
+			Note:      "Goods Received " + receiptNumber,
+			UnitCost:  &unitCost,
+			LotID:     lotID,
+		})
+		if err != nil {
+			return "", "", "", fmt.Errorf("stock update failed: %w", err)
+		}
+	}
+
+	var complete bool
+	tx.QueryRow("SELECT COALESCE(bool_and(quantity_received >= quantity), true) FROM purchase_items WHERE purchase_id=$1", purchaseID).Scan(&complete)
+	status = "partially_received"
+	if complete {
+		status = "received"
+	}
+	_, err = tx.Exec("UPDATE purchases SET status=$1, received_at = CASE WHEN $2 THEN now() ELSE received_at END WHERE id=$3", status, complete, purchaseID)
+	if err != nil {
+		return "", "", "", err
+	}
+	return receiptID, receiptNumber, status, nil
+}
+
+// ListPurchaseReceipts returns the receipt history of a purchase
+func ListPurchaseReceipts(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM purchases WHERE id=$1 AND tenant_id=$2)", id, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Purchase not found"})
+		return
+	}
+
+	receipts, err := loadPurchaseReceipts(tenantID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, receipts)
+}
+
+func loadPurchaseReceipts(tenantID, purchaseID string) ([]models.GoodsReceipt, error) {
+	rows, err := db.DB.Query(`SELECT id, purchase_id, COALESCE(branch_id::text, ''), receipt_number, COALESCE(notes, ''), created_at
+        FROM goods_receipts WHERE purchase_id=$1 AND tenant_id=$2 ORDER BY created_at, receipt_number`, purchaseID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	receipts := []models.GoodsReceipt{}
+	for rows.Next() {
+		var r models.GoodsReceipt
+		rows.Scan(&r.ID, &r.PurchaseID, &r.BranchID, &r.ReceiptNumber, &r.Notes, &r.CreatedAt)
+		receipts = append(receipts, r)
+	}
+	rows.Close()
+
+	for i := range receipts {
+		iRows, err := db.DB.Query(`SELECT gi.purchase_item_id, gi.product_id, COALESCE(gi.variant_id::text, ''), COALESCE(pi.name_snapshot, ''), gi.quantity,
+                COALESCE(gi.lot_id::text, ''), COALESCE(l.lot_number, '')
+            FROM goods_receipt_items gi JOIN purchase_items pi ON pi.id = gi.purchase_item_id
+            LEFT JOIN stock_lots l ON l.id = gi.lot_id
+            WHERE gi.receipt_id=$1 ORDER BY gi.id`, receipts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		receipts[i].Items = []models.GoodsReceiptItem{}
+		for iRows.Next() {
+			var it models.GoodsReceiptItem
+			iRows.Scan(&it.PurchaseItemID, &it.ProductID, &it.VariantID, &it.Name, &it.Quantity, &it.LotID, &it.LotNumber)
+			receipts[i].Items = append(receipts[i].Items, it)
+		}
+		iRows.Close()
+	}
+	return receipts, nil
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"fmt"
+
 	"github.com/gin-gonic/gin"
 	"github.com/insaansher/sherpos/backend/db"
 	"github.com/insaansher/sherpos/backend/models"
@@ -44,19 +46,69 @@ func CreateSupplier(c *gin.Context) {
 	c.JSON(201, gin.H{"message": "Created"})
 }
 
+// GetSupplierBalance returns what is owed to a supplier: purchases taken in
+// (anything not draft or cancelled) less the credit notes from returns
+func GetSupplierBalance(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	supplierID := c.Param("id")
+
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM suppliers WHERE id=$1 AND tenant_id=$2)", supplierID, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	b := models.SupplierBalance{SupplierID: supplierID}
+	db.DB.QueryRow("SELECT COALESCE(SUM(grand_total), 0) FROM purchases WHERE tenant_id=$1 AND supplier_id=$2 AND status NOT IN ('draft', 'cancelled')",
+		tenantID, supplierID).Scan(&b.TotalPurchases)
+
+	rows, err := db.DB.Query(`SELECT id, supplier_id, COALESCE(purchase_return_id::text, ''), amount, COALESCE(note, ''), created_at
+        FROM supplier_credit_notes WHERE tenant_id=$1 AND supplier_id=$2 ORDER BY created_at DESC`, tenantID, supplierID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	b.CreditNotes = []models.SupplierCreditNote{}
+	for rows.Next() {
+		var n models.SupplierCreditNote
+		rows.Scan(&n.ID, &n.SupplierID, &n.PurchaseReturnID, &n.Amount, &n.Note, &n.CreatedAt)
+		b.TotalCredits += n.Amount
+		b.CreditNotes = append(b.CreditNotes, n)
+	}
+	b.TotalCredits = roundMoney(b.TotalCredits)
+	b.BalanceOwed = roundMoney(b.TotalPurchases - b.TotalCredits)
+	c.JSON(200, b)
+}
+
 // Ledger
 func GetStockLedger(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
 	productID := c.Query("product_id")
+	variantID := c.Query("variant_id")
+	branchID := c.Query("branch_id")
 
-	query := `SELECT l.id, l.product_id, l.ref_type, l.ref_id, l.qty_change, l.qty_after, l.note, l.created_at, p.name 
+	query := `SELECT l.id, l.product_id, COALESCE(l.variant_id::text, ''), COALESCE(l.branch_id::text, ''), l.ref_type, l.ref_id, l.qty_change, l.qty_after, l.note, l.unit_cost, l.cost_amount, COALESCE(l.lot_id::text, ''), COALESCE(lt.lot_number, ''), l.created_at, p.name, COALESCE(v.name, ''), COALESCE(b.name, '')
               FROM stock_ledger l JOIN products p ON l.product_id = p.id 
+              LEFT JOIN product_variants v ON l.variant_id = v.id
+              LEFT JOIN branches b ON l.branch_id = b.id
+              LEFT JOIN stock_lots lt ON l.lot_id = lt.id
               WHERE l.tenant_id=$1`
 	args := []interface{}{tenantID}
 
 	if productID != "" {
-		query += " AND l.product_id=$2"
 		args = append(args, productID)
+		query += fmt.Sprintf(" AND l.product_id=$%d", len(args))
+	}
+	if variantID != "" {
+		args = append(args, variantID)
+		query += fmt.Sprintf(" AND l.variant_id=$%d", len(args))
+	}
+	if branchID != "" {
+		args = append(args, branchID)
+		query += fmt.Sprintf(" AND l.branch_id=$%d", len(args))
 	}
 	query += " ORDER BY l.created_at DESC LIMIT 100"
 
@@ -70,7 +122,7 @@ func GetStockLedger(c *gin.Context) {
 	var ledger []models.StockLedger
 	for rows.Next() {
 		var l models.StockLedger
-		rows.Scan(&l.ID, &l.ProductID, &l.RefType, &l.RefID, &l.QtyChange, &l.QtyAfter, &l.Note, &l.CreatedAt, &l.ProductName)
+		rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.BranchID, &l.RefType, &l.RefID, &l.QtyChange, &l.QtyAfter, &l.Note, &l.UnitCost, &l.CostAmount, &l.LotID, &l.LotNumber, &l.CreatedAt, &l.ProductName, &l.VariantName, &l.BranchName)
 		ledger = append(ledger, l)
 	}
 	if ledger == nil {
@@ -83,6 +135,7 @@ func GetStockLedger(c *gin.Context) {
 func CreateAdjustment(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
 	userID := c.GetString("userID")
+	branchID := c.GetString("branchID")
 	var req models.AdjustmentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -97,26 +150,45 @@ func CreateAdjustment(c *gin.Context) {
 	defer tx.Rollback()
 
 	var adjID string
-	err = tx.QueryRow(`INSERT INTO adjustments (tenant_id, reason, notes, created_by) VALUES ($1, $2, $3, $4) RETURNING id`,
-		tenantID, req.Reason, req.Notes, userID).Scan(&adjID)
+	err = tx.QueryRow(`INSERT INTO adjustments (tenant_id, branch_id, reason, notes, created_by) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		tenantID, branchID, req.Reason, req.Notes, userID).Scan(&adjID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
 	for _, item := range req.Items {
+		if err := checkStockItem(tx, tenantID, item.ProductID, item.VariantID, true); err != nil {
+			respondTxError(c, err)
+			return
+		}
+		// Serialized units move by serial
+		if err := adjustSerials(tx, tenantID, userID, item.ProductID, item.VariantID, branchID, adjID, item.Serials, item.QtyChange); err != nil {
+			respondTxError(c, err)
+			return
+		}
+
 		// Log item
-		_, err = tx.Exec(`INSERT INTO adjustment_items (adjustment_id, product_id, qty_change) VALUES ($1, $2, $3)`,
-			adjID, item.ProductID, item.QtyChange)
+		_, err = tx.Exec(`INSERT INTO adjustment_items (adjustment_id, product_id, variant_id, lot_id, qty_change) VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5)`,
+			adjID, item.ProductID, item.VariantID, item.LotID, item.QtyChange)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Item err"})
 			return
 		}
 
 		// Update Stock Helper
-		err = updateStockHelper(tx, tenantID, item.ProductID, item.QtyChange, "adjustment", adjID, req.Reason)
+		err = updateStockHelper(tx, tenantID, stockMove{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			BranchID:  branchID,
+			QtyChange: item.QtyChange,
+			RefType:   "adjustment",
+			RefID:     adjID,
+			Note:      req.Reason,
+			LotID:     item.LotID,
+		})
 		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondTxError(c, err)
 			return
 		}
 	}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// nextInvoiceNumber allocates the next invoice number for the tenant.
+// Must be called within the sale transaction: the sequence row stays locked until
+// commit, so concurrent checkouts queue up and a rolled back sale frees its number.
+// With per-branch numbering each branch has its own sequence and prefix.
+func nextInvoiceNumber(tx *sql.Tx, tenantID, branchID string) (string, error) {
+	var prefix, timezone string
+	var resetYearly, perBranch bool
+	err := tx.QueryRow("SELECT COALESCE(invoice_prefix_default, 'INV-'), COALESCE(timezone, 'UTC'), COALESCE(invoice_reset_yearly, true), COALESCE(invoice_per_branch, false) FROM tenants WHERE id=$1", tenantID).
+		Scan(&prefix, &timezone, &resetYearly, &perBranch)
+	if err != nil {
+		return "", err
+	}
+
+	seqBranch := "" // Tenant-wide sequence
+	if perBranch && branchID != "" {
+		// Branch prefix, else tenant prefix + branch code so numbers stay unique per tenant
+		var branchPrefix, code string
+		err := tx.QueryRow("SELECT COALESCE(invoice_prefix, ''), code FROM branches WHERE id=$1 AND tenant_id=$2", branchID, tenantID).Scan(&branchPrefix, &code)
+		if err != nil {
+			return "", err
+		}
+		if branchPrefix != "" {
+			prefix = branchPrefix
+		} else {
+			prefix = prefix + code + "-"
+		}
+		seqBranch = branchID
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC // Unknown zone names should not block checkout
+	}
+	year := time.Now().In(loc).Year()
+
+	periodYear := 0
+	if resetYearly {
+		periodYear = year
+	}
+
+	// 1. Lock Sequence Row (create on first use)
+	const lockQuery = `SELECT last_number FROM invoice_sequences
+        WHERE tenant_id=$1 AND branch_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid AND period_year=$3 FOR UPDATE`
+	var lastNumber int
+	err = tx.QueryRow(lockQuery, tenantID, seqBranch, periodYear).Scan(&lastNumber)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("INSERT INTO invoice_sequences (tenant_id, branch_id, period_year, last_number) VALUES ($1, NULLIF($2, '')::uuid, $3, 0) ON CONFLICT DO NOTHING",
+			tenantID, seqBranch, periodYear)
+		if err != nil {
+			return "", err
+		}
+		// Re-select so a concurrent first insert is waited on rather than duplicated
+		err = tx.QueryRow(lockQuery, tenantID, seqBranch, periodYear).Scan(&lastNumber)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// 2. Advance. The same prefix and year may have been numbered by another
+	// sequence row (e.g. before invoice_reset_yearly was switched): continue after
+	// the highest number issued there rather than reuse one.
+	next := lastNumber + 1
+	number := fmt.Sprintf("%s%d-", prefix, year)
+	var taken bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sales WHERE tenant_id=$1 AND invoice_number=$2)", tenantID, fmt.Sprintf("%s%06d", number, next)).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken {
+		var highest int
+		err := tx.QueryRow(`SELECT COALESCE(MAX(SUBSTRING(invoice_number FROM LENGTH($2) + 1)::int), 0) FROM sales
+            WHERE tenant_id=$1 AND LEFT(invoice_number, LENGTH($2)) = $2 AND SUBSTRING(invoice_number FROM LENGTH($2) + 1) ~ '^[0-9]+$'`,
+			tenantID, number).Scan(&highest)
+		if err != nil {
+			return "", err
+		}
+		next = highest + 1
+	}
+	_, err = tx.Exec(`UPDATE invoice_sequences SET last_number=$1, updated_at=now()
+        WHERE tenant_id=$2 AND branch_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND period_year=$4`,
+		next, tenantID, seqBranch, periodYear)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%06d", number, next), nil
+}
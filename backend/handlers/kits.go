@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/lib/pq"
+)
+
+// kitPart is a component stock item of a kit line, scaled to the line
+type kitPart struct {
+	ProductID string
+	VariantID string
+	Name      string
+	Qty       float64 // Per kit, then per line once scaled
+}
+
+// ListKitComponents returns what a kit is made of, with component stock
+// (all branches, or the one given by ?branch_id)
+func ListKitComponents(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+
+	var productType string
+	if err := db.DB.QueryRow("SELECT product_type FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&productType); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+
+	components, err := loadKitComponents(tenantID, productID, c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, components)
+}
+
+// SetKitComponents replaces the component list of a kit. Components are standard
+// products sold by the piece or by measure; kits do not nest and serialized
+// products cannot be components, as the POS has no serials to give for them.
+func SetKitComponents(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	var req models.SetKitComponentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var productType string
+	err = tx.QueryRow("SELECT product_type FROM products WHERE id=$1 AND tenant_id=$2 FOR UPDATE", productID, tenantID).Scan(&productType)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if productType != "kit" {
+		c.JSON(400, gin.H{"error": "Product is not a kit"})
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, comp := range req.Components {
+		if comp.ProductID == productID {
+			c.JSON(400, gin.H{"error": "A kit cannot contain itself"})
+			return
+		}
+		key := comp.ProductID + "/" + comp.VariantID
+		if seen[key] {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Component %s listed twice", key)})
+			return
+		}
+		seen[key] = true
+
+		if err := checkStockItem(tx, tenantID, comp.ProductID, comp.VariantID, false); err != nil {
+			respondTxError(c, err)
+			return
+		}
+		var compType string
+		var serialized bool
+		tx.QueryRow("SELECT product_type, track_serials FROM products WHERE id=$1", comp.ProductID).Scan(&compType, &serialized)
+		if compType != "standard" {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Product %s is a kit; kits cannot be components", comp.ProductID)})
+			return
+		}
+		if serialized {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Product %s is serialized and cannot be a kit component", comp.ProductID)})
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM product_kit_components WHERE kit_product_id=$1", productID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, comp := range req.Components {
+		_, err := tx.Exec(`INSERT INTO product_kit_components (tenant_id, kit_product_id, component_product_id, component_variant_id, quantity)
+            VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5)`,
+			tenantID, productID, comp.ProductID, comp.VariantID, roundQty(comp.Quantity))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+func loadKitComponents(tenantID, productID, branchID string) ([]models.KitComponent, error) {
+	rows, err := db.DB.Query(`SELECT k.component_product_id, COALESCE(k.component_variant_id::text, ''),
+            p.name || COALESCE(' - ' || v.name, ''), k.quantity, COALESCE(SUM(i.quantity), 0)
+        FROM product_kit_components k
+        JOIN products p ON p.id = k.component_product_id
+        LEFT JOIN product_variants v ON v.id = k.component_variant_id
+        LEFT JOIN inventory_stock i ON i.product_id = k.component_product_id AND i.variant_id IS NOT DISTINCT FROM k.component_variant_id
+            AND (NULLIF($3, '') IS NULL OR i.branch_id = NULLIF($3, '')::uuid)
+        WHERE k.kit_product_id=$1 AND k.tenant_id=$2
+        GROUP BY k.id, p.name, v.name ORDER BY p.name`, productID, tenantID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	components := []models.KitComponent{}
+	for rows.Next() {
+		var k models.KitComponent
+		if err := rows.Scan(&k.ProductID, &k.VariantID, &k.Name, &k.Quantity, &k.StockQuantity); err != nil {
+			return nil, err
+		}
+		components = append(components, k)
+	}
+	return components, rows.Err()
+}
+
+// kitParts returns the components of a kit product per kit, or nil when the
+// product is not a kit. A kit without components cannot be sold.
+func kitParts(tx *sql.Tx, tenantID, productID string) ([]kitPart, error) {
+	var productType string
+	if err := tx.QueryRow("SELECT product_type FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&productType); err != nil {
+		return nil, &productNotFoundError{ProductID: productID}
+	}
+	if productType != "kit" {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`SELECT k.component_product_id, COALESCE(k.component_variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), k.quantity
+        FROM product_kit_components k
+        JOIN products p ON p.id = k.component_product_id
+        LEFT JOIN product_variants v ON v.id = k.component_variant_id
+        WHERE k.kit_product_id=$1 AND k.tenant_id=$2 ORDER BY k.id`, productID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []kitPart{}
+	for rows.Next() {
+		var p kitPart
+		if err := rows.Scan(&p.ProductID, &p.VariantID, &p.Name, &p.Qty); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, &validationError{fmt.Sprintf("Kit %s has no components", productID)}
+	}
+	return parts, nil
+}
+
+// checkProductType validates the type of a product being saved. Kits take no
+// stock of their own, so they cannot track lots or serials, have variants or
+// hold stock; a product that is a component of a kit cannot become one.
+func checkProductType(tenantID, productID string, p *models.Product) string {
+	if p.ProductType == "" {
+		p.ProductType = "standard"
+	}
+	if p.ProductType != "standard" && p.ProductType != "kit" {
+		return "product_type must be standard or kit"
+	}
+	if p.ProductType != "kit" {
+		return ""
+	}
+	if p.TrackLots || p.TrackSerials {
+		return "Kits cannot track lots or serials; their components do"
+	}
+	if productID == "" {
+		return ""
+	}
+
+	var hasVariants, hasStock, isComponent bool
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM product_variants WHERE product_id=$1),
+            EXISTS(SELECT 1 FROM inventory_stock WHERE product_id=$1 AND quantity <> 0),
+            EXISTS(SELECT 1 FROM product_kit_components WHERE component_product_id=$1)`, productID).
+		Scan(&hasVariants, &hasStock, &isComponent)
+	switch {
+	case hasVariants:
+		return "A product with variants cannot become a kit"
+	case hasStock:
+		return "A product holding stock cannot become a kit"
+	case isComponent:
+		return "This product is a component of a kit and cannot become one"
+	}
+	return ""
+}
+
+// attachKitStock sets the stock of the listed kits to the number of whole kits
+// their components at the branch make up
+func attachKitStock(products []models.Product, tenantID, branchID string) error {
+	var ids []string
+	byID := map[string]int{}
+	for i, p := range products {
+		if p.ProductType == "kit" {
+			ids = append(ids, p.ID)
+			byID[p.ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.DB.Query(`SELECT k.kit_product_id, MIN(COALESCE(i.quantity, 0) / k.quantity)
+        FROM product_kit_components k
+        LEFT JOIN inventory_stock i ON i.product_id = k.component_product_id AND i.variant_id IS NOT DISTINCT FROM k.component_variant_id AND i.branch_id = $3
+        WHERE k.tenant_id=$1 AND k.kit_product_id = ANY($2)
+        GROUP BY k.kit_product_id`, tenantID, pq.Array(ids), branchID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var kits float64
+		if err := rows.Scan(&id, &kits); err != nil {
+			return err
+		}
+		products[byID[id]].StockQuantity = math.Max(math.Floor(kits), 0)
+	}
+	return rows.Err()
+}
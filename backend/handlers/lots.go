@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// lotPart is the share of an outgoing move taken from one lot ("" = stock held outside lots)
+type lotPart struct {
+	lotID string
+	qty   float64
+}
+
+// allocateLots splits an outgoing move of a lot-tracked product first-expiry-first-out.
+// Stock held outside any lot predates tracking and goes first; sales and transfers
+// skip expired lots (expired goods leave by write-off or adjustment).
+// Returns nil when the product does not track lots.
+func allocateLots(tx *sql.Tx, tenantID string, m stockMove) ([]lotPart, error) {
+	var tracked bool
+	var name string
+	if err := tx.QueryRow("SELECT track_lots, name FROM products WHERE id=$1 AND tenant_id=$2", m.ProductID, tenantID).Scan(&tracked, &name); err != nil {
+		return nil, err
+	}
+	if !tracked {
+		return nil, nil
+	}
+
+	onHand, _, err := lockStockQty(tx, tenantID, m.ProductID, m.VariantID, m.BranchID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id, quantity, COALESCE(expiry_date < $5::date, false) FROM stock_lots
+        WHERE tenant_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND branch_id=$4 AND quantity > 0
+        ORDER BY expiry_date NULLS LAST, created_at FOR UPDATE`, tenantID, m.ProductID, m.VariantID, m.BranchID, tenantToday(tx, tenantID))
+	if err != nil {
+		return nil, err
+	}
+	type lot struct {
+		id      string
+		qty     float64
+		expired bool
+	}
+	var lots []lot
+	var inLots float64
+	for rows.Next() {
+		var l lot
+		rows.Scan(&l.id, &l.qty, &l.expired)
+		lots = append(lots, l)
+		inLots = roundQty(inLots + l.qty)
+	}
+	rows.Close()
+
+	left := -m.QtyChange
+	var parts []lotPart
+	if loose := roundQty(onHand - inLots); loose > 0 {
+		take := loose
+		if take > left {
+			take = left
+		}
+		parts = append(parts, lotPart{qty: take})
+		left = roundQty(left - take)
+	}
+	for _, l := range lots {
+		if left <= 0 {
+			break
+		}
+		if l.expired && (m.RefType == "sale" || m.RefType == "transfer_out") {
+			continue
+		}
+		take := l.qty
+		if take > left {
+			take = left
+		}
+		parts = append(parts, lotPart{lotID: l.id, qty: take})
+		left = roundQty(left - take)
+	}
+
+	if left > 0 && m.AllowNegative {
+		parts = append(parts, lotPart{qty: left}) // The shortfall goes below zero outside any lot
+		left = 0
+	}
+	if left > 0 {
+		available := roundQty(-m.QtyChange - left)
+		return nil, &insufficientStockError{ProductID: m.ProductID, VariantID: m.VariantID, Name: name + " (unexpired lots)", Available: available, Requested: -m.QtyChange}
+	}
+	return parts, nil
+}
+
+// receiptLot finds or opens the lot a receipt line goes into. Lot-tracked
+// products must name one; for other products an empty lot number means none.
+func receiptLot(tx *sql.Tx, tenantID, productID, variantID, branchID, lotNumber, expiryDate string) (string, error) {
+	var tracked bool
+	if err := tx.QueryRow("SELECT track_lots FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&tracked); err != nil {
+		return "", &productNotFoundError{ProductID: productID}
+	}
+	if lotNumber == "" {
+		if tracked {
+			return "", &validationError{fmt.Sprintf("Product %s tracks lots; lot_number is required", productID)}
+		}
+		return "", nil
+	}
+
+	var expiry interface{}
+	if expiryDate != "" {
+		d, err := time.Parse("2006-01-02", expiryDate)
+		if err != nil {
+			return "", &validationError{"expiry_date must be a date (YYYY-MM-DD)"}
+		}
+		expiry = d
+	}
+
+	var lotID string
+	err := tx.QueryRow(`INSERT INTO stock_lots (tenant_id, product_id, variant_id, branch_id, lot_number, expiry_date)
+        VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6)
+        ON CONFLICT (branch_id, product_id, COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid), lot_number)
+        DO UPDATE SET expiry_date = COALESCE(EXCLUDED.expiry_date, stock_lots.expiry_date), updated_at = now()
+        RETURNING id`,
+		tenantID, productID, variantID, branchID, lotNumber, expiry).Scan(&lotID)
+	return lotID, err
+}
+
+// ListLots returns lots holding stock, by ?product_id and ?branch_id
+func ListLots(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	lots, err := queryLots(tenantToday(db.DB, tenantID), `WHERE l.tenant_id=$1 AND l.quantity > 0
+          AND (NULLIF($2, '') IS NULL OR l.product_id = NULLIF($2, '')::uuid)
+          AND (NULLIF($3, '') IS NULL OR l.branch_id = NULLIF($3, '')::uuid)`,
+		tenantID, c.Query("product_id"), c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, lots)
+}
+
+// GetExpiringLots lists lots with stock that expire within ?days (default 30),
+// already expired ones included. Optional ?branch_id.
+func GetExpiringLots(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	days := 30
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(400, gin.H{"error": "days must be a positive number"})
+			return
+		}
+		days = n
+	}
+
+	lots, err := queryLots(tenantToday(db.DB, tenantID), `WHERE l.tenant_id=$1 AND l.quantity > 0 AND l.expiry_date IS NOT NULL
+          AND l.expiry_date <= $4::date + $2::int
+          AND (NULLIF($3, '') IS NULL OR l.branch_id = NULLIF($3, '')::uuid)`,
+		tenantID, days, c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, lots)
+}
+
+// queryLots lists the lots matching where (over stock_lots l), counting days to
+// expiry from today (the tenant's date), which goes in as the last argument
+func queryLots(today, where string, args ...interface{}) ([]models.StockLot, error) {
+	args = append(args, today)
+	rows, err := db.DB.Query(`SELECT l.id, l.product_id, COALESCE(l.variant_id::text, ''), l.branch_id, p.name, COALESCE(v.name, ''), b.name,
+            l.lot_number, l.expiry_date, l.quantity, COALESCE(l.expiry_date - $`+strconv.Itoa(len(args))+`::date, 0),
+            l.quantity * COALESCE(NULLIF(i.avg_cost, 0), p.cost_price, 0)
+        FROM stock_lots l
+        JOIN products p ON p.id = l.product_id
+        JOIN branches b ON b.id = l.branch_id
+        LEFT JOIN product_variants v ON v.id = l.variant_id
+        LEFT JOIN inventory_stock i ON i.product_id = l.product_id AND i.branch_id = l.branch_id AND i.variant_id IS NOT DISTINCT FROM l.variant_id
+        `+where+`
+        ORDER BY l.expiry_date NULLS LAST, p.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []models.StockLot{}
+	for rows.Next() {
+		var l models.StockLot
+		rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.BranchID, &l.ProductName, &l.VariantName, &l.BranchName,
+			&l.LotNumber, &l.ExpiryDate, &l.Quantity, &l.DaysToExpiry, &l.Value)
+		l.Value = roundMoney(l.Value)
+		l.Expired = l.ExpiryDate != nil && l.DaysToExpiry < 0
+		lots = append(lots, l)
+	}
+	return lots, rows.Err()
+}
+
+// WriteOffLot removes what is left of an expired lot with an 'expired' adjustment
+func WriteOffLot(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.WriteOffLotRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var productID, variantID, branchID, lotNumber string
+	var qty float64
+	var expired bool
+	err = tx.QueryRow(`SELECT product_id, COALESCE(variant_id::text, ''), branch_id, lot_number, quantity, COALESCE(expiry_date < $3::date, false)
+        FROM stock_lots WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, id, tenantID, tenantToday(tx, tenantID)).
+		Scan(&productID, &variantID, &branchID, &lotNumber, &qty, &expired)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Lot not found"})
+		return
+	}
+	if !expired {
+		c.JSON(400, gin.H{"error": "Lot has not expired"})
+		return
+	}
+	if qty == 0 {
+		c.JSON(400, gin.H{"error": "Lot holds no stock"})
+		return
+	}
+	if !branchUsable(c, branchID) {
+		return
+	}
+	// Serialized units are written off by serial, through an adjustment
+	if _, err := checkSerials(tx, tenantID, productID, nil, qty); err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	notes := req.Notes
+	if notes == "" {
+		notes = "Expired lot " + lotNumber
+	}
+	var adjID string
+	err = tx.QueryRow(`INSERT INTO adjustments (tenant_id, branch_id, reason, notes, created_by) VALUES ($1, $2, 'expired', $3, $4) RETURNING id`,
+		tenantID, branchID, notes, userID).Scan(&adjID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO adjustment_items (adjustment_id, product_id, variant_id, lot_id, qty_change) VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5)`,
+		adjID, productID, variantID, id, -qty)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Item err"})
+		return
+	}
+
+	err = updateStockHelper(tx, tenantID, stockMove{
+		ProductID: productID,
+		VariantID: variantID,
+		BranchID:  branchID,
+		QtyChange: -qty,
+		RefType:   "adjustment",
+		RefID:     adjID,
+		Note:      notes,
+		LotID:     id,
+	})
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"message": "Lot written off", "adjustment_id": adjID, "quantity": qty})
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// loyaltyRules is a tenant's loyalty program as applied to sales
+type loyaltyRules struct {
+	PointsPerUnit float64
+	PointValue    float64
+	MinRedeem     int
+	ExpiryDays    sql.NullInt64
+	Multipliers   map[string]float64 // By product category
+}
+
+// expiresAt is when points earned at t run out, nil when they never do
+func (r *loyaltyRules) expiresAt(t time.Time) *time.Time {
+	if !r.ExpiryDays.Valid {
+		return nil
+	}
+	e := t.AddDate(0, 0, int(r.ExpiryDays.Int64))
+	return &e
+}
+
+// loyaltyProgram reads the tenant's loyalty rules; nil when the program is
+// switched off. A program left on after the plan lost reward points is a
+// planRestrictionError.
+func loyaltyProgram(tx *sql.Tx, tenantID string) (*loyaltyRules, error) {
+	var enabled bool
+	r := &loyaltyRules{Multipliers: map[string]float64{}}
+	err := tx.QueryRow(`SELECT loyalty_enabled, loyalty_points_per_unit, loyalty_point_value, loyalty_min_redeem, loyalty_expiry_days
+        FROM tenants WHERE id=$1`, tenantID).Scan(&enabled, &r.PointsPerUnit, &r.PointValue, &r.MinRedeem, &r.ExpiryDays)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	_, features, err := services.GetTenantEntitlements(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !features.RewardPoints {
+		return nil, &planRestrictionError{Code: "PLAN_FEATURE_REQUIRED", Feature: "reward_points", msg: "Your plan does not include reward points"}
+	}
+
+	rows, err := tx.Query("SELECT category, multiplier FROM loyalty_category_multipliers WHERE tenant_id=$1", tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var m float64
+		if err := rows.Scan(&category, &m); err != nil {
+			return nil, err
+		}
+		r.Multipliers[category] = m
+	}
+	return r, rows.Err()
+}
+
+// applySaleLoyalty redeems the sale's points tenders from the customer's balance
+// and credits the points the rest of the sale earns. Points pay at the program's
+// point value, rounded up to whole points; the reference of each points tender
+// records how many it took. Must run before the tenders are inserted.
+func applySaleLoyalty(tx *sql.Tx, tenantID, userID, saleID, customerID, invoiceNum string, lines []saleLine, finalAmount float64, tenders *saleTenders, at time.Time) (earned, redeemed int, err error) {
+	var pointsPaid float64
+	for _, p := range tenders.Payments {
+		if p.Method == "points" {
+			pointsPaid += p.Amount
+		}
+	}
+	pointsPaid = roundMoney(pointsPaid)
+
+	if customerID == "" {
+		if pointsPaid > 0 {
+			return 0, 0, &validationError{"Paying with points needs a customer on the sale"}
+		}
+		return 0, 0, nil
+	}
+
+	rules, err := loyaltyProgram(tx, tenantID)
+	if err != nil {
+		var restricted *planRestrictionError
+		if pointsPaid == 0 && errors.As(err, &restricted) {
+			return 0, 0, nil // Nothing to redeem; the sale just earns nothing
+		}
+		return 0, 0, err
+	}
+	if rules == nil {
+		if pointsPaid > 0 {
+			return 0, 0, &validationError{"The loyalty program is not enabled"}
+		}
+		return 0, 0, nil
+	}
+
+	// 1. Redeem
+	if pointsPaid > 0 {
+		for i, p := range tenders.Payments {
+			if p.Method != "points" {
+				continue
+			}
+			n := int(math.Ceil(roundCost(p.Amount / rules.PointValue)))
+			tenders.Payments[i].Reference = fmt.Sprintf("%d points", n)
+			redeemed += n
+		}
+		if redeemed < rules.MinRedeem {
+			return 0, 0, &validationError{fmt.Sprintf("At least %d points must be redeemed at once", rules.MinRedeem)}
+		}
+		if _, err := spendPoints(tx, tenantID, customerID, redeemed, "", false); err != nil {
+			return 0, 0, err
+		}
+		_, err := tx.Exec(`INSERT INTO loyalty_point_entries (tenant_id, customer_id, entry_type, points, sale_id, note, created_by, created_at)
+            VALUES ($1, $2, 'redeem', $3, $4, $5, $6, $7)`,
+			tenantID, customerID, -redeemed, saleID, "Redeemed on "+invoiceNum, userID, at)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// 2. Earn on the net (pre-tax) line amounts, weighted by category, for the
+	// share of the sale not paid with points
+	if finalAmount <= 0 {
+		return 0, redeemed, nil
+	}
+	var base float64
+	for _, l := range lines {
+		m, ok := rules.Multipliers[l.category]
+		if !ok {
+			m = 1
+		}
+		base += l.NetAmount * m
+	}
+	base = base * (finalAmount - pointsPaid) / finalAmount
+	earned = int(math.Floor(roundCost(base * rules.PointsPerUnit)))
+	if earned <= 0 {
+		return 0, redeemed, nil
+	}
+	_, err = tx.Exec(`INSERT INTO loyalty_point_entries (tenant_id, customer_id, entry_type, points, remaining, sale_id, expires_at, note, created_by, created_at)
+        VALUES ($1, $2, 'earn', $3, $3, $4, $5, $6, $7, $8)`,
+		tenantID, customerID, earned, saleID, rules.expiresAt(at), "Earned on "+invoiceNum, userID, at)
+	if err != nil {
+		return 0, 0, err
+	}
+	return earned, redeemed, nil
+}
+
+// spendPoints takes points off a customer's unexpired entries, those expiring
+// first first (entries of preferSaleID before any). Unless partial, a balance
+// short of points is a validationError and nothing is taken. Returns the points taken.
+func spendPoints(tx *sql.Tx, tenantID, customerID string, points int, preferSaleID string, partial bool) (int, error) {
+	rows, err := tx.Query(`SELECT id, remaining FROM loyalty_point_entries
+        WHERE customer_id=$1 AND tenant_id=$2 AND remaining > 0 AND (expires_at IS NULL OR expires_at > now())
+        ORDER BY (sale_id = NULLIF($3, '')::uuid) IS TRUE DESC, expires_at NULLS LAST, created_at
+        FOR UPDATE`, customerID, tenantID, preferSaleID)
+	if err != nil {
+		return 0, err
+	}
+	type open struct {
+		id        string
+		remaining int
+	}
+	var entries []open
+	balance := 0
+	for rows.Next() {
+		var e open
+		if err := rows.Scan(&e.id, &e.remaining); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+		balance += e.remaining
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if balance < points && !partial {
+		return 0, &validationError{fmt.Sprintf("Customer has %d points; %d needed", balance, points)}
+	}
+
+	taken := 0
+	for _, e := range entries {
+		if taken >= points {
+			break
+		}
+		take := e.remaining
+		if take > points-taken {
+			take = points - taken
+		}
+		if _, err := tx.Exec("UPDATE loyalty_point_entries SET remaining = remaining - $1 WHERE id=$2", take, e.id); err != nil {
+			return 0, err
+		}
+		taken += take
+	}
+	return taken, nil
+}
+
+// reverseSalePoints takes back the points a sale earned in the share of it
+// refunded so far (all of them once it is fully refunded). Points the customer
+// has already spent cannot be taken back; the reversal entry notes the shortfall.
+func reverseSalePoints(tx *sql.Tx, tenantID, userID, saleID, returnID string, fullyRefunded bool) error {
+	var customerID string
+	var earned int
+	err := tx.QueryRow("SELECT customer_id, points FROM loyalty_point_entries WHERE sale_id=$1 AND tenant_id=$2 AND entry_type='earn'", saleID, tenantID).
+		Scan(&customerID, &earned)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var finalAmount, refunded float64
+	var reversed int
+	err = tx.QueryRow(`SELECT s.final_amount,
+            COALESCE((SELECT SUM(refund_amount) FROM sale_returns WHERE sale_id = s.id), 0),
+            COALESCE((SELECT -SUM(points) FROM loyalty_point_entries WHERE sale_id = s.id AND entry_type = 'reverse'), 0)
+        FROM sales s WHERE s.id=$1`, saleID).Scan(&finalAmount, &refunded, &reversed)
+	if err != nil {
+		return err
+	}
+
+	target := earned
+	if !fullyRefunded && finalAmount > 0 {
+		target = int(math.Round(float64(earned) * math.Min(refunded/finalAmount, 1)))
+	}
+	points := target - reversed
+	if points <= 0 {
+		return nil
+	}
+
+	taken, err := spendPoints(tx, tenantID, customerID, points, saleID, true)
+	if err != nil {
+		return err
+	}
+	note := "Reversed on return"
+	if taken < points {
+		note += fmt.Sprintf("; %d points were already spent", points-taken)
+	}
+	_, err = tx.Exec(`INSERT INTO loyalty_point_entries (tenant_id, customer_id, entry_type, points, sale_id, sale_return_id, note, created_by)
+        VALUES ($1, $2, 'reverse', $3, $4, $5, $6, $7)`,
+		tenantID, customerID, -points, saleID, returnID, note, userID)
+	return err
+}
+
+func GetLoyaltySettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var s models.LoyaltySettings
+	var expiry sql.NullInt64
+	err := db.DB.QueryRow(`SELECT loyalty_enabled, loyalty_points_per_unit, loyalty_point_value, loyalty_min_redeem, loyalty_expiry_days
+        FROM tenants WHERE id=$1`, tenantID).Scan(&s.Enabled, &s.PointsPerUnit, &s.PointValue, &s.MinRedeemPoints, &expiry)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Tenant not found"})
+		return
+	}
+	if expiry.Valid {
+		days := int(expiry.Int64)
+		s.ExpiryDays = &days
+	}
+
+	s.CategoryMultipliers = map[string]float64{}
+	rows, err := db.DB.Query("SELECT category, multiplier FROM loyalty_category_multipliers WHERE tenant_id=$1", tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var m float64
+		rows.Scan(&category, &m)
+		s.CategoryMultipliers[category] = m
+	}
+	c.JSON(200, s)
+}
+
+// UpdateLoyaltySettings sets the earn and redemption rules and replaces the
+// category multipliers. Points already earned keep their expiry date.
+func UpdateLoyaltySettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.LoyaltySettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	for category, m := range req.CategoryMultipliers {
+		if strings.TrimSpace(category) == "" || m < 0 {
+			c.JSON(400, gin.H{"error": "Category multipliers need a category and a multiplier of 0 or more"})
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE tenants SET loyalty_enabled=$1, loyalty_points_per_unit=$2, loyalty_point_value=$3, loyalty_min_redeem=$4, loyalty_expiry_days=$5, updated_at=now()
+        WHERE id=$6`, req.Enabled, req.PointsPerUnit, req.PointValue, req.MinRedeemPoints, req.ExpiryDays, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM loyalty_category_multipliers WHERE tenant_id=$1", tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for category, m := range req.CategoryMultipliers {
+		_, err := tx.Exec("INSERT INTO loyalty_category_multipliers (tenant_id, category, multiplier) VALUES ($1, $2, $3)",
+			tenantID, strings.TrimSpace(category), m)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Loyalty settings updated"})
+}
+
+// GetCustomerPoints returns a customer's points balance and ledger, newest first
+func GetCustomerPoints(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	customerID := c.Param("id")
+
+	res := models.CustomerPoints{CustomerID: customerID, Entries: []models.LoyaltyPointEntry{}}
+	var pointValue float64
+	err := db.DB.QueryRow(`SELECT COALESCE((SELECT SUM(remaining) FROM loyalty_point_entries
+                WHERE customer_id = c.id AND remaining > 0 AND (expires_at IS NULL OR expires_at > now())), 0), t.loyalty_point_value
+        FROM customers c JOIN tenants t ON t.id = c.tenant_id
+        WHERE c.id=$1 AND c.tenant_id=$2`, customerID, tenantID).Scan(&res.Balance, &pointValue)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	res.BalanceValue = roundMoney(float64(res.Balance) * pointValue)
+
+	rows, err := db.DB.Query(`SELECT e.id, e.entry_type, e.points, e.remaining, COALESCE(e.sale_id::text, ''), COALESCE(s.invoice_number, ''),
+            COALESCE(e.sale_return_id::text, ''), e.expires_at, COALESCE(e.note, ''), e.created_at
+        FROM loyalty_point_entries e
+        LEFT JOIN sales s ON s.id = e.sale_id
+        WHERE e.customer_id=$1 AND e.tenant_id=$2
+        ORDER BY e.created_at DESC LIMIT 200`, customerID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.LoyaltyPointEntry
+		rows.Scan(&e.ID, &e.EntryType, &e.Points, &e.Remaining, &e.SaleID, &e.InvoiceNumber, &e.SaleReturnID, &e.ExpiresAt, &e.Note, &e.CreatedAt)
+		res.Entries = append(res.Entries, e)
+	}
+	c.JSON(200, res)
+}
+
+// AdjustCustomerPoints credits or debits points by hand. Credits expire like
+// earned points; a debit cannot take the balance below zero.
+func AdjustCustomerPoints(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	customerID := c.Param("id")
+	var req models.PointsAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE id::text=$1 AND tenant_id=$2)", customerID, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	rules, err := loyaltyProgram(tx, tenantID)
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+	if rules == nil {
+		c.JSON(400, gin.H{"error": "The loyalty program is not enabled"})
+		return
+	}
+
+	remaining := 0
+	var expiresAt *time.Time
+	if req.Points > 0 {
+		remaining = req.Points
+		expiresAt = rules.expiresAt(time.Now())
+	} else if _, err := spendPoints(tx, tenantID, customerID, -req.Points, "", false); err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	var id string
+	err = tx.QueryRow(`INSERT INTO loyalty_point_entries (tenant_id, customer_id, entry_type, points, remaining, expires_at, note, created_by)
+        VALUES ($1, $2, 'adjust', $3, $4, $5, $6, $7) RETURNING id`,
+		tenantID, customerID, req.Points, remaining, expiresAt, req.Note, userID).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": id, "message": "Points adjusted"})
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// Manufacturing turns ingredients into finished goods. A BOM lists what one batch
+// takes; a production order scales it to the quantity made and, on completion,
+// consumes the ingredients (production_out) and books the output (production_in)
+// at the cost of what was consumed, all in one transaction.
+
+// checkManufactured validates a finished good or ingredient of a BOM. Both are
+// standard stock items counted by quantity: kits hold no stock and serialized
+// units cannot be consumed or produced without their serials.
+func checkManufactured(tx *sql.Tx, tenantID, productID, variantID string) error {
+	if err := checkStockItem(tx, tenantID, productID, variantID, false); err != nil {
+		return err
+	}
+	var productType string
+	var serialized bool
+	if err := tx.QueryRow("SELECT product_type, track_serials FROM products WHERE id=$1", productID).Scan(&productType, &serialized); err != nil {
+		return &productNotFoundError{ProductID: productID}
+	}
+	if productType != "standard" {
+		return &validationError{fmt.Sprintf("Product %s is a kit and cannot be manufactured or used as an ingredient", productID)}
+	}
+	if serialized {
+		return &validationError{fmt.Sprintf("Product %s is serialized and cannot be manufactured or used as an ingredient", productID)}
+	}
+	return nil
+}
+
+// grossQty is an ingredient quantity with its waste on top
+func grossQty(qty, wastePct float64) float64 {
+	return roundQty(qty * (1 + wastePct/100))
+}
+
+func ListBoms(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT b.id, b.product_id, COALESCE(b.variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), b.name, b.output_qty, b.is_active, COALESCE(b.notes, ''), b.created_at
+        FROM boms b JOIN products p ON p.id = b.product_id
+        LEFT JOIN product_variants v ON v.id = b.variant_id
+        WHERE b.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR b.product_id = NULLIF($2, '')::uuid)
+        ORDER BY p.name, b.name`, tenantID, c.Query("product_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	boms := []models.Bom{}
+	for rows.Next() {
+		var b models.Bom
+		rows.Scan(&b.ID, &b.ProductID, &b.VariantID, &b.ProductName, &b.Name, &b.OutputQty, &b.IsActive, &b.Notes, &b.CreatedAt)
+		boms = append(boms, b)
+	}
+	c.JSON(200, boms)
+}
+
+// GetBom returns a BOM with its ingredients and the cost rolled up from them:
+// each at its average cost over all branches, or its cost price when out of stock
+func GetBom(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var b models.Bom
+	err := db.DB.QueryRow(`SELECT b.id, b.product_id, COALESCE(b.variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), b.name, b.output_qty, b.is_active, COALESCE(b.notes, ''), b.created_at
+        FROM boms b JOIN products p ON p.id = b.product_id
+        LEFT JOIN product_variants v ON v.id = b.variant_id
+        WHERE b.id=$1 AND b.tenant_id=$2`, id, tenantID).
+		Scan(&b.ID, &b.ProductID, &b.VariantID, &b.ProductName, &b.Name, &b.OutputQty, &b.IsActive, &b.Notes, &b.CreatedAt)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "BOM not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT bi.id, bi.product_id, COALESCE(bi.variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), bi.quantity, bi.waste_pct,
+            COALESCE((SELECT SUM(s.stock_value) / NULLIF(SUM(s.quantity), 0) FROM inventory_stock s
+                WHERE s.product_id = bi.product_id AND s.variant_id IS NOT DISTINCT FROM bi.variant_id AND s.quantity > 0), p.cost_price, 0)
+        FROM bom_items bi JOIN products p ON p.id = bi.product_id
+        LEFT JOIN product_variants v ON v.id = bi.variant_id
+        WHERE bi.bom_id=$1 ORDER BY p.name`, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	b.Items = []models.BomItem{}
+	for rows.Next() {
+		var it models.BomItem
+		rows.Scan(&it.ID, &it.ProductID, &it.VariantID, &it.ProductName, &it.Quantity, &it.WastePct, &it.UnitCost)
+		it.UnitCost = roundCost(it.UnitCost)
+		it.GrossQty = grossQty(it.Quantity, it.WastePct)
+		b.EstimatedCost += it.UnitCost * it.GrossQty
+		b.Items = append(b.Items, it)
+	}
+	b.EstimatedCost = roundCost(b.EstimatedCost)
+	b.EstimatedUnitCost = roundCost(b.EstimatedCost / b.OutputQty)
+	c.JSON(200, b)
+}
+
+func CreateBom(c *gin.Context) {
+	saveBom(c, "")
+}
+
+// UpdateBom replaces a BOM and its ingredients. Orders already raised keep the
+// quantities they were raised with.
+func UpdateBom(c *gin.Context) {
+	saveBom(c, c.Param("id"))
+}
+
+func saveBom(c *gin.Context, id string) {
+	tenantID := c.GetString("tenantID")
+	var req models.BomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OutputQty == 0 {
+		req.OutputQty = 1
+	}
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := checkManufactured(tx, tenantID, req.ProductID, req.VariantID); err != nil {
+		respondTxError(c, err)
+		return
+	}
+	seen := map[string]bool{}
+	for _, item := range req.Items {
+		key := item.ProductID + "/" + item.VariantID
+		if item.ProductID == req.ProductID && item.VariantID == req.VariantID {
+			c.JSON(400, gin.H{"error": "A product cannot be an ingredient of itself"})
+			return
+		}
+		if seen[key] {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Ingredient %s listed twice", key)})
+			return
+		}
+		seen[key] = true
+		if err := checkManufactured(tx, tenantID, item.ProductID, item.VariantID); err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	if id == "" {
+		err = tx.QueryRow(`INSERT INTO boms (tenant_id, product_id, variant_id, name, output_qty, is_active, notes)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7) RETURNING id`,
+			tenantID, req.ProductID, req.VariantID, req.Name, roundQty(req.OutputQty), isActive, req.Notes).Scan(&id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		res, err := tx.Exec(`UPDATE boms SET product_id=$1, variant_id=NULLIF($2, '')::uuid, name=$3, output_qty=$4, is_active=$5, notes=$6, updated_at=now()
+            WHERE id=$7 AND tenant_id=$8`,
+			req.ProductID, req.VariantID, req.Name, roundQty(req.OutputQty), isActive, req.Notes, id, tenantID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(404, gin.H{"error": "BOM not found"})
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM bom_items WHERE bom_id=$1", id); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	for _, item := range req.Items {
+		_, err := tx.Exec(`INSERT INTO bom_items (tenant_id, bom_id, product_id, variant_id, quantity, waste_pct)
+            VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6)`,
+			tenantID, id, item.ProductID, item.VariantID, roundQty(item.Quantity), item.WastePct)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Item insert failed"})
+			return
+		}
+	}
+
+	tx.Commit()
+	if c.Request.Method == "POST" {
+		c.JSON(201, gin.H{"id": id})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+const productionOrderSelect = `SELECT o.id, o.reference_no, o.branch_id, br.name, COALESCE(o.bom_id::text, ''), o.product_id, COALESCE(o.variant_id::text, ''),
+        p.name || COALESCE(' - ' || v.name, ''), o.quantity, o.status, o.total_cost, o.unit_cost, COALESCE(l.lot_number, ''), COALESCE(o.notes, ''), o.created_at, o.completed_at
+    FROM production_orders o
+    JOIN branches br ON br.id = o.branch_id
+    JOIN products p ON p.id = o.product_id
+    LEFT JOIN product_variants v ON v.id = o.variant_id
+    LEFT JOIN stock_lots l ON l.id = o.lot_id`
+
+func scanProductionOrder(row interface{ Scan(...interface{}) error }, o *models.ProductionOrder) error {
+	return row.Scan(&o.ID, &o.ReferenceNo, &o.BranchID, &o.BranchName, &o.BomID, &o.ProductID, &o.VariantID,
+		&o.ProductName, &o.Quantity, &o.Status, &o.TotalCost, &o.UnitCost, &o.LotNumber, &o.Notes, &o.CreatedAt, &o.CompletedAt)
+}
+
+// ListProductionOrders lists production orders, optionally by ?status and ?branch_id
+func ListProductionOrders(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(productionOrderSelect+`
+        WHERE o.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR o.status = $2)
+          AND (NULLIF($3, '') IS NULL OR o.branch_id = NULLIF($3, '')::uuid)
+        ORDER BY o.created_at DESC LIMIT 100`, tenantID, c.Query("status"), c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	orders := []models.ProductionOrder{}
+	for rows.Next() {
+		var o models.ProductionOrder
+		scanProductionOrder(rows, &o)
+		orders = append(orders, o)
+	}
+	c.JSON(200, orders)
+}
+
+func GetProductionOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var o models.ProductionOrder
+	if err := scanProductionOrder(db.DB.QueryRow(productionOrderSelect+" WHERE o.id=$1 AND o.tenant_id=$2", c.Param("id"), tenantID), &o); err != nil {
+		c.JSON(404, gin.H{"error": "Production order not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT i.id, i.product_id, COALESCE(i.variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), i.quantity, i.unit_cost
+        FROM production_order_items i JOIN products p ON p.id = i.product_id
+        LEFT JOIN product_variants v ON v.id = i.variant_id
+        WHERE i.order_id=$1 ORDER BY p.name`, o.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	o.Items = []models.ProductionOrderItem{}
+	for rows.Next() {
+		var it models.ProductionOrderItem
+		rows.Scan(&it.ID, &it.ProductID, &it.VariantID, &it.ProductName, &it.Quantity, &it.UnitCost)
+		o.Items = append(o.Items, it)
+	}
+	c.JSON(200, o)
+}
+
+// CreateProductionOrder raises an order for a quantity of a BOM's finished good.
+// Ingredient quantities are scaled from the BOM, waste included; with complete
+// set the order is produced in the same request.
+func CreateProductionOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	var req models.CreateProductionOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	branchID := req.BranchID
+	if branchID == "" {
+		branchID = c.GetString("branchID")
+	}
+	if !branchUsable(c, branchID) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var productID, variantID string
+	var outputQty float64
+	var active bool
+	err = tx.QueryRow("SELECT product_id, COALESCE(variant_id::text, ''), output_qty, is_active FROM boms WHERE id=$1 AND tenant_id=$2", req.BomID, tenantID).
+		Scan(&productID, &variantID, &outputQty, &active)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "BOM not found"})
+		return
+	}
+	if !active {
+		c.JSON(400, gin.H{"error": "BOM is not active"})
+		return
+	}
+
+	// Serialise numbering per tenant
+	if _, err := tx.Exec("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	ref := req.ReferenceNo
+	if ref == "" {
+		var count int
+		tx.QueryRow("SELECT COUNT(*) FROM production_orders WHERE tenant_id=$1", tenantID).Scan(&count)
+		ref = fmt.Sprintf("PRD-%06d", count+1)
+	}
+	var taken bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM production_orders WHERE tenant_id=$1 AND reference_no=$2)", tenantID, ref).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "Reference number already in use"})
+		return
+	}
+
+	var orderID string
+	err = tx.QueryRow(`INSERT INTO production_orders (tenant_id, branch_id, reference_no, bom_id, product_id, variant_id, quantity, notes, created_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7, $8, $9) RETURNING id`,
+		tenantID, branchID, ref, req.BomID, productID, variantID, roundQty(req.Quantity), req.Notes, userID).Scan(&orderID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	batches := req.Quantity / outputQty
+	res, err := tx.Exec(`INSERT INTO production_order_items (tenant_id, order_id, product_id, variant_id, quantity)
+        SELECT $1, $2, product_id, variant_id, ROUND(quantity * (1 + waste_pct / 100) * $3, 3) FROM bom_items
+        WHERE bom_id=$4 AND ROUND(quantity * (1 + waste_pct / 100) * $3, 3) > 0`,
+		tenantID, orderID, batches, req.BomID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": "Quantity too small: no ingredients would be used"})
+		return
+	}
+
+	status := "draft"
+	if req.Complete {
+		if err := completeProduction(tx, tenantID, userID, orderID, req.LotNumber, req.ExpiryDate); err != nil {
+			respondTxError(c, err)
+			return
+		}
+		status = "completed"
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": orderID, "reference_no": ref, "status": status})
+}
+
+// CompleteProductionOrder produces a draft order
+func CompleteProductionOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	var req models.CompleteProductionOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM production_orders WHERE id=$1 AND tenant_id=$2)", c.Param("id"), tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Production order not found"})
+		return
+	}
+
+	if err := completeProduction(tx, tenantID, userID, c.Param("id"), req.LotNumber, req.ExpiryDate); err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Production completed"})
+}
+
+// completeProduction consumes an order's ingredients at its branch and books the
+// output at their total cost. Fails (leaving the order a draft) when an
+// ingredient is short. Must be called within a transaction.
+func completeProduction(tx *sql.Tx, tenantID, userID, orderID, lotNumber, expiryDate string) error {
+	var branchID, productID, variantID, ref, status string
+	var qty float64
+	err := tx.QueryRow(`SELECT branch_id, product_id, COALESCE(variant_id::text, ''), reference_no, status, quantity
+        FROM production_orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, orderID, tenantID).
+		Scan(&branchID, &productID, &variantID, &ref, &status, &qty)
+	if err == sql.ErrNoRows {
+		return &validationError{"Production order not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if status != "draft" {
+		return &validationError{"Production order is " + status}
+	}
+	if err := checkManufactured(tx, tenantID, productID, variantID); err != nil {
+		return err
+	}
+
+	type ingredient struct {
+		id, productID, variantID, name string
+		qty                            float64
+	}
+	rows, err := tx.Query(`SELECT i.id, i.product_id, COALESCE(i.variant_id::text, ''), p.name || COALESCE(' - ' || v.name, ''), i.quantity
+        FROM production_order_items i JOIN products p ON p.id = i.product_id
+        LEFT JOIN product_variants v ON v.id = i.variant_id
+        WHERE i.order_id=$1 ORDER BY i.id`, orderID)
+	if err != nil {
+		return err
+	}
+	var ingredients []ingredient
+	for rows.Next() {
+		var in ingredient
+		if err := rows.Scan(&in.id, &in.productID, &in.variantID, &in.name, &in.qty); err != nil {
+			rows.Close()
+			return err
+		}
+		ingredients = append(ingredients, in)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 1. Consume ingredients (production_out)
+	note := "Production " + ref
+	var totalCost float64
+	for _, in := range ingredients {
+		available, _, err := lockStockQty(tx, tenantID, in.productID, in.variantID, branchID)
+		if err != nil {
+			return err
+		}
+		if available < in.qty {
+			return &insufficientStockError{ProductID: in.productID, VariantID: in.variantID, Name: in.name, Available: available, Requested: in.qty}
+		}
+
+		unitCost, err := moveStock(tx, tenantID, stockMove{
+			ProductID: in.productID,
+			VariantID: in.variantID,
+			BranchID:  branchID,
+			QtyChange: -in.qty,
+			RefType:   "production_out",
+			RefID:     orderID,
+			Note:      note,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE production_order_items SET unit_cost=$1 WHERE id=$2", unitCost, in.id); err != nil {
+			return err
+		}
+		totalCost += unitCost * in.qty
+	}
+	totalCost = roundCost(totalCost)
+
+	// 2. Book the output at the rolled-up cost (production_in)
+	lotID, err := receiptLot(tx, tenantID, productID, variantID, branchID, lotNumber, expiryDate)
+	if err != nil {
+		return err
+	}
+	unitCost := roundCost(totalCost / qty)
+	_, err = moveStock(tx, tenantID, stockMove{
+		ProductID: productID,
+		VariantID: variantID,
+		BranchID:  branchID,
+		QtyChange: qty,
+		RefType:   "production_in",
+		RefID:     orderID,
+		Note:      note,
+		UnitCost:  &unitCost,
+		LotID:     lotID,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE production_orders SET status='completed', total_cost=$1, unit_cost=$2, lot_id=NULLIF($3, '')::uuid, completed_by=$4, completed_at=now()
+        WHERE id=$5`, totalCost, unitCost, lotID, userID, orderID)
+	return err
+}
+
+// CancelProductionOrder drops a draft order; nothing has moved yet
+func CancelProductionOrder(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	res, err := db.DB.Exec("UPDATE production_orders SET status='cancelled' WHERE id=$1 AND tenant_id=$2 AND status='draft'", c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": "Only draft production orders can be cancelled"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Production order cancelled"})
+}
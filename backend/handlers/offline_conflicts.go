@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+const syncConflictSelect = `SELECT c.id, c.branch_id, COALESCE(b.name, ''), c.local_sale_id, c.payload, c.reason,
+        COALESCE(c.product_id::text, ''), COALESCE(c.variant_id::text, ''), c.available, c.requested, c.status, COALESCE(c.resolution, ''),
+        COALESCE(c.sale_id::text, ''), COALESCE(s.invoice_number, ''), c.refund_amount, COALESCE(c.refund_method, ''), COALESCE(c.resolution_note, ''),
+        COALESCE(c.synced_by::text, ''), COALESCE(c.resolved_by::text, ''), c.resolved_at, c.created_at
+    FROM offline_sync_conflicts c
+    LEFT JOIN branches b ON b.id = c.branch_id
+    LEFT JOIN sales s ON s.id = c.sale_id`
+
+func scanSyncConflict(row interface{ Scan(...interface{}) error }, sc *models.OfflineSyncConflict) error {
+	var payload []byte
+	err := row.Scan(&sc.ID, &sc.BranchID, &sc.BranchName, &sc.LocalSaleID, &payload, &sc.Reason, &sc.ProductID, &sc.VariantID, &sc.Available, &sc.Requested,
+		&sc.Status, &sc.Resolution, &sc.SaleID, &sc.InvoiceNumber, &sc.RefundAmount, &sc.RefundMethod, &sc.ResolutionNote,
+		&sc.SyncedBy, &sc.ResolvedBy, &sc.ResolvedAt, &sc.CreatedAt)
+	if err != nil {
+		return err
+	}
+	sc.Sale = &models.OfflineSyncSaleRequest{}
+	return json.Unmarshal(payload, sc.Sale)
+}
+
+// loadSyncConflict reads the conflict matching where (over offline_sync_conflicts c)
+func loadSyncConflict(where string, args ...interface{}) (*models.OfflineSyncConflict, error) {
+	var sc models.OfflineSyncConflict
+	if err := scanSyncConflict(db.DB.QueryRow(syncConflictSelect+" WHERE "+where, args...), &sc); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// insertSyncConflict records an offline sale short of stock: open, or already
+// resolved as negative stock when saleID is given. A sale held twice keeps the
+// first record.
+func insertSyncConflict(tx *sql.Tx, tenantID, userID, branchID string, req models.OfflineSyncSaleRequest, short *insufficientStockError, saleID string) (string, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	status, resolution, note := "open", "", ""
+	if saleID != "" {
+		status, resolution, note = "resolved", "negative_stock", "Posted automatically with negative stock"
+	}
+
+	var id string
+	err = tx.QueryRow(`INSERT INTO offline_sync_conflicts (tenant_id, branch_id, local_sale_id, payload, reason, product_id, variant_id, available, requested,
+            status, resolution, sale_id, resolution_note, synced_by, resolved_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid, $8, $9, $10, NULLIF($11, ''), NULLIF($12, '')::uuid, NULLIF($13, ''), $14,
+            CASE WHEN $10 = 'resolved' THEN now() END)
+        ON CONFLICT (tenant_id, local_sale_id) DO NOTHING RETURNING id`,
+		tenantID, branchID, req.LocalSaleID, string(payload), short.Error(), short.ProductID, short.VariantID, short.Available, short.Requested,
+		status, resolution, saleID, note, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// ListSyncConflicts lists offline sales held at sync time, oldest first.
+// ?status=open (default), resolved or all; optional ?branch_id.
+func ListSyncConflicts(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	status := c.DefaultQuery("status", "open")
+	if status == "all" {
+		status = ""
+	}
+
+	rows, err := db.DB.Query(syncConflictSelect+`
+        WHERE c.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR c.status = $2)
+          AND (NULLIF($3, '') IS NULL OR c.branch_id = NULLIF($3, '')::uuid)
+        ORDER BY c.created_at LIMIT 200`, tenantID, status, c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	conflicts := []models.OfflineSyncConflict{}
+	for rows.Next() {
+		var sc models.OfflineSyncConflict
+		scanSyncConflict(rows, &sc)
+		conflicts = append(conflicts, sc)
+	}
+	c.JSON(200, conflicts)
+}
+
+func GetSyncConflict(c *gin.Context) {
+	sc, err := loadSyncConflict("c.id=$1 AND c.tenant_id=$2", c.Param("id"), c.GetString("tenantID"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Sync conflict not found"})
+		return
+	}
+	c.JSON(200, sc)
+}
+
+// ResolveSyncConflict settles a held offline sale (see ResolveSyncConflictRequest).
+// Posted sales are booked to the cashier who synced them, at the branch they
+// synced for, with today's invoice number; the resolution is recorded on the
+// conflict and, for adjustment, on the stock ledger as 'sync_conflict' moves.
+func ResolveSyncConflict(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.ResolveSyncConflictRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var branchID, localSaleID, status, syncedBy string
+	var payload []byte
+	var heldAt time.Time
+	err = tx.QueryRow(`SELECT branch_id, local_sale_id, payload, status, COALESCE(synced_by::text, ''), created_at
+        FROM offline_sync_conflicts WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, id, tenantID).
+		Scan(&branchID, &localSaleID, &payload, &status, &syncedBy, &heldAt)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Sync conflict not found"})
+		return
+	}
+	if status != "open" {
+		c.JSON(400, gin.H{"error": "Sync conflict is already resolved"})
+		return
+	}
+	var sale models.OfflineSyncSaleRequest
+	if err := json.Unmarshal(payload, &sale); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Action == "void" {
+		refund, method, err := syncConflictRefund(tx, tenantID, sale, req)
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+		_, err = tx.Exec(`UPDATE offline_sync_conflicts SET status='resolved', resolution='void', refund_amount=$1, refund_method=$2, resolution_note=$3, resolved_by=$4, resolved_at=now()
+            WHERE id=$5`, refund, method, req.Note, userID, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		tx.Commit()
+		c.JSON(200, gin.H{"message": "Sale voided", "refund_amount": refund, "refund_method": method})
+		return
+	}
+
+	if req.Action == "adjustment" {
+		if err := adjustSyncShortfall(tx, tenantID, id, branchID, localSaleID, sale.Items); err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	// Posted as the terminal synced it: its cashier and device, dated as it was
+	// when it arrived
+	if syncedBy == "" {
+		syncedBy = userID
+	}
+	note := "Offline Sale Sync (adjusted)"
+	if req.Action == "negative_stock" {
+		note = "Offline Sale Sync (negative stock)"
+	}
+	cashier, in, err := offlineSaleInput(tx, tenantID, syncedBy, branchID, sale, heldAt)
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+	in.StockNote = note
+	in.AllowNegative = req.Action == "negative_stock"
+	res, err := postSale(tx, tenantID, cashier, in)
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	_, err = tx.Exec("INSERT INTO offline_sync_map (tenant_id, local_sale_id, server_sale_id) VALUES ($1, $2, $3)", tenantID, localSaleID, res.SaleID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Sync map insert failed"})
+		return
+	}
+	_, err = tx.Exec(`UPDATE offline_sync_conflicts SET status='resolved', resolution=$1, sale_id=$2, resolution_note=$3, resolved_by=$4, resolved_at=now()
+        WHERE id=$5`, req.Action, res.SaleID, req.Note, userID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "Commit failed"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Sale posted", "sale_id": res.SaleID, "invoice_number": res.InvoiceNumber, "final_amount": res.FinalAmount})
+}
+
+// adjustSyncShortfall books in ('sync_conflict' moves) the stock a held sale is
+// short of at its branch, so that it posts leaving that stock at zero
+func adjustSyncShortfall(tx *sql.Tx, tenantID, conflictID, branchID, localSaleID string, items []models.SaleItemRequest) error {
+	var keys []string
+	need := map[string]kitPart{}
+	for _, item := range items {
+		l, err := priceSaleItem(tx, tenantID, item, false)
+		if err != nil {
+			return err
+		}
+		stock := l.Components
+		if stock == nil {
+			stock = []kitPart{{ProductID: l.ProductID, VariantID: l.VariantID, Name: l.ProductName, Qty: l.StockQty}}
+		}
+		for _, s := range stock {
+			key := s.ProductID + "/" + s.VariantID
+			if n, ok := need[key]; ok {
+				s.Qty = roundQty(n.Qty + s.Qty)
+			} else {
+				keys = append(keys, key)
+			}
+			need[key] = s
+		}
+	}
+
+	for _, key := range keys {
+		s := need[key]
+		have, _, err := lockStockQty(tx, tenantID, s.ProductID, s.VariantID, branchID)
+		if err != nil {
+			return err
+		}
+		short := roundQty(s.Qty - have)
+		if short <= 0 {
+			continue
+		}
+		err = updateStockHelper(tx, tenantID, stockMove{
+			ProductID: s.ProductID,
+			VariantID: s.VariantID,
+			BranchID:  branchID,
+			QtyChange: short,
+			RefType:   "sync_conflict",
+			RefID:     conflictID,
+			Note:      "Stock adjusted for offline sale " + localSaleID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncConflictRefund is what a voided offline sale paid back: the amount given,
+// or what the customer paid (tenders net of cash change, at most the amount the
+// sale prices to now), by the given method or the sale's first tender
+func syncConflictRefund(tx *sql.Tx, tenantID string, sale models.OfflineSyncSaleRequest, req models.ResolveSyncConflictRequest) (float64, string, error) {
+	method := req.RefundMethod
+	if method != "" && !allowedPaymentMethods[method] {
+		return 0, "", &validationError{"unsupported refund method: " + method}
+	}
+	if method == "" {
+		method = sale.PaymentMethod
+		if len(sale.Payments) > 0 {
+			method = sale.Payments[0].Method
+		}
+	}
+	if req.RefundAmount != nil {
+		if *req.RefundAmount < 0 {
+			return 0, "", &validationError{"refund_amount cannot be negative"}
+		}
+		return roundMoney(*req.RefundAmount), method, nil
+	}
+
+	inclusive, err := tenantPricesIncludeTax(tx, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+	lines := make([]saleLine, 0, len(sale.Items))
+	for _, item := range sale.Items {
+		l, err := priceSaleItem(tx, tenantID, item, false)
+		if err != nil {
+			return 0, "", err
+		}
+		lines = append(lines, l)
+	}
+	totals, err := taxSaleLines(tx, tenantID, lines, sale.DiscountAmount, inclusive)
+	if err != nil {
+		return 0, "", err
+	}
+	tenders, err := resolveTenders(sale.Payments, sale.PaymentMethod, sale.PaymentReceived, totals.Final)
+	if err != nil {
+		return 0, "", &validationError{fmt.Sprintf("Cannot work out the refund (%s); give refund_amount", err.Error())}
+	}
+	return roundMoney(tenders.PaymentReceived - tenders.ChangeDue), method, nil
+}
+
+func GetOfflineSyncSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var s models.OfflineSyncSettings
+	err := db.DB.QueryRow("SELECT offline_stock_conflicts FROM tenants WHERE id=$1", tenantID).Scan(&s.StockConflicts)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Tenant not found"})
+		return
+	}
+	c.JSON(200, s)
+}
+
+// UpdateOfflineSyncSettings chooses what happens to offline sales short of stock
+// at sync: held for a manager, or posted taking stock below zero (still listed
+// as resolved conflicts). Sales already held stay held.
+func UpdateOfflineSyncSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.OfflineSyncSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := db.DB.Exec("UPDATE tenants SET offline_stock_conflicts=$1, updated_at=now() WHERE id=$2", req.StockConflicts, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Offline sync settings updated"})
+}
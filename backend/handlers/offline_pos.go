@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/insaansher/sherpos/backend/db"
 	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+	"github.com/lib/pq"
 )
 
 // SyncOfflineSale handles cached sales from offline mode
@@ -21,132 +25,339 @@ func SyncOfflineSale(c *gin.Context) {
 		return
 	}
 
-	// 1. Idempotency Check
-	var existingServerID string
-	var existingInvoice string
-	err := db.DB.QueryRow(`
-		SELECT s.id, s.invoice_number 
-		FROM offline_sync_map m
-		JOIN sales s ON m.server_sale_id = s.id
-		WHERE m.tenant_id=$1 AND m.local_sale_id=$2
-	`, tenantID, req.LocalSaleID).Scan(&existingServerID, &existingInvoice)
-
-	if err == nil {
-		// Already synced
+	req.DeviceID = c.GetString("deviceID")
+	res, err := syncOfflineSale(tenantID, userID, c.GetString("branchID"), req)
+	if err != nil {
+		c.JSON(syncErrorResponse(err))
+		return
+	}
+	if res.Conflict != nil {
+		// Held: the terminal can drop the sale from its queue; a manager settles it
+		if res.Conflict.Resolution == "void" {
+			c.JSON(200, gin.H{"message": "Voided in conflict review", "conflict_id": res.Conflict.ID, "status": res.Conflict.Status})
+			return
+		}
+		c.JSON(202, gin.H{"message": "Held for review: " + res.Conflict.Reason, "conflict_id": res.Conflict.ID, "status": res.Conflict.Status})
+		return
+	}
+	if res.AlreadySynced {
 		c.JSON(200, gin.H{
 			"message":        "Already synced",
-			"sale_id":        existingServerID,
-			"invoice_number": existingInvoice,
+			"sale_id":        res.SaleID,
+			"invoice_number": res.InvoiceNumber,
 		})
 		return
-	} else if err != sql.ErrNoRows {
-		c.JSON(500, gin.H{"error": "Sync map check failed"})
+	}
+
+	c.JSON(201, gin.H{
+		"message":        "Synced",
+		"sale_id":        res.SaleID,
+		"invoice_number": res.InvoiceNumber,
+	})
+}
+
+// SyncOfflineSalesBatch posts a terminal's queued sales in the order given, each
+// in its own transaction, and reports on every one. A sale that cannot be
+// posted is reported and skipped; the ones after it are still posted. Sales
+// already synced (by an earlier batch, or earlier in this one) report their
+// existing invoice, so a batch can be resent safely after a dropped connection.
+func SyncOfflineSalesBatch(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	branchID := c.GetString("branchID")
+
+	var req models.OfflineSyncBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 2. Begin Transaction
+	results := make([]models.OfflineSyncResult, len(req.Sales))
+	counts := map[string]int{}
+	for i, sale := range req.Sales {
+		r := models.OfflineSyncResult{Index: i, LocalSaleID: sale.LocalSaleID}
+		if sale.SentAt.IsZero() {
+			sale.SentAt = req.SentAt
+		}
+		sale.DeviceID = c.GetString("deviceID")
+
+		// Each sale is validated on its own so a malformed one only rejects itself
+		if err := binding.Validator.ValidateStruct(&sale); err != nil {
+			r.Status = "rejected"
+			r.Error = err.Error()
+		} else if res, err := syncOfflineSale(tenantID, userID, branchID, sale); err != nil {
+			code, body := syncErrorResponse(err)
+			switch {
+			case code == 409:
+				r.Status = "conflict"
+			case code < 500:
+				r.Status = "rejected"
+			default:
+				r.Status = "error"
+			}
+			r.Error, _ = body["error"].(string)
+			delete(body, "error")
+			if len(body) > 0 {
+				r.Details = body
+			}
+		} else if res.Conflict != nil {
+			r.Status = "held"
+			if res.Conflict.Resolution == "void" {
+				r.Status = "voided"
+			}
+			r.Error = res.Conflict.Reason
+			r.Details = map[string]interface{}{"conflict_id": res.Conflict.ID}
+		} else {
+			r.Status = "synced"
+			if res.AlreadySynced {
+				r.Status = "already_synced"
+			}
+			r.SaleID, r.InvoiceNumber = res.SaleID, res.InvoiceNumber
+		}
+
+		counts[r.Status]++
+		results[i] = r
+	}
+
+	c.JSON(200, gin.H{"results": results, "summary": counts})
+}
+
+// offlineSync is where an offline sale ended up: posted as a sale, or held as a
+// stock conflict for a manager
+type offlineSync struct {
+	SaleID        string
+	InvoiceNumber string
+	AlreadySynced bool
+	Conflict      *models.OfflineSyncConflict
+}
+
+// syncOfflineSale posts one offline sale unless its local id is already mapped
+// to a server sale or held as a conflict. A sale short of stock is held for a
+// manager, or posted taking stock below zero when the tenant allows it.
+func syncOfflineSale(tenantID, userID, branchID string, req models.OfflineSyncSaleRequest) (*offlineSync, error) {
+	// 1. Idempotency Check
+	saleID, invoice, err := syncedSale(tenantID, req.LocalSaleID)
+	if err == nil {
+		return &offlineSync{SaleID: saleID, InvoiceNumber: invoice, AlreadySynced: true}, nil
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("sync map check failed: %w", err)
+	}
+	held, err := loadSyncConflict("c.tenant_id=$1 AND c.local_sale_id=$2", tenantID, req.LocalSaleID)
+	if err == nil {
+		return &offlineSync{AlreadySynced: true, Conflict: held}, nil
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("sync conflict check failed: %w", err)
+	}
+
+	// 2. Post it; when short of stock, apply the tenant's conflict policy
+	receivedAt := time.Now()
+	res, err := postOfflineSale(tenantID, userID, branchID, req, receivedAt, nil)
+	var short *insufficientStockError
+	if !errors.As(err, &short) {
+		return res, err
+	}
+
+	var policy string
+	db.DB.QueryRow("SELECT offline_stock_conflicts FROM tenants WHERE id=$1", tenantID).Scan(&policy)
+	if policy == "allow_negative" {
+		return postOfflineSale(tenantID, userID, branchID, req, receivedAt, short)
+	}
+	return holdOfflineSale(tenantID, userID, branchID, req, short)
+}
+
+// postOfflineSale posts an offline sale received at receivedAt in its own
+// transaction and maps its local id. With short set it takes stock below zero
+// and records the conflict as resolved that way.
+func postOfflineSale(tenantID, userID, branchID string, req models.OfflineSyncSaleRequest, receivedAt time.Time, short *insufficientStockError) (*offlineSync, error) {
 	tx, err := db.DB.Begin()
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Tx failed"})
-		return
+		return nil, fmt.Errorf("tx failed: %w", err)
 	}
 	defer tx.Rollback()
 
-	// 3. Stock Check & Calc
-	// NOTE: We validate stock at sync time. If insufficient, we reject sync.
-	// User must resolve manually (adjust stock or cancel sale).
-	var totalAmount float64
-	var itemsToInsert []models.SaleItem
-
-	for _, item := range req.Items {
-		var price float64
-		var name string
-		var currentStock int
+	// NOTE: Stock is validated at sync time; see syncOfflineSale for sales short of it.
+	// The invoice comes from the server-side sequence at sync time, ignoring offline created_at for sequence consistency.
+	// created_at is server time to keep the DB chronological; the device's time is sold_at.
+	note := "Offline Sale Sync"
+	if short != nil {
+		note += " (negative stock)"
+	}
+	cashier, in, err := offlineSaleInput(tx, tenantID, userID, branchID, req, receivedAt)
+	if err != nil {
+		return nil, err
+	}
+	in.StockNote = note
+	in.AllowNegative = short != nil
+	res, err := postSale(tx, tenantID, cashier, in)
+	if err != nil {
+		return nil, err
+	}
 
-		err := tx.QueryRow("SELECT price, name FROM products WHERE id=$1 AND tenant_id=$2", item.ProductID, tenantID).Scan(&price, &name)
+	// Write Sync Map. A concurrent request syncing the same sale got there
+	// first if the local id is taken: drop this copy and report theirs.
+	_, err = tx.Exec("INSERT INTO offline_sync_map (tenant_id, local_sale_id, server_sale_id) VALUES ($1, $2, $3)",
+		tenantID, req.LocalSaleID, res.SaleID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		tx.Rollback()
+		saleID, invoice, err := syncedSale(tenantID, req.LocalSaleID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": fmt.Sprintf("Product %s not found (deleted?)", item.ProductID)})
-			return
+			return nil, fmt.Errorf("sync map check failed: %w", err)
 		}
+		return &offlineSync{SaleID: saleID, InvoiceNumber: invoice, AlreadySynced: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sync map insert failed: %w", err)
+	}
 
-		err = tx.QueryRow("SELECT quantity FROM inventory_stock WHERE product_id=$1 AND tenant_id=$2 FOR UPDATE", item.ProductID, tenantID).Scan(&currentStock)
-		if err != nil { // No stock record
-			currentStock = 0
+	if short != nil {
+		_, err := insertSyncConflict(tx, tenantID, userID, branchID, req, short, res.SaleID)
+		if err != nil {
+			return nil, fmt.Errorf("sync conflict insert failed: %w", err)
 		}
+	}
 
-		if currentStock < item.Quantity {
-			c.JSON(409, gin.H{
-				"error":      fmt.Sprintf("Insufficient stock for %s during sync. Available: %d, Sold: %d", name, currentStock, item.Quantity),
-				"product_id": item.ProductID,
-			})
-			return
-		}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+	return &offlineSync{SaleID: res.SaleID, InvoiceNumber: res.InvoiceNumber}, nil
+}
 
-		lineTotal := price * float64(item.Quantity)
-		totalAmount += lineTotal
+const (
+	maxDeviceClockAhead = 5 * time.Minute     // Corrected sale times later than receipt by more are not trusted
+	maxOfflineSaleAge   = 30 * 24 * time.Hour // Nor are ones older than this
+)
 
-		itemsToInsert = append(itemsToInsert, models.SaleItem{
-			ProductName: name,
-			Quantity:    item.Quantity,
-			UnitPrice:   price,
-			TotalPrice:  lineTotal,
-		})
+// offlineSaleInput builds the sale an offline sale posts as, and the cashier it
+// is posted for: the user syncing, or the one the terminal names if they may
+// sell at the branch and the device is enrolled to them or to it. The sale is
+// dated by offlineSaleTime.
+func offlineSaleInput(tx *sql.Tx, tenantID, userID, branchID string, req models.OfflineSyncSaleRequest, receivedAt time.Time) (string, saleInput, error) {
+	cashier := userID
+	if req.CashierID != "" && req.CashierID != userID {
+		if err := checkOfflineCashier(tx, tenantID, branchID, req.DeviceID, req.CashierID); err != nil {
+			return "", saleInput{}, err
+		}
+		cashier = req.CashierID
 	}
 
-	finalAmount := totalAmount - req.DiscountAmount
-	if finalAmount < 0 {
-		finalAmount = 0
+	origin := &saleOrigin{DeviceID: req.DeviceID, SyncedBy: userID}
+	if !req.CreatedAt.IsZero() {
+		deviceAt := req.CreatedAt
+		origin.DeviceCreatedAt = &deviceAt
 	}
+	var soldAt time.Time
+	soldAt, origin.ClockSkew, origin.TimeRejected = offlineSaleTime(req.CreatedAt, req.SentAt, receivedAt)
 
-	// 4. Generate Invoice (Using current server time/sequence, ignoring offline created_at for sequence consistency, but could track metadata)
-	var count int
-	tx.QueryRow("SELECT COUNT(*) FROM sales WHERE tenant_id=$1", tenantID).Scan(&count)
-	invoiceNum := fmt.Sprintf("INV-%d-%06d", 2026, count+1)
+	return cashier, saleInput{
+		BranchID:        branchID,
+		CustomerID:      req.CustomerID,
+		Items:           req.Items,
+		DiscountAmount:  req.DiscountAmount,
+		PaymentMethod:   req.PaymentMethod,
+		PaymentReceived: req.PaymentReceived,
+		Payments:        req.Payments,
+		CreatedAt:       time.Now(),
+		SoldAt:          soldAt,
+		Origin:          origin,
+	}, nil
+}
 
-	// 5. Insert Sale
-	// We use server time for created_at to maintain chronological order in DB, or use req.CreatedAt if strict local time needed.
-	// Let's use REQ time but ensure it's not future? No, safe to use Server Time for "System Record" but maybe store "Device Time" in metadata eventually.
-	// For now, use Server Time for consistent reporting.
-	var saleID string
-	err = tx.QueryRow(`INSERT INTO sales (tenant_id, invoice_number, total_amount, discount_amount, final_amount, payment_method, created_by, created_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
-		tenantID, invoiceNum, totalAmount, req.DiscountAmount, finalAmount, req.PaymentMethod, userID, time.Now()).Scan(&saleID)
+// offlineSaleTime dates an offline sale by the device clock (createdAt),
+// corrected by how far that clock was off when the terminal sent the sale
+// (sentAt against receivedAt); skew is that correction in seconds, nil when the
+// terminal did not say when it sent. A time that is missing, in the future or
+// implausibly old is not trusted: the sale is dated at receipt instead and
+// rejected is set so it gets reviewed.
+func offlineSaleTime(createdAt, sentAt, receivedAt time.Time) (soldAt time.Time, skew *int, rejected bool) {
+	soldAt = createdAt
+	if !createdAt.IsZero() && !sentAt.IsZero() {
+		d := receivedAt.Sub(sentAt)
+		seconds := int(d.Seconds())
+		skew = &seconds
+		soldAt = soldAt.Add(d)
+	}
+	switch {
+	case soldAt.IsZero(), soldAt.After(receivedAt.Add(maxDeviceClockAhead)), soldAt.Before(receivedAt.Add(-maxOfflineSaleAge)):
+		return receivedAt, skew, true
+	case soldAt.After(receivedAt):
+		// Within tolerance (network time, clock drift since sending): not sold in the future
+		return receivedAt, skew, false
+	}
+	return soldAt, skew, false
+}
+
+// checkOfflineCashier accepts the cashier a terminal says rang up a sale when they
+// are a POS user who can work at the branch, on a device enrolled by them or for
+// that branch
+func checkOfflineCashier(tx *sql.Tx, tenantID, branchID, deviceID, cashierID string) error {
+	var role string
+	err := tx.QueryRow("SELECT role FROM users WHERE id=$1 AND tenant_id=$2", cashierID, tenantID).Scan(&role)
+	if err == sql.ErrNoRows || (err == nil && role != "owner" && role != "manager" && role != "cashier") {
+		return &validationError{"Cashier " + cashierID + " not found"}
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Sale insert failed"})
-		return
+		return err
+	}
+	found, active, allowed, err := services.BranchAccess(tenantID, cashierID, role, branchID)
+	if err != nil {
+		return err
+	}
+	if !found || !active || !allowed {
+		return &validationError{"Cashier " + cashierID + " is not assigned to this branch"}
 	}
 
-	// 6. Insert Items & Updates
-	for i, item := range itemsToInsert {
-		_, err := tx.Exec(`INSERT INTO sale_items (sale_id, product_id, product_name, quantity, unit_price, total_price)
-            VALUES ($1, $2, $3, $4, $5, $6)`,
-			saleID, req.Items[i].ProductID, item.ProductName, item.Quantity, item.UnitPrice, item.TotalPrice)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Item insert failed"})
-			return
-		}
+	var enrolled bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM pos_devices WHERE id=NULLIF($1, '')::uuid AND tenant_id=$2 AND (user_id=$3 OR branch_id=$4))",
+		deviceID, tenantID, cashierID, branchID).Scan(&enrolled)
+	if !enrolled {
+		return &validationError{"This device is not enrolled for cashier " + cashierID + " or this branch"}
+	}
+	return nil
+}
 
-		// Update Stock via Helper
-		err = updateStockHelper(tx, tenantID, req.Items[i].ProductID, -item.Quantity, "sale", saleID, "Offline Sale Sync")
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Stock update failed: " + err.Error()})
-			return
-		}
+// holdOfflineSale queues an offline sale short of stock for a manager
+func holdOfflineSale(tenantID, userID, branchID string, req models.OfflineSyncSaleRequest, short *insufficientStockError) (*offlineSync, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("tx failed: %w", err)
 	}
+	defer tx.Rollback()
 
-	// 7. Write Sync Map
-	_, err = tx.Exec("INSERT INTO offline_sync_map (tenant_id, local_sale_id, server_sale_id) VALUES ($1, $2, $3)",
-		tenantID, req.LocalSaleID, saleID)
+	if _, err := insertSyncConflict(tx, tenantID, userID, branchID, req, short, ""); err != nil {
+		return nil, fmt.Errorf("sync conflict insert failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+
+	// Read back (ours, or one a concurrent sync of the same sale held first)
+	held, err := loadSyncConflict("c.tenant_id=$1 AND c.local_sale_id=$2", tenantID, req.LocalSaleID)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Sync map insert failed"})
-		return
+		return nil, err
 	}
+	return &offlineSync{Conflict: held}, nil
+}
 
-	tx.Commit()
+// syncedSale returns the server sale an offline sale was synced as (sql.ErrNoRows if none)
+func syncedSale(tenantID, localSaleID string) (string, string, error) {
+	var saleID, invoice string
+	err := db.DB.QueryRow(`
+		SELECT s.id, s.invoice_number
+		FROM offline_sync_map m
+		JOIN sales s ON m.server_sale_id = s.id
+		WHERE m.tenant_id=$1 AND m.local_sale_id=$2
+	`, tenantID, localSaleID).Scan(&saleID, &invoice)
+	return saleID, invoice, err
+}
 
-	c.JSON(201, gin.H{
-		"message":        "Synced",
-		"sale_id":        saleID,
-		"invoice_number": invoiceNum,
-	})
+// syncErrorResponse maps a failed sync like respondTxError; a product missing at
+// sync time was most likely deleted while the terminal was offline, which the
+// terminal cannot fix by retrying, so it is a 400
+func syncErrorResponse(err error) (int, gin.H) {
+	var notFound *productNotFoundError
+	if errors.As(err, &notFound) {
+		return 400, gin.H{"error": fmt.Sprintf("Product %s not found (deleted?)", notFound.ProductID), "product_id": notFound.ProductID}
+	}
+	return txErrorResponse(err)
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOfflineSaleTime(t *testing.T) {
+	received := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return received.Add(d) }
+	var never time.Time
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		sentAt    time.Time
+		soldAt    time.Time
+		skew      *int
+		rejected  bool
+	}{
+		{"clock in step", at(-time.Hour), at(0), at(-time.Hour), intPtr(0), false},
+		{"clock behind is moved forward", at(-2 * time.Hour), at(-10 * time.Minute), at(-110 * time.Minute), intPtr(600), false},
+		{"clock ahead is moved back", at(time.Hour), at(2 * time.Hour), at(-time.Hour), intPtr(-7200), false},
+		{"no sent time: device time as is", at(-time.Hour), never, at(-time.Hour), nil, false},
+		{"slightly in the future is received time", at(2 * time.Minute), never, received, nil, false},
+		{"far in the future is rejected", at(time.Hour), never, received, nil, true},
+		{"corrected into the future is rejected", at(-time.Minute), at(-time.Hour), received, intPtr(3600), true},
+		{"older than the window is rejected", at(-31 * 24 * time.Hour), never, received, nil, true},
+		{"within the window", at(-29 * 24 * time.Hour), at(0), at(-29 * 24 * time.Hour), intPtr(0), false},
+		{"no device time is rejected", never, at(0), received, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			soldAt, skew, rejected := offlineSaleTime(tt.createdAt, tt.sentAt, received)
+			if !soldAt.Equal(tt.soldAt) || rejected != tt.rejected {
+				t.Errorf("sold at %v (rejected %v), want %v (rejected %v)", soldAt, rejected, tt.soldAt, tt.rejected)
+			}
+			if (skew == nil) != (tt.skew == nil) || (skew != nil && *skew != *tt.skew) {
+				t.Errorf("skew %v, want %v", fmtSkew(skew), fmtSkew(tt.skew))
+			}
+		})
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+func fmtSkew(s *int) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
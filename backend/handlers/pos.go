@@ -2,32 +2,40 @@ package handlers
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/json"
 
 	"github.com/gin-gonic/gin"
 	"github.com/insaansher/sherpos/backend/db"
 	"github.com/insaansher/sherpos/backend/models"
+	"github.com/lib/pq"
 )
 
-// GetPOSProducts returns active products with stock for the tenant
+// GetPOSProducts returns active products with their stock at the current branch.
+// A kit's stock is how many whole kits its components make up.
 func GetPOSProducts(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
+	branchID := c.GetString("branchID")
 	search := c.Query("search")
 
 	query := `
-        SELECT p.id, p.name, p.sku, p.barcode, p.price, COALESCE(SUM(i.quantity), 0) as stock
+        SELECT p.id, p.name, p.sku, p.barcode, p.price, COALESCE(SUM(i.quantity), 0) as stock,
+            COALESCE(p.unit_id::text, ''), COALESCE(u.short_name, ''), COALESCE(u.allow_decimals, false),
+            COALESCE(p.sale_unit_id::text, ''), p.sale_unit_factor, p.product_type
         FROM products p
-        LEFT JOIN inventory_stock i ON p.id = i.product_id AND i.tenant_id = p.tenant_id
+        LEFT JOIN units u ON u.id = p.unit_id
+        LEFT JOIN inventory_stock i ON p.id = i.product_id AND i.tenant_id = p.tenant_id AND i.branch_id = $2
         WHERE p.tenant_id = $1 AND p.is_active = true
     `
-	args := []interface{}{tenantID}
+	args := []interface{}{tenantID, branchID}
 
 	if search != "" {
-		query += " AND (p.name ILIKE $2 OR p.sku ILIKE $2 OR p.barcode ILIKE $2)"
+		// Variant barcodes/SKUs resolve to their parent product
+		query += ` AND (p.name ILIKE $3 OR p.sku ILIKE $3 OR p.barcode ILIKE $3 OR EXISTS (
+            SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND COALESCE(v.is_active, true) AND (v.barcode ILIKE $3 OR v.sku ILIKE $3)))`
 		args = append(args, "%"+search+"%")
 	}
 
-	query += " GROUP BY p.id ORDER BY p.name LIMIT 50"
+	query += " GROUP BY p.id, u.id ORDER BY p.name LIMIT 50"
 
 	// Check if products exist for this tenant, if not seed them
 	var count int
@@ -37,15 +45,15 @@ func GetPOSProducts(c *gin.Context) {
 		// Ideally helper func, but inline for brevity/safety in this handler file
 		p1ID := ""
 		db.DB.QueryRow("INSERT INTO products (tenant_id, name, sku, price, is_active) VALUES ($1, 'Demo Coffee', 'SKU001', 3.50, true) RETURNING id", tenantID).Scan(&p1ID)
-		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, 100)", tenantID, p1ID)
+		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, branch_id, quantity) VALUES ($1, $2, $3, 100)", tenantID, p1ID, branchID)
 
 		p2ID := ""
 		db.DB.QueryRow("INSERT INTO products (tenant_id, name, sku, price, is_active) VALUES ($1, 'Demo Tea', 'SKU002', 2.00, true) RETURNING id", tenantID).Scan(&p2ID)
-		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, 50)", tenantID, p2ID)
+		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, branch_id, quantity) VALUES ($1, $2, $3, 50)", tenantID, p2ID, branchID)
 
 		p3ID := ""
 		db.DB.QueryRow("INSERT INTO products (tenant_id, name, sku, price, is_active) VALUES ($1, 'Demo Cake', 'SKU003', 5.00, true) RETURNING id", tenantID).Scan(&p3ID)
-		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, 20)", tenantID, p3ID)
+		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, branch_id, quantity) VALUES ($1, $2, $3, 20)", tenantID, p3ID, branchID)
 	}
 
 	rows, err := db.DB.Query(query, args...)
@@ -59,7 +67,8 @@ func GetPOSProducts(c *gin.Context) {
 	for rows.Next() {
 		var p models.Product
 		var bc sql.NullString
-		if err := rows.Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.StockQuantity); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.StockQuantity,
+			&p.UnitID, &p.Unit, &p.AllowDecimals, &p.SaleUnitID, &p.SaleUnitFactor, &p.ProductType); err != nil {
 			continue
 		}
 		p.Barcode = bc.String
@@ -67,12 +76,60 @@ func GetPOSProducts(c *gin.Context) {
 		products = append(products, p)
 	}
 
+	if err := attachPOSVariants(products, tenantID, branchID, search, false); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := attachKitStock(products, tenantID, branchID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
 	if products == nil {
 		products = []models.Product{}
 	}
 	c.JSON(200, products)
 }
 
+// attachPOSVariants loads the sellable variants (with branch stock) of the listed products,
+// and the retired ones too when withInactive (catalog deltas, so terminals drop them).
+// An exact variant barcode match is flagged so the POS can add that variant directly.
+func attachPOSVariants(products []models.Product, tenantID, branchID, search string, withInactive bool) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]string, len(products))
+	byID := make(map[string]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+		byID[p.ID] = i
+	}
+
+	rows, err := db.DB.Query(`SELECT v.id, v.product_id, v.name, COALESCE(v.sku, ''), COALESCE(v.barcode, ''), v.price_override, COALESCE(SUM(i.quantity), 0), COALESCE(v.is_active, true)
+        FROM product_variants v LEFT JOIN inventory_stock i ON i.variant_id = v.id AND i.branch_id = $3
+        WHERE v.tenant_id=$1 AND v.product_id = ANY($2) AND (COALESCE(v.is_active, true) OR $4)
+        GROUP BY v.id ORDER BY v.name`, tenantID, pq.Array(ids), branchID, withInactive)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v models.ProductVariant
+		var override sql.NullFloat64
+		if err := rows.Scan(&v.ID, &v.ProductID, &v.Name, &v.Sku, &v.Barcode, &override, &v.StockQuantity, &v.IsActive); err != nil {
+			return err
+		}
+		p := &products[byID[v.ProductID]]
+		setVariantPrice(&v, override, p.Price)
+		if search != "" && v.Barcode == search {
+			p.MatchedVariantID = v.ID
+		}
+		p.Variants = append(p.Variants, v)
+	}
+	return rows.Err()
+}
+
 // CreateSale processes a POS transaction
 func CreateSale(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
@@ -91,89 +148,40 @@ func CreateSale(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	// 1. Generate Invoice Number (Simple Count+1 logic for MVP)
-	// NOTE: In high concurrency this is flaky without locking, but acceptable for MVP "Core"
-	var count int
-	tx.QueryRow("SELECT COUNT(*) FROM sales WHERE tenant_id=$1", tenantID).Scan(&count)
-	invoiceNum := fmt.Sprintf("INV-%d-%06d", 2026, count+1) // Hardcoded year for MVP
-
-	// 2. Process Items
-	var totalAmount float64
-	var itemsToInsert []models.SaleItem
-
-	for _, item := range req.Items {
-		// Check Stock & Price
-		var price float64
-		var currentStock int
-		var name string
-
-		err := tx.QueryRow("SELECT price, name FROM products WHERE id=$1 AND tenant_id=$2", item.ProductID, tenantID).Scan(&price, &name)
-		if err != nil {
-			c.JSON(404, gin.H{"error": fmt.Sprintf("Product %s not found", item.ProductID)})
-			return
-		}
-
-		err = tx.QueryRow("SELECT quantity FROM inventory_stock WHERE product_id=$1 AND tenant_id=$2 FOR UPDATE", item.ProductID, tenantID).Scan(&currentStock)
-		if err == sql.ErrNoRows {
-			currentStock = 0
-		} // No record = 0 stock
-
-		if currentStock < item.Quantity {
-			c.JSON(409, gin.H{"error": fmt.Sprintf("Insufficient stock for %s. Available: %d", name, currentStock)})
-			return
-		}
-
-		lineTotal := price * float64(item.Quantity)
-		totalAmount += lineTotal
-
-		itemsToInsert = append(itemsToInsert, models.SaleItem{
-			ProductName: name,
-			Quantity:    item.Quantity,
-			UnitPrice:   price, // Use DB price for security, ignoring req.UnitPrice unless handling overrides
-			TotalPrice:  lineTotal,
-		})
-	}
-
-	finalAmount := totalAmount - req.DiscountAmount
-	if finalAmount < 0 {
-		finalAmount = 0
-	}
-
-	// 3. Insert Sale
-	var saleID string
-	err = tx.QueryRow(`INSERT INTO sales (tenant_id, invoice_number, total_amount, discount_amount, final_amount, payment_method, created_by)
-        VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		tenantID, invoiceNum, totalAmount, req.DiscountAmount, finalAmount, req.PaymentMethod, userID).Scan(&saleID)
+	res, err := postSale(tx, tenantID, userID, saleInput{
+		BranchID:        c.GetString("branchID"),
+		CustomerID:      req.CustomerID,
+		Items:           req.Items,
+		DiscountAmount:  req.DiscountAmount,
+		PaymentMethod:   req.PaymentMethod,
+		PaymentReceived: req.PaymentReceived,
+		Payments:        req.Payments,
+		StockNote:       "POS Sale",
+		Origin:          &saleOrigin{DeviceID: c.GetString("deviceID")},
+	})
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Sale insert failed: " + err.Error()})
+		respondTxError(c, err)
 		return
 	}
 
-	// 4. Insert Items
-	for i, item := range itemsToInsert {
-		_, err := tx.Exec(`INSERT INTO sale_items (sale_id, product_id, product_name, quantity, unit_price, total_price)
-            VALUES ($1, $2, $3, $4, $5, $6)`,
-			saleID, req.Items[i].ProductID, item.ProductName, item.Quantity, item.UnitPrice, item.TotalPrice)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Item insert failed"})
-			return
-		}
-
-		// Deduct Stock with Ledger
-		err = updateStockHelper(tx, tenantID, req.Items[i].ProductID, -item.Quantity, "sale", saleID, "POS Sale")
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Stock update failed: " + err.Error()})
-			return
-		}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "Commit failed"})
+		return
 	}
 
-	tx.Commit()
-
 	c.JSON(201, gin.H{
-		"message":        "Sale created",
-		"sale_id":        saleID,
-		"invoice_number": invoiceNum,
-		"final_amount":   finalAmount,
+		"message":         "Sale created",
+		"sale_id":         res.SaleID,
+		"invoice_number":  res.InvoiceNumber,
+		"total_amount":    res.TotalAmount,
+		"discount_amount": res.DiscountAmount,
+		"tax_amount":      res.TaxAmount,
+		"tax_breakdown":   res.Taxes,
+		"final_amount":    res.FinalAmount,
+		"change_due":      res.Tenders.ChangeDue,
+		"payments":        res.Tenders.Payments,
+		"points_earned":   res.PointsEarned,
+		"points_redeemed": res.PointsRedeemed,
 	})
 }
 
@@ -183,22 +191,44 @@ func GetSale(c *gin.Context) {
 	id := c.Param("id")
 
 	var s models.Sale
-	err := db.DB.QueryRow("SELECT id, invoice_number, total_amount, discount_amount, final_amount, payment_method, created_at FROM sales WHERE id=$1 AND tenant_id=$2", id, tenantID).
-		Scan(&s.ID, &s.InvoiceNumber, &s.TotalAmount, &s.DiscountAmount, &s.FinalAmount, &s.PaymentMethod, &s.CreatedAt)
+	err := db.DB.QueryRow(`SELECT s.id, COALESCE(s.branch_id::text, ''), COALESCE(s.customer_id::text, ''), COALESCE(cu.name, ''), s.invoice_number, s.total_amount, s.discount_amount, COALESCE(s.tax_amount, 0), s.final_amount,
+            s.payment_method, COALESCE(s.status, 'completed'), COALESCE(s.payment_received, 0), COALESCE(s.change_due, 0), s.created_at,
+            s.sold_at, COALESCE(s.device_id::text, ''), s.device_created_at, s.device_time_rejected, COALESCE(s.created_by::text, ''), COALESCE(s.synced_by::text, '')
+        FROM sales s LEFT JOIN customers cu ON cu.id = s.customer_id WHERE s.id=$1 AND s.tenant_id=$2`, id, tenantID).
+		Scan(&s.ID, &s.BranchID, &s.CustomerID, &s.CustomerName, &s.InvoiceNumber, &s.TotalAmount, &s.DiscountAmount, &s.TaxAmount, &s.FinalAmount, &s.PaymentMethod, &s.Status, &s.PaymentReceived, &s.ChangeDue, &s.CreatedAt,
+			&s.SoldAt, &s.DeviceID, &s.DeviceCreatedAt, &s.DeviceTimeRejected, &s.CashierID, &s.SyncedBy)
 
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Sale not found"})
 		return
 	}
 
-	rows, _ := db.DB.Query("SELECT product_name, quantity, unit_price, total_price FROM sale_items WHERE sale_id=$1", s.ID)
+	rows, err := db.DB.Query(`SELECT si.id, COALESCE(si.product_id::text, ''), COALESCE(si.variant_id::text, ''), si.product_name, si.quantity,
+        COALESCE(si.unit_id::text, ''), COALESCE(u.short_name, ''), si.unit_factor, si.unit_price, si.total_price,
+        COALESCE(si.discount_amount, 0), COALESCE(si.net_amount, 0), COALESCE(si.tax_amount, 0), COALESCE(si.tax_details, '[]'),
+        COALESCE((SELECT SUM(ri.quantity) FROM sale_return_items ri WHERE ri.sale_item_id = si.id), 0),
+        COALESCE((SELECT array_agg(ps.serial_number ORDER BY ps.serial_number) FROM product_serials ps WHERE ps.sale_item_id = si.id), '{}')
+        FROM sale_items si LEFT JOIN units u ON u.id = si.unit_id WHERE si.sale_id=$1 ORDER BY si.id`, s.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load items"})
+		return
+	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var i models.SaleItem
-		rows.Scan(&i.ProductName, &i.Quantity, &i.UnitPrice, &i.TotalPrice)
+		var taxDetails []byte
+		rows.Scan(&i.ID, &i.ProductID, &i.VariantID, &i.ProductName, &i.Quantity, &i.UnitID, &i.Unit, &i.UnitFactor, &i.UnitPrice, &i.TotalPrice, &i.DiscountAmount, &i.NetAmount, &i.TaxAmount, &taxDetails, &i.ReturnedQty, pq.Array(&i.Serials))
+		json.Unmarshal(taxDetails, &i.Taxes)
+		s.TaxBreakdown = mergeTaxLines(s.TaxBreakdown, i.Taxes)
 		s.Items = append(s.Items, i)
 	}
 
+	s.Payments, err = loadSalePayments(s.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load payments"})
+		return
+	}
+
 	c.JSON(200, s)
 }
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+const (
+	catalogPageSize    = 500
+	maxCatalogPageSize = 2000
+)
+
+// catalogSelect is a product as the offline catalog carries it, with its stock at
+// the branch ($2); stock of products with variants is the sum over the variants
+const catalogSelect = `SELECT p.id, p.name, p.sku, COALESCE(p.barcode, ''), p.price, COALESCE(p.is_active, true), COALESCE(p.tax_class_id::text, ''),
+        p.track_lots, p.track_serials, p.product_type, COALESCE(p.category, ''),
+        COALESCE(p.unit_id::text, ''), COALESCE(u.short_name, ''), COALESCE(u.allow_decimals, false), COALESCE(p.sale_unit_id::text, ''), p.sale_unit_factor,
+        COALESCE((SELECT SUM(i.quantity) FROM inventory_stock i WHERE i.product_id = p.id AND i.branch_id = $2), 0)
+    FROM products p LEFT JOIN units u ON u.id = p.unit_id`
+
+// catalogChangedSince matches products that changed at or after the cursor ($5):
+// the product, one of its variants, its stock at the branch, or (kits) the stock
+// of a component
+const catalogChangedSince = ` AND (p.catalog_txid >= $5
+        OR EXISTS(SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND v.catalog_txid >= $5)
+        OR EXISTS(SELECT 1 FROM inventory_stock i WHERE i.product_id = p.id AND i.branch_id = $2 AND i.catalog_txid >= $5)
+        OR (p.product_type = 'kit' AND EXISTS(SELECT 1 FROM product_kit_components k
+            JOIN inventory_stock i ON i.product_id = k.component_product_id AND i.variant_id IS NOT DISTINCT FROM k.component_variant_id AND i.branch_id = $2
+            WHERE k.kit_product_id = p.id AND i.catalog_txid >= $5)))`
+
+// GetPOSCatalog returns the full catalog of active products at the POS branch,
+// with variants, prices, barcodes, units and stock, for a terminal to work offline.
+// Paged by ?after (the next_after of the previous page) and ?limit. Keep the
+// cursor of the first page: GetPOSCatalogChanges since it brings the catalog up to date.
+func GetPOSCatalog(c *gin.Context) {
+	respondCatalogPage(c, false)
+}
+
+// GetPOSCatalogChanges returns the products changed since ?since (a catalog
+// cursor), deactivated ones included, each in full with its retired variants
+// flagged inactive: terminals replace their copy of each product returned.
+// Paged like GetPOSCatalog; keep the first page's cursor for the next delta.
+func GetPOSCatalogChanges(c *gin.Context) {
+	respondCatalogPage(c, true)
+}
+
+func respondCatalogPage(c *gin.Context, delta bool) {
+	tenantID := c.GetString("tenantID")
+	branchID := c.GetString("branchID")
+
+	limit := catalogPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCatalogPageSize {
+			c.JSON(400, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxCatalogPageSize)})
+			return
+		}
+		limit = n
+	}
+	var since int64
+	if delta {
+		n, err := strconv.ParseInt(c.Query("since"), 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(400, gin.H{"error": "since must be a catalog cursor"})
+			return
+		}
+		since = n
+	}
+
+	// The cursor is taken before reading: anything written by a transaction still
+	// running now (or later) is at or after it, and comes again in the next delta
+	var cursor int64
+	if err := db.DB.QueryRow("SELECT txid_snapshot_xmin(txid_current_snapshot())").Scan(&cursor); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := catalogSelect + ` WHERE p.tenant_id = $1 AND (NULLIF($3, '') IS NULL OR p.id > NULLIF($3, '')::uuid)`
+	args := []interface{}{tenantID, branchID, c.Query("after"), limit}
+	if delta {
+		query += catalogChangedSince
+		args = append(args, since)
+	} else {
+		query += ` AND COALESCE(p.is_active, true)`
+	}
+	query += ` ORDER BY p.id LIMIT $4`
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(&p.ID, &p.Name, &p.Sku, &p.Barcode, &p.Price, &p.IsActive, &p.TaxClassID, &p.TrackLots, &p.TrackSerials, &p.ProductType, &p.Category,
+			&p.UnitID, &p.Unit, &p.AllowDecimals, &p.SaleUnitID, &p.SaleUnitFactor, &p.StockQuantity); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		p.TenantID = tenantID
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := attachPOSVariants(products, tenantID, branchID, "", delta); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := attachKitStock(products, tenantID, branchID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	nextAfter := ""
+	if len(products) == limit {
+		nextAfter = products[len(products)-1].ID
+	}
+	if products == nil {
+		products = []models.Product{}
+	}
+	c.JSON(200, gin.H{
+		"branch_id":  branchID,
+		"cursor":     strconv.FormatInt(cursor, 10),
+		"products":   products,
+		"has_more":   nextAfter != "",
+		"next_after": nextAfter,
+	})
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+	"github.com/insaansher/sherpos/backend/utils"
+)
+
+const posDeviceSelect = `SELECT d.id, COALESCE(d.name, ''), d.device_hash, COALESCE(d.branch_id::text, ''), COALESCE(b.name, ''),
+        COALESCE(d.user_id::text, ''), COALESCE(u.full_name, ''), COALESCE(d.user_agent, ''), d.status, d.last_active_at, d.revoked_at, d.created_at
+    FROM pos_devices d
+    LEFT JOIN branches b ON b.id = d.branch_id
+    LEFT JOIN users u ON u.id = d.user_id`
+
+func scanPosDevice(row interface{ Scan(...interface{}) error }, d *models.PosDevice) error {
+	return row.Scan(&d.ID, &d.Name, &d.Fingerprint, &d.BranchID, &d.BranchName, &d.EnrolledBy, &d.EnrolledByName, &d.UserAgent,
+		&d.Status, &d.LastActiveAt, &d.RevokedAt, &d.CreatedAt)
+}
+
+// EnrollPOSDevice enrolls the calling terminal and returns its device token,
+// which the terminal sends as X-Device-Token on every POS call. The token is
+// shown only here. A fingerprint enrolls once: one already enrolled is refused (its
+// token is never reissued), and so is one that was revoked.
+func EnrollPOSDevice(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+
+	var req models.EnrollDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BranchID != "" {
+		found, active, allowed, err := services.BranchAccess(tenantID, userID, c.GetString("role"), req.BranchID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if !found || !active {
+			c.JSON(404, gin.H{"error": "Branch not found or inactive"})
+			return
+		}
+		if !allowed {
+			c.JSON(403, gin.H{"error": "You are not assigned to this branch"})
+			return
+		}
+	}
+
+	token, tokenHash, err := utils.GenerateDeviceToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to issue device token"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var locked string
+	if err := tx.QueryRow("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID).Scan(&locked); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM pos_devices WHERE tenant_id=$1 AND device_hash=$2
+        ORDER BY status = 'active' DESC LIMIT 1`, tenantID, req.Fingerprint).Scan(&status)
+	if err == nil && status == "active" {
+		c.JSON(409, gin.H{"error": "This device is already enrolled"})
+		return
+	}
+	if err == nil {
+		c.JSON(403, gin.H{"code": "DEVICE_REVOKED", "error": "This device was revoked and cannot enroll again"})
+		return
+	}
+	if err != sql.ErrNoRows {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkDeviceAllowance(tx, tenantID); err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	var deviceID string
+	err = tx.QueryRow(`INSERT INTO pos_devices (tenant_id, user_id, device_hash, user_agent, name, branch_id, token_hash, status, last_active_at)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7, 'active', now()) RETURNING id`,
+		tenantID, userID, req.Fingerprint, c.Request.UserAgent(), req.Name, req.BranchID, tokenHash).Scan(&deviceID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "Commit failed"})
+		return
+	}
+
+	var d models.PosDevice
+	if err := scanPosDevice(db.DB.QueryRow(posDeviceSelect+" WHERE d.id=$1", deviceID), &d); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"device": d, "token": token})
+}
+
+// checkDeviceAllowance fails once the tenant has as many active devices as its
+// plan allows. The caller holds the tenant row lock.
+func checkDeviceAllowance(tx *sql.Tx, tenantID string) error {
+	limits, _, err := services.GetTenantEntitlements(tenantID)
+	if err != nil {
+		return err
+	}
+	var active int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pos_devices WHERE tenant_id=$1 AND status='active'", tenantID).Scan(&active); err != nil {
+		return err
+	}
+	if active >= limits.PosDeviceLimit {
+		return &planRestrictionError{Code: "PLAN_LIMIT_REACHED", Feature: "pos_device_limit", msg: fmt.Sprintf("Your plan allows %d POS devices; revoke one to enroll this device", limits.PosDeviceLimit)}
+	}
+	return nil
+}
+
+// ListPOSDevices lists the tenant's enrolled devices (?status=active|revoked)
+func ListPOSDevices(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(posDeviceSelect+` WHERE d.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR d.status = $2)
+        ORDER BY d.status, d.last_active_at DESC NULLS LAST`, tenantID, c.Query("status"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var devices []models.PosDevice
+	for rows.Next() {
+		var d models.PosDevice
+		scanPosDevice(rows, &d)
+		devices = append(devices, d)
+	}
+	if devices == nil {
+		devices = []models.PosDevice{}
+	}
+	c.JSON(200, devices)
+}
+
+// UpdatePOSDevice renames a device or moves it to another branch
+func UpdatePOSDevice(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var req models.UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BranchID != "" {
+		var ok bool
+		db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM branches WHERE id=$1 AND tenant_id=$2 AND is_active)", req.BranchID, tenantID).Scan(&ok)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Branch not found or inactive"})
+			return
+		}
+	}
+
+	res, err := db.DB.Exec("UPDATE pos_devices SET name=$1, branch_id=NULLIF($2, '')::uuid WHERE id=$3 AND tenant_id=$4",
+		req.Name, req.BranchID, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Device not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Device updated"})
+}
+
+// RevokePOSDevice invalidates a device's token, freeing its slot. The terminal
+// must enroll again to be used.
+func RevokePOSDevice(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+
+	res, err := db.DB.Exec(`UPDATE pos_devices SET status='revoked', token_hash=NULL, revoked_at=now(), revoked_by=$1
+        WHERE id=$2 AND tenant_id=$3 AND status='active'`, userID, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Active device not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Device revoked"})
+}
@@ -2,17 +2,22 @@ package handlers
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/insaansher/sherpos/backend/db"
 	"github.com/insaansher/sherpos/backend/models"
 )
 
-// ListProducts (Admin/Manager View)
+// ListProducts (Admin/Manager View). Stock is the total over all branches unless ?branch_id is given.
 func ListProducts(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
-	rows, err := db.DB.Query(`SELECT p.id, p.name, p.sku, p.barcode, p.price, p.cost_price, p.is_active, COALESCE(sum(i.quantity), 0) 
-        FROM products p LEFT JOIN inventory_stock i ON p.id=i.product_id WHERE p.tenant_id=$1 GROUP BY p.id ORDER BY p.name`, tenantID)
+	branchID := c.Query("branch_id")
+	rows, err := db.DB.Query(`SELECT p.id, p.name, p.sku, p.barcode, p.price, p.cost_price, p.is_active, COALESCE(p.tax_class_id::text, ''), p.track_lots, p.track_serials, COALESCE(sum(i.quantity), 0), p.product_type, COALESCE(p.category, ''),
+            COALESCE(p.unit_id::text, ''), COALESCE(u.short_name, ''), COALESCE(u.allow_decimals, false)
+        FROM products p LEFT JOIN inventory_stock i ON p.id=i.product_id AND (NULLIF($2, '') IS NULL OR i.branch_id = NULLIF($2, '')::uuid)
+        LEFT JOIN units u ON u.id = p.unit_id
+        WHERE p.tenant_id=$1 GROUP BY p.id, u.id ORDER BY p.name`, tenantID, branchID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -24,7 +29,8 @@ func ListProducts(c *gin.Context) {
 		var p models.Product
 		var bc sql.NullString // omitted in query scan but struct has it
 		// simplified scan matches query columns
-		rows.Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.CostPrice, &p.IsActive, &p.StockQuantity)
+		rows.Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.CostPrice, &p.IsActive, &p.TaxClassID, &p.TrackLots, &p.TrackSerials, &p.StockQuantity, &p.ProductType, &p.Category,
+			&p.UnitID, &p.Unit, &p.AllowDecimals)
 		p.Barcode = bc.String
 		products = append(products, p)
 	}
@@ -39,13 +45,25 @@ func GetProduct(c *gin.Context) {
 	id := c.Param("id")
 	var p models.Product
 	var bc sql.NullString
-	err := db.DB.QueryRow(`SELECT id, name, sku, barcode, price, cost_price, is_active FROM products WHERE id=$1 AND tenant_id=$2`, id, tenantID).
-		Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.CostPrice, &p.IsActive)
+	err := db.DB.QueryRow(`SELECT p.id, p.name, p.sku, p.barcode, p.price, p.cost_price, p.is_active, COALESCE(p.tax_class_id::text, ''), p.track_lots, p.track_serials, p.product_type, COALESCE(p.category, ''),
+            p.reorder_level, p.max_level, p.reorder_qty, COALESCE(p.preferred_supplier_id::text, ''),
+            COALESCE(p.unit_id::text, ''), COALESCE(u.short_name, ''), COALESCE(u.allow_decimals, false),
+            COALESCE(p.purchase_unit_id::text, ''), p.purchase_unit_factor, COALESCE(p.sale_unit_id::text, ''), p.sale_unit_factor
+        FROM products p LEFT JOIN units u ON u.id = p.unit_id WHERE p.id=$1 AND p.tenant_id=$2`, id, tenantID).
+		Scan(&p.ID, &p.Name, &p.Sku, &bc, &p.Price, &p.CostPrice, &p.IsActive, &p.TaxClassID, &p.TrackLots, &p.TrackSerials, &p.ProductType, &p.Category,
+			&p.ReorderLevel, &p.MaxLevel, &p.ReorderQty, &p.PreferredSupplierID,
+			&p.UnitID, &p.Unit, &p.AllowDecimals, &p.PurchaseUnitID, &p.PurchaseUnitFactor, &p.SaleUnitID, &p.SaleUnitFactor)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Not found"})
 		return
 	}
 	p.Barcode = bc.String
+	if p.ProductType == "kit" {
+		if p.Components, err = loadKitComponents(tenantID, id, ""); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	c.JSON(200, p)
 }
 
@@ -57,17 +75,45 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	if !taxClassBelongsToTenant(tenantID, req.TaxClassID) {
+		c.JSON(400, gin.H{"error": "Tax class not found"})
+		return
+	}
+	if !supplierBelongsToTenant(tenantID, req.PreferredSupplierID) {
+		c.JSON(400, gin.H{"error": "Supplier not found"})
+		return
+	}
+	if msg := checkReorderLevels(req.ReorderLevel, req.MaxLevel, req.ReorderQty); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	if msg := checkProductUnits(tenantID, &req); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	if msg := checkProductType(tenantID, "", &req); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
 	var id string
-	err := db.DB.QueryRow(`INSERT INTO products (tenant_id, name, sku, barcode, price, cost_price, is_active) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		tenantID, req.Name, req.Sku, req.Barcode, req.Price, req.CostPrice, true).Scan(&id)
+	err := db.DB.QueryRow(`INSERT INTO products (tenant_id, name, sku, barcode, price, cost_price, is_active, tax_class_id, track_lots, track_serials, reorder_level, max_level, reorder_qty, preferred_supplier_id,
+            unit_id, purchase_unit_id, purchase_unit_factor, sale_unit_id, sale_unit_factor, product_type, category) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, '')::uuid, $9, $10, $11, $12, $13, NULLIF($14, '')::uuid,
+            NULLIF($15, '')::uuid, NULLIF($16, '')::uuid, $17, NULLIF($18, '')::uuid, $19, $20, NULLIF($21, '')) RETURNING id`,
+		tenantID, req.Name, req.Sku, req.Barcode, req.Price, req.CostPrice, true, req.TaxClassID, req.TrackLots, req.TrackSerials,
+		req.ReorderLevel, req.MaxLevel, req.ReorderQty, req.PreferredSupplierID,
+		req.UnitID, req.PurchaseUnitID, req.PurchaseUnitFactor, req.SaleUnitID, req.SaleUnitFactor, req.ProductType, strings.TrimSpace(req.Category)).Scan(&id)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Initial stock 0
-	db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, 0)", tenantID, id)
+	// Initial stock 0 at the default branch; other branches get a row on their first movement.
+	// Kits are stocked through their components.
+	if req.ProductType == "standard" {
+		db.DB.Exec("INSERT INTO inventory_stock (tenant_id, product_id, branch_id, quantity) SELECT $1, $2, id, 0 FROM branches WHERE tenant_id=$1 AND is_default", tenantID, id)
+	}
 	c.JSON(201, gin.H{"id": id})
 }
 
@@ -80,11 +126,40 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	_, err := db.DB.Exec(`UPDATE products SET name=$1, sku=$2, barcode=$3, price=$4, cost_price=$5, is_active=$6 WHERE id=$7 AND tenant_id=$8`,
-		req.Name, req.Sku, req.Barcode, req.Price, req.CostPrice, req.IsActive, id, tenantID)
+	if !taxClassBelongsToTenant(tenantID, req.TaxClassID) {
+		c.JSON(400, gin.H{"error": "Tax class not found"})
+		return
+	}
+	if !supplierBelongsToTenant(tenantID, req.PreferredSupplierID) {
+		c.JSON(400, gin.H{"error": "Supplier not found"})
+		return
+	}
+	if msg := checkReorderLevels(req.ReorderLevel, req.MaxLevel, req.ReorderQty); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	if msg := checkProductUnits(tenantID, &req); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+	if msg := checkProductType(tenantID, id, &req); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	_, err := db.DB.Exec(`UPDATE products SET name=$1, sku=$2, barcode=$3, price=$4, cost_price=$5, is_active=$6, tax_class_id=NULLIF($7, '')::uuid, track_lots=$8, track_serials=$9,
+            reorder_level=$10, max_level=$11, reorder_qty=$12, preferred_supplier_id=NULLIF($13, '')::uuid,
+            unit_id=NULLIF($14, '')::uuid, purchase_unit_id=NULLIF($15, '')::uuid, purchase_unit_factor=$16, sale_unit_id=NULLIF($17, '')::uuid, sale_unit_factor=$18,
+            product_type=$19, category=NULLIF($20, ''), updated_at=now() WHERE id=$21 AND tenant_id=$22`,
+		req.Name, req.Sku, req.Barcode, req.Price, req.CostPrice, req.IsActive, req.TaxClassID, req.TrackLots, req.TrackSerials,
+		req.ReorderLevel, req.MaxLevel, req.ReorderQty, req.PreferredSupplierID,
+		req.UnitID, req.PurchaseUnitID, req.PurchaseUnitFactor, req.SaleUnitID, req.SaleUnitFactor, req.ProductType, strings.TrimSpace(req.Category), id, tenantID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if req.ProductType != "kit" {
+		db.DB.Exec("DELETE FROM product_kit_components WHERE kit_product_id=$1 AND tenant_id=$2", id, tenantID)
+	}
 	c.JSON(200, gin.H{"message": "Updated"})
 }
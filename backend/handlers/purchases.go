@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
 
 	"github.com/gin-gonic/gin"
 	"github.com/insaansher/sherpos/backend/db"
@@ -10,8 +13,11 @@ import (
 
 func ListPurchases(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
-	rows, err := db.DB.Query(`SELECT p.id, p.reference_no, p.grand_total, p.status, p.created_at, s.name 
-        FROM purchases p LEFT JOIN suppliers s ON p.supplier_id=s.id WHERE p.tenant_id=$1 ORDER BY p.created_at DESC`, tenantID)
+	branchID := c.Query("branch_id")
+	rows, err := db.DB.Query(`SELECT p.id, COALESCE(p.branch_id::text, ''), p.reference_no, COALESCE(p.subtotal, 0), COALESCE(p.tax_total, 0), p.grand_total, p.status, p.is_suggested, p.created_at, s.name 
+        FROM purchases p LEFT JOIN suppliers s ON p.supplier_id=s.id
+        WHERE p.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR p.branch_id = NULLIF($2, '')::uuid)
+        ORDER BY p.created_at DESC`, tenantID, branchID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -21,7 +27,7 @@ func ListPurchases(c *gin.Context) {
 	for rows.Next() {
 		var p models.Purchase
 		var sName sql.NullString
-		rows.Scan(&p.ID, &p.ReferenceNo, &p.GrandTotal, &p.Status, &p.CreatedAt, &sName)
+		rows.Scan(&p.ID, &p.BranchID, &p.ReferenceNo, &p.Subtotal, &p.TaxTotal, &p.GrandTotal, &p.Status, &p.IsSuggested, &p.CreatedAt, &sName)
 		p.SupplierName = sName.String
 		purchases = append(purchases, p)
 	}
@@ -33,11 +39,19 @@ func ListPurchases(c *gin.Context) {
 
 func CreatePurchase(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
+	branchID := c.GetString("branchID")
 	var req models.CreatePurchaseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Status == "" {
+		req.Status = "draft"
+	}
+	if req.Status != "draft" && req.Status != "received" {
+		c.JSON(400, gin.H{"error": "status must be draft or received"})
+		return
+	}
 
 	tx, err := db.DB.Begin()
 	if err != nil {
@@ -46,42 +60,87 @@ func CreatePurchase(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	// Calc totals
-	var subtotal float64
-	for _, item := range req.Items {
-		subtotal += item.CostPrice * float64(item.Quantity)
+	// Price & tax each line from the product's tax class
+	type purchaseLine struct {
+		name       string
+		unitID     string
+		unitFactor float64
+		lineTotal  float64
+		net        float64
+		tax        float64
+		taxDetails []byte
+	}
+	lines := make([]purchaseLine, len(req.Items))
+	rates := taxRateCache{}
+	var subtotal, taxTotal float64
+	for i, item := range req.Items {
+		if err := checkStockItem(tx, tenantID, item.ProductID, item.VariantID, false); err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		var name, productType string
+		var taxClassID sql.NullString
+		err := tx.QueryRow("SELECT name, tax_class_id, product_type FROM products WHERE id=$1 AND tenant_id=$2", item.ProductID, tenantID).Scan(&name, &taxClassID, &productType)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Product " + item.ProductID + " not found"})
+			return
+		}
+		if productType == "kit" {
+			c.JSON(400, gin.H{"error": name + " is a kit; purchase its components instead"})
+			return
+		}
+		classRates, err := rates.classRates(tx, tenantID, taxClassID.String)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		// Bought in the product's purchase unit unless the line names another of its units
+		unitID, factor, err := lineUnit(tx, tenantID, item.ProductID, item.UnitID, "purchase")
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		lineTotal := roundMoney(item.CostPrice * item.Quantity)
+		net, tax, taxes := computeLineTax(lineTotal, classRates, req.PricesIncludeTax)
+		taxDetails, _ := json.Marshal(taxes)
+		lines[i] = purchaseLine{name: name, unitID: unitID, unitFactor: factor, lineTotal: lineTotal, net: net, tax: tax, taxDetails: taxDetails}
+		subtotal += net
+		taxTotal += tax
 	}
-	grandTotal := subtotal // + tax handles later
+	subtotal = roundMoney(subtotal)
+	taxTotal = roundMoney(taxTotal)
+	grandTotal := roundMoney(subtotal + taxTotal)
 
 	var purchaseID string
-	err = tx.QueryRow(`INSERT INTO purchases (tenant_id, supplier_id, reference_no, subtotal, grand_total, status, notes) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		tenantID, req.SupplierID, req.ReferenceNo, subtotal, grandTotal, req.Status, req.Notes).Scan(&purchaseID)
+	err = tx.QueryRow(`INSERT INTO purchases (tenant_id, branch_id, supplier_id, reference_no, subtotal, tax_total, grand_total, status, notes, prices_include_tax) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7, 'draft', $8, $9) RETURNING id`,
+		tenantID, branchID, req.SupplierID, req.ReferenceNo, subtotal, taxTotal, grandTotal, req.Notes, req.PricesIncludeTax).Scan(&purchaseID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	for _, item := range req.Items {
-		// Get snapshot name
-		var name string
-		tx.QueryRow("SELECT name FROM products WHERE id=$1", item.ProductID).Scan(&name)
-
-		_, err = tx.Exec(`INSERT INTO purchase_items (purchase_id, product_id, name_snapshot, cost_price, quantity, line_total)
-            VALUES ($1, $2, $3, $4, $5, $6)`,
-			purchaseID, item.ProductID, name, item.CostPrice, item.Quantity, item.CostPrice*float64(item.Quantity))
+	receipt := make([]models.GoodsReceiptItemRequest, len(req.Items))
+	for i, item := range req.Items {
+		l := lines[i]
+		var itemID string
+		err = tx.QueryRow(`INSERT INTO purchase_items (purchase_id, product_id, variant_id, name_snapshot, unit_id, unit_factor, cost_price, quantity, line_total, net_amount, tax_amount, tax_details)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, NULLIF($5, '')::uuid, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+			purchaseID, item.ProductID, item.VariantID, l.name, l.unitID, l.unitFactor, item.CostPrice, item.Quantity, l.lineTotal, l.net, l.tax, string(l.taxDetails)).Scan(&itemID)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Item insert failed"})
 			return
 		}
+		receipt[i] = models.GoodsReceiptItemRequest{PurchaseItemID: itemID, Quantity: item.Quantity, LotNumber: item.LotNumber, ExpiryDate: item.ExpiryDate, Serials: item.Serials}
+	}
 
-		// If status is 'received', update stock
-		if req.Status == "received" {
-			err = updateStockHelper(tx, tenantID, item.ProductID, item.Quantity, "purchase", purchaseID, "Purchase Received")
-			if err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
-				return
-			}
+	// Received on creation: one goods receipt for the whole order
+	if req.Status == "received" {
+		if _, _, _, err := receiveGoods(tx, tenantID, c.GetString("userID"), purchaseID, branchID, receipt, "Received on entry"); err != nil {
+			respondTxError(c, err)
+			return
 		}
 	}
 
@@ -89,9 +148,64 @@ func CreatePurchase(c *gin.Context) {
 	c.JSON(201, gin.H{"id": purchaseID, "message": "Purchase created"})
 }
 
+// GetPurchase returns a purchase with its lines (received and outstanding
+// quantities) and its goods receipts
+func GetPurchase(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var p models.Purchase
+	var supplierID, sName, notes sql.NullString
+	var receivedAt sql.NullTime
+	err := db.DB.QueryRow(`SELECT p.id, p.supplier_id::text, COALESCE(p.branch_id::text, ''), p.reference_no, COALESCE(p.subtotal, 0), COALESCE(p.tax_total, 0), p.grand_total, p.status, p.is_suggested, p.notes, p.created_at, p.received_at, s.name
+        FROM purchases p LEFT JOIN suppliers s ON p.supplier_id=s.id
+        WHERE p.id=$1 AND p.tenant_id=$2`, id, tenantID).
+		Scan(&p.ID, &supplierID, &p.BranchID, &p.ReferenceNo, &p.Subtotal, &p.TaxTotal, &p.GrandTotal, &p.Status, &p.IsSuggested, &notes, &p.CreatedAt, &receivedAt, &sName)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Purchase not found"})
+		return
+	}
+	p.SupplierID = supplierID.String
+	p.SupplierName = sName.String
+	p.Notes = notes.String
+	if receivedAt.Valid {
+		p.ReceivedAt = receivedAt.Time
+	}
+
+	rows, err := db.DB.Query(`SELECT pi.id, pi.product_id, COALESCE(pi.variant_id::text, ''), COALESCE(pi.name_snapshot, ''), COALESCE(pi.unit_id::text, ''), COALESCE(u.short_name, ''), pi.unit_factor,
+            pi.cost_price, pi.quantity, pi.quantity_received, COALESCE(pi.line_total, 0)
+        FROM purchase_items pi LEFT JOIN units u ON u.id = pi.unit_id WHERE pi.purchase_id=$1 ORDER BY pi.id`, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	p.Items = []models.PurchaseItem{}
+	for rows.Next() {
+		var it models.PurchaseItem
+		rows.Scan(&it.ID, &it.ProductID, &it.VariantID, &it.Name, &it.UnitID, &it.Unit, &it.UnitFactor, &it.CostPrice, &it.Quantity, &it.QuantityReceived, &it.LineTotal)
+		it.Outstanding = roundQty(it.Quantity - it.QuantityReceived)
+		p.Items = append(p.Items, it)
+	}
+	rows.Close()
+
+	p.Receipts, err = loadPurchaseReceipts(tenantID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, p)
+}
+
+// ReceivePurchase books a goods receipt against a purchase. The body is optional:
+// without items every outstanding quantity is received.
 func ReceivePurchase(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
 	id := c.Param("id")
+	var req models.GoodsReceiptRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
 	tx, err := db.DB.Begin()
 	if err != nil {
@@ -100,8 +214,10 @@ func ReceivePurchase(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	var status string
-	err = tx.QueryRow("SELECT status FROM purchases WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).Scan(&status)
+	// Goods arrive at the branch the purchase was raised for
+	var status, branchID string
+	var suggested bool
+	err = tx.QueryRow("SELECT status, COALESCE(branch_id::text, $3), is_suggested FROM purchases WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID, c.GetString("branchID")).Scan(&status, &branchID, &suggested)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Purchase not found"})
 		return
@@ -110,40 +226,21 @@ func ReceivePurchase(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Already received"})
 		return
 	}
-
-	_, err = tx.Exec("UPDATE purchases SET status='received', received_at=now() WHERE id=$1", id)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Update failed"})
+	if status == "cancelled" {
+		c.JSON(400, gin.H{"error": "Purchase is cancelled"})
 		return
 	}
-
-	// Process items
-	rows, err := tx.Query("SELECT product_id, quantity FROM purchase_items WHERE purchase_id=$1", id)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Items fetch failed"})
+	if suggested {
+		c.JSON(400, gin.H{"error": "Confirm the suggested purchase before receiving it"})
 		return
 	}
 
-	type pi struct {
-		pid string
-		qty int
-	}
-	var items []pi
-	for rows.Next() {
-		var i pi
-		rows.Scan(&i.pid, &i.qty)
-		items = append(items, i)
-	}
-	rows.Close()
-
-	for _, item := range items {
-		err = updateStockHelper(tx, tenantID, item.pid, item.qty, "purchase", id, "Purchase Received (Late)")
-		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
-			return
-		}
+	receiptID, receiptNumber, status, err := receiveGoods(tx, tenantID, c.GetString("userID"), id, branchID, req.Items, req.Notes)
+	if err != nil {
+		respondTxError(c, err)
+		return
 	}
 
 	tx.Commit()
-	c.JSON(200, gin.H{"message": "Purchase Received"})
+	c.JSON(200, gin.H{"message": "Goods Received", "receipt_id": receiptID, "receipt_number": receiptNumber, "status": status})
 }
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/lib/pq"
+)
+
+const quotationSelect = `SELECT q.id, q.reference_no, COALESCE(q.branch_id::text, ''), COALESCE(q.customer_id::text, ''), COALESCE(cu.name, ''),
+        q.status, to_char(q.valid_until, 'YYYY-MM-DD'), q.total_amount, q.discount_amount, q.tax_amount, q.final_amount, COALESCE(q.notes, ''),
+        COALESCE(q.sale_id::text, ''), COALESCE(s.invoice_number, ''), q.created_at, q.converted_at
+    FROM quotations q
+    LEFT JOIN customers cu ON cu.id = q.customer_id
+    LEFT JOIN sales s ON s.id = q.sale_id`
+
+func scanQuotation(row interface{ Scan(...interface{}) error }, q *models.Quotation) error {
+	return row.Scan(&q.ID, &q.ReferenceNo, &q.BranchID, &q.CustomerID, &q.CustomerName, &q.Status, &q.ValidUntil,
+		&q.TotalAmount, &q.DiscountAmount, &q.TaxAmount, &q.FinalAmount, &q.Notes, &q.SaleID, &q.InvoiceNumber, &q.CreatedAt, &q.ConvertedAt)
+}
+
+// quotationOpen reports whether a quotation in this stored status can still be
+// accepted or converted, validity permitting
+func quotationOpen(status string) bool {
+	return status == "draft" || status == "sent" || status == "accepted"
+}
+
+// expireQuotation reports an open quotation past its validity date (against
+// the tenant's today, YYYY-MM-DD) as expired. Expiry is worked out on reading
+// rather than stored.
+func expireQuotation(q *models.Quotation, today string) {
+	if quotationOpen(q.Status) && q.ValidUntil < today {
+		q.Status = "expired"
+	}
+}
+
+// ListQuotations lists quotations, newest first, by ?status and ?customer_id
+func ListQuotations(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	today := tenantToday(db.DB, tenantID)
+
+	rows, err := db.DB.Query(quotationSelect+`
+        WHERE q.tenant_id=$1
+          AND (NULLIF($2, '') IS NULL OR $2 = CASE WHEN q.status IN ('draft', 'sent', 'accepted') AND q.valid_until < $4::date THEN 'expired' ELSE q.status END)
+          AND (NULLIF($3, '') IS NULL OR q.customer_id = NULLIF($3, '')::uuid)
+        ORDER BY q.created_at DESC LIMIT 100`, tenantID, c.Query("status"), c.Query("customer_id"), today)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	quotations := []models.Quotation{}
+	for rows.Next() {
+		var q models.Quotation
+		scanQuotation(rows, &q)
+		expireQuotation(&q, today)
+		quotations = append(quotations, q)
+	}
+	c.JSON(200, quotations)
+}
+
+func GetQuotation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	q, err := loadQuotation(tenantID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, q)
+}
+
+func CreateQuotation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	var req models.QuotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	validUntil, ok := quotationValidity(c, tenantID, req.ValidUntil)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Serialise numbering per tenant
+	if _, err := tx.Exec("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	ref := req.ReferenceNo
+	if ref == "" {
+		// Next after the highest automatic number, so hand-typed references
+		// (even ones in the QUO- pattern) are never handed out again
+		var last int64
+		err := tx.QueryRow(`SELECT COALESCE(MAX(SUBSTRING(reference_no FROM 5)::bigint), 0) FROM quotations
+            WHERE tenant_id=$1 AND reference_no ~ '^QUO-[0-9]{1,18}$'`, tenantID).Scan(&last)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		ref = fmt.Sprintf("QUO-%06d", last+1)
+	}
+	var taken bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM quotations WHERE tenant_id=$1 AND reference_no=$2)", tenantID, ref).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "Reference number already in use"})
+		return
+	}
+
+	var id string
+	err = tx.QueryRow(`INSERT INTO quotations (tenant_id, branch_id, reference_no, valid_until, created_by)
+        VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5) RETURNING id`,
+		tenantID, c.GetString("branchID"), ref, validUntil, userID).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals, err := saveQuotationLines(tx, tenantID, id, req, validUntil)
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": id, "reference_no": ref, "final_amount": totals.Final})
+}
+
+// UpdateQuotation replaces the lines and terms of a draft or sent quotation,
+// repricing it at today's prices
+func UpdateQuotation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.QuotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	validUntil, ok := quotationValidity(c, tenantID, req.ValidUntil)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow("SELECT status FROM quotations WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).Scan(&status); err != nil {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+	if status != "draft" && status != "sent" {
+		c.JSON(400, gin.H{"error": "Only draft or sent quotations can be edited (this one is " + status + ")"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM quotation_items WHERE quotation_id=$1", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	totals, err := saveQuotationLines(tx, tenantID, id, req, validUntil)
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Updated", "final_amount": totals.Final})
+}
+
+// SendQuotation marks a draft quotation as sent to the customer
+func SendQuotation(c *gin.Context) {
+	setQuotationStatus(c, "sent", "draft")
+}
+
+// AcceptQuotation records the customer accepting a quotation
+func AcceptQuotation(c *gin.Context) {
+	setQuotationStatus(c, "accepted", "draft", "sent")
+}
+
+func setQuotationStatus(c *gin.Context, to string, from ...string) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	today := tenantToday(db.DB, tenantID)
+
+	q := models.Quotation{}
+	if err := db.DB.QueryRow("SELECT status, to_char(valid_until, 'YYYY-MM-DD') FROM quotations WHERE id=$1 AND tenant_id=$2", id, tenantID).
+		Scan(&q.Status, &q.ValidUntil); err != nil {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+	expireQuotation(&q, today)
+	status := q.Status
+
+	res, err := db.DB.Exec("UPDATE quotations SET status=$1, updated_at=now() WHERE id=$2 AND tenant_id=$3 AND status = ANY($4) AND valid_until >= $5::date",
+		to, id, tenantID, pq.Array(from), today)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Quotation is %s and cannot be marked %s", status, to)})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Quotation " + to})
+}
+
+// ConvertQuotation turns an open quotation into a sale at the current branch,
+// at the quoted unit prices and discount. Stock, serials, tax, invoice numbering
+// and payments go through the same path as a POS sale; tax is charged at
+// today's rates.
+func ConvertQuotation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.ConvertQuotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var ref, status, customerID string
+	var discount float64
+	var expired bool
+	err = tx.QueryRow(`SELECT reference_no, status, COALESCE(customer_id::text, ''), discount_amount, valid_until < $3::date
+        FROM quotations WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, id, tenantID, tenantToday(tx, tenantID)).Scan(&ref, &status, &customerID, &discount, &expired)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+	if expired && quotationOpen(status) {
+		status = "expired"
+	}
+	if !quotationOpen(status) {
+		c.JSON(400, gin.H{"error": "Quotation is " + status + " and cannot be converted"})
+		return
+	}
+
+	rows, err := tx.Query(`SELECT id, product_id, COALESCE(variant_id::text, ''), quantity, COALESCE(unit_id::text, ''), unit_price
+        FROM quotation_items WHERE quotation_id=$1 ORDER BY id`, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var items []models.SaleItemRequest
+	for rows.Next() {
+		var itemID string
+		var it models.SaleItemRequest
+		rows.Scan(&itemID, &it.ProductID, &it.VariantID, &it.Quantity, &it.UnitID, &it.UnitPrice)
+		it.Serials = req.Serials[itemID]
+		items = append(items, it)
+	}
+	rows.Close()
+
+	res, err := postSale(tx, tenantID, userID, saleInput{
+		BranchID:        c.GetString("branchID"),
+		CustomerID:      customerID,
+		Items:           items,
+		DiscountAmount:  discount,
+		PaymentMethod:   req.PaymentMethod,
+		PaymentReceived: req.PaymentReceived,
+		Payments:        req.Payments,
+		StockNote:       "Quotation " + ref,
+		LockedPrices:    true,
+	})
+	if err != nil {
+		respondTxError(c, err)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE quotations SET status='converted', sale_id=$1, converted_at=now(), updated_at=now() WHERE id=$2", res.SaleID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "Commit failed"})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message":         "Quotation converted",
+		"sale_id":         res.SaleID,
+		"invoice_number":  res.InvoiceNumber,
+		"total_amount":    res.TotalAmount,
+		"discount_amount": res.DiscountAmount,
+		"tax_amount":      res.TaxAmount,
+		"tax_breakdown":   res.Taxes,
+		"final_amount":    res.FinalAmount,
+		"change_due":      res.Tenders.ChangeDue,
+		"payments":        res.Tenders.Payments,
+		"points_earned":   res.PointsEarned,
+		"points_redeemed": res.PointsRedeemed,
+	})
+}
+
+// ExportQuotation renders a quotation for the customer: ?format=html (default)
+// is a printable page, ?format=csv a spreadsheet of its lines
+func ExportQuotation(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	q, err := loadQuotation(tenantID, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var business, currency string
+	db.DB.QueryRow("SELECT name, COALESCE(currency, 'USD') FROM tenants WHERE id=$1", tenantID).Scan(&business, &currency)
+
+	switch c.DefaultQuery("format", "html") {
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, q.ReferenceNo))
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"Quotation", q.ReferenceNo})
+		w.Write([]string{"Customer", q.CustomerName})
+		w.Write([]string{"Valid until", q.ValidUntil})
+		w.Write([]string{"Currency", currency})
+		w.Write(nil)
+		w.Write([]string{"Product", "Quantity", "Unit", "Unit price", "Total", "Discount", "Net", "Tax"})
+		for _, it := range q.Items {
+			w.Write([]string{it.ProductName, fmt.Sprint(it.Quantity), it.Unit, formatMoney(it.UnitPrice), formatMoney(it.TotalPrice),
+				formatMoney(it.DiscountAmount), formatMoney(it.NetAmount), formatMoney(it.TaxAmount)})
+		}
+		w.Write(nil)
+		w.Write([]string{"Subtotal", formatMoney(q.TotalAmount)})
+		w.Write([]string{"Discount", formatMoney(q.DiscountAmount)})
+		w.Write([]string{"Tax", formatMoney(q.TaxAmount)})
+		w.Write([]string{"Total", formatMoney(q.FinalAmount)})
+		w.Flush()
+	case "html":
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if err := quotationPage.Execute(c.Writer, gin.H{"Q": q, "Business": business, "Currency": currency}); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+		}
+	default:
+		c.JSON(400, gin.H{"error": "format must be html or csv"})
+	}
+}
+
+func formatMoney(v float64) string { return fmt.Sprintf("%.2f", v) }
+
+var quotationPage = template.Must(template.New("quotation").Funcs(template.FuncMap{"money": formatMoney}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Quotation {{.Q.ReferenceNo}}</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse;width:100%}th,td{border-bottom:1px solid #ccc;padding:4px;text-align:left}td.n,th.n{text-align:right}</style>
+</head><body>
+<h1>{{.Business}}</h1>
+<h2>Quotation {{.Q.ReferenceNo}}</h2>
+<p>{{if .Q.CustomerName}}For: {{.Q.CustomerName}}<br>{{end}}Date: {{.Q.CreatedAt.Format "2006-01-02"}}<br>Valid until: {{.Q.ValidUntil}}</p>
+<table>
+<tr><th>Product</th><th class="n">Qty</th><th>Unit</th><th class="n">Unit price</th><th class="n">Total</th></tr>
+{{range .Q.Items}}<tr><td>{{.ProductName}}</td><td class="n">{{.Quantity}}</td><td>{{.Unit}}</td><td class="n">{{money .UnitPrice}}</td><td class="n">{{money .TotalPrice}}</td></tr>
+{{end}}</table>
+<p style="text-align:right">Subtotal: {{money .Q.TotalAmount}}<br>{{if .Q.DiscountAmount}}Discount: -{{money .Q.DiscountAmount}}<br>{{end}}Tax: {{money .Q.TaxAmount}}<br><strong>Total: {{money .Q.FinalAmount}} {{.Currency}}</strong></p>
+{{if .Q.Notes}}<p>{{.Q.Notes}}</p>{{end}}
+</body></html>
+`))
+
+func loadQuotation(tenantID, id string) (*models.Quotation, error) {
+	var q models.Quotation
+	if err := scanQuotation(db.DB.QueryRow(quotationSelect+" WHERE q.id=$1 AND q.tenant_id=$2", id, tenantID), &q); err != nil {
+		return nil, err
+	}
+	expireQuotation(&q, tenantToday(db.DB, tenantID))
+
+	rows, err := db.DB.Query(`SELECT qi.id, qi.product_id, COALESCE(qi.variant_id::text, ''), qi.product_name, qi.quantity,
+            COALESCE(qi.unit_id::text, ''), COALESCE(u.short_name, ''), qi.unit_factor, qi.unit_price, qi.total_price,
+            qi.discount_amount, qi.net_amount, qi.tax_amount, COALESCE(qi.tax_details, '[]')
+        FROM quotation_items qi LEFT JOIN units u ON u.id = qi.unit_id WHERE qi.quotation_id=$1 ORDER BY qi.id`, q.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	q.Items = []models.QuotationItem{}
+	for rows.Next() {
+		var i models.QuotationItem
+		var taxDetails []byte
+		rows.Scan(&i.ID, &i.ProductID, &i.VariantID, &i.ProductName, &i.Quantity, &i.UnitID, &i.Unit, &i.UnitFactor, &i.UnitPrice, &i.TotalPrice,
+			&i.DiscountAmount, &i.NetAmount, &i.TaxAmount, &taxDetails)
+		json.Unmarshal(taxDetails, &i.Taxes)
+		q.TaxBreakdown = mergeTaxLines(q.TaxBreakdown, i.Taxes)
+		q.Items = append(q.Items, i)
+	}
+	return &q, rows.Err()
+}
+
+// quotationValidity parses the validity date of a quotation (default 30 days
+// out from the tenant's today), answering 400 when it is malformed or already past
+func quotationValidity(c *gin.Context, tenantID, s string) (time.Time, bool) {
+	y, m, d := time.Now().In(tenantLocation(db.DB, tenantID)).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if s == "" {
+		return today.AddDate(0, 0, 30), true
+	}
+	date, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "valid_until must be YYYY-MM-DD"})
+		return time.Time{}, false
+	}
+	if date.Before(today) {
+		c.JSON(400, gin.H{"error": "valid_until cannot be in the past"})
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// saveQuotationLines prices a quotation's lines at the current product prices,
+// locking them in, and sets its customer, terms and totals
+func saveQuotationLines(tx *sql.Tx, tenantID, id string, req models.QuotationRequest, validUntil time.Time) (*saleTotals, error) {
+	if err := checkSaleCustomer(tx, tenantID, req.CustomerID); err != nil {
+		return nil, err
+	}
+	inclusive, err := tenantPricesIncludeTax(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]saleLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		l, err := priceSaleItem(tx, tenantID, item, false)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	totals, err := taxSaleLines(tx, tenantID, lines, req.DiscountAmount, inclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range lines {
+		taxDetails, _ := json.Marshal(l.Taxes)
+		_, err := tx.Exec(`INSERT INTO quotation_items (tenant_id, quotation_id, product_id, variant_id, product_name, quantity, unit_id, unit_factor, unit_price, total_price, discount_amount, net_amount, tax_amount, tax_details)
+            VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6, NULLIF($7, '')::uuid, $8, $9, $10, $11, $12, $13, $14)`,
+			tenantID, id, l.ProductID, l.VariantID, l.ProductName, l.Quantity, l.UnitID, l.UnitFactor, l.UnitPrice, l.TotalPrice, l.DiscountAmount, l.NetAmount, l.TaxAmount, string(taxDetails))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE quotations SET customer_id=NULLIF($1, '')::uuid, valid_until=$2, notes=$3, total_amount=$4, discount_amount=$5, tax_amount=$6, final_amount=$7, updated_at=now()
+        WHERE id=$8`, req.CustomerID, validUntil, req.Notes, totals.Total, totals.Discount, totals.Tax, totals.Final, id)
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+func supplierBelongsToTenant(tenantID, supplierID string) bool {
+	if supplierID == "" {
+		return true
+	}
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM suppliers WHERE id::text=$1 AND tenant_id=$2)", supplierID, tenantID).Scan(&exists)
+	return exists
+}
+
+// checkReorderLevels validates a set of reorder levels; returns the problem, or ""
+func checkReorderLevels(level, maxLevel, qty *float64) string {
+	if level == nil {
+		if maxLevel != nil || qty != nil {
+			return "reorder_level is required with max_level or reorder_qty"
+		}
+		return ""
+	}
+	if *level < 0 {
+		return "reorder_level cannot be negative"
+	}
+	if maxLevel != nil && *maxLevel <= *level {
+		return "max_level must be above reorder_level"
+	}
+	if qty != nil && *qty <= 0 {
+		return "reorder_qty must be positive"
+	}
+	return ""
+}
+
+// ListProductReorderLevels returns the branch overrides of a product's reorder levels
+func ListProductReorderLevels(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT r.product_id, r.branch_id, b.name, r.reorder_level, r.max_level, r.reorder_qty
+        FROM product_reorder_levels r JOIN branches b ON b.id = r.branch_id
+        WHERE r.tenant_id=$1 AND r.product_id=$2 ORDER BY b.name`, tenantID, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	levels := []models.BranchReorderLevel{}
+	for rows.Next() {
+		var l models.BranchReorderLevel
+		rows.Scan(&l.ProductID, &l.BranchID, &l.BranchName, &l.ReorderLevel, &l.MaxLevel, &l.ReorderQty)
+		levels = append(levels, l)
+	}
+	c.JSON(200, levels)
+}
+
+// SetProductReorderLevel sets a product's reorder levels at one branch
+func SetProductReorderLevel(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	branchID := c.Param("branchId")
+	var req models.SetReorderLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := checkReorderLevels(req.ReorderLevel, req.MaxLevel, req.ReorderQty); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE id::text=$1 AND tenant_id=$2)", productID, tenantID).Scan(&exists)
+	if !exists {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if !branchUsable(c, branchID) {
+		return
+	}
+
+	_, err := db.DB.Exec(`INSERT INTO product_reorder_levels (tenant_id, product_id, branch_id, reorder_level, max_level, reorder_qty)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (product_id, branch_id) DO UPDATE
+        SET reorder_level=EXCLUDED.reorder_level, max_level=EXCLUDED.max_level, reorder_qty=EXCLUDED.reorder_qty`,
+		tenantID, productID, branchID, *req.ReorderLevel, req.MaxLevel, req.ReorderQty)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Reorder level saved"})
+}
+
+// DeleteProductReorderLevel drops a branch override; the product's levels apply again
+func DeleteProductReorderLevel(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	res, err := db.DB.Exec("DELETE FROM product_reorder_levels WHERE tenant_id=$1 AND product_id=$2 AND branch_id=$3",
+		tenantID, c.Param("id"), c.Param("branchId"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "No reorder level for this branch"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Reorder level removed"})
+}
+
+// ListPurchaseSuggestions returns the suggested purchases awaiting review, with their lines. Optional ?branch_id.
+func ListPurchaseSuggestions(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT p.id, COALESCE(p.supplier_id::text, ''), COALESCE(p.branch_id::text, ''), p.reference_no, COALESCE(p.subtotal, 0), COALESCE(p.tax_total, 0), p.grand_total,
+            p.status, COALESCE(p.notes, ''), p.created_at, COALESCE(s.name, '')
+        FROM purchases p LEFT JOIN suppliers s ON p.supplier_id=s.id
+        WHERE p.tenant_id=$1 AND p.is_suggested AND p.status='draft'
+          AND (NULLIF($2, '') IS NULL OR p.branch_id = NULLIF($2, '')::uuid)
+        ORDER BY p.created_at DESC`, tenantID, c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	purchases := []models.Purchase{}
+	for rows.Next() {
+		var p models.Purchase
+		rows.Scan(&p.ID, &p.SupplierID, &p.BranchID, &p.ReferenceNo, &p.Subtotal, &p.TaxTotal, &p.GrandTotal, &p.Status, &p.Notes, &p.CreatedAt, &p.SupplierName)
+		p.IsSuggested = true
+		purchases = append(purchases, p)
+	}
+	rows.Close()
+
+	for i := range purchases {
+		iRows, err := db.DB.Query(`SELECT pi.id, pi.product_id, COALESCE(pi.variant_id::text, ''), COALESCE(pi.name_snapshot, ''), COALESCE(pi.unit_id::text, ''), COALESCE(u.short_name, ''), pi.unit_factor,
+                pi.cost_price, pi.quantity, pi.quantity_received, COALESCE(pi.line_total, 0)
+            FROM purchase_items pi LEFT JOIN units u ON u.id = pi.unit_id WHERE pi.purchase_id=$1 ORDER BY pi.name_snapshot`, purchases[i].ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		purchases[i].Items = []models.PurchaseItem{}
+		for iRows.Next() {
+			var it models.PurchaseItem
+			iRows.Scan(&it.ID, &it.ProductID, &it.VariantID, &it.Name, &it.UnitID, &it.Unit, &it.UnitFactor, &it.CostPrice, &it.Quantity, &it.QuantityReceived, &it.LineTotal)
+			it.Outstanding = roundQty(it.Quantity - it.QuantityReceived)
+			purchases[i].Items = append(purchases[i].Items, it)
+		}
+		iRows.Close()
+	}
+	c.JSON(200, purchases)
+}
+
+// GeneratePurchaseSuggestions runs the reorder job for the tenant now
+func GeneratePurchaseSuggestions(c *gin.Context) {
+	created, err := services.GenerateReorderSuggestions(c.GetString("tenantID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Suggestions generated", "purchase_ids": created})
+}
+
+// ConfirmPurchaseSuggestion accepts a suggested purchase, optionally with edited
+// lines and supplier, and prices its tax. It then is an ordinary draft purchase.
+func ConfirmPurchaseSuggestion(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.ConfirmSuggestionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var branchID, supplierID, status string
+	var suggested bool
+	err = tx.QueryRow(`SELECT COALESCE(branch_id::text, ''), COALESCE(supplier_id::text, ''), status, is_suggested
+        FROM purchases WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, id, tenantID).Scan(&branchID, &supplierID, &status, &suggested)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Purchase not found"})
+		return
+	}
+	if !suggested || status != "draft" {
+		c.JSON(400, gin.H{"error": "Purchase is not a pending suggestion"})
+		return
+	}
+	if !branchUsable(c, branchID) {
+		return
+	}
+	if req.SupplierID != "" {
+		if !supplierBelongsToTenant(tenantID, req.SupplierID) {
+			c.JSON(400, gin.H{"error": "Supplier not found"})
+			return
+		}
+		supplierID = req.SupplierID
+	}
+
+	type line struct {
+		id, productID, taxClassID string
+		costPrice, qty            float64
+	}
+	rows, err := tx.Query(`SELECT pi.id, pi.product_id, COALESCE(p.tax_class_id::text, ''), pi.cost_price, pi.quantity
+        FROM purchase_items pi JOIN products p ON p.id = pi.product_id WHERE pi.purchase_id=$1`, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var lines []line
+	index := map[string]int{}
+	for rows.Next() {
+		var l line
+		rows.Scan(&l.id, &l.productID, &l.taxClassID, &l.costPrice, &l.qty)
+		index[l.id] = len(lines)
+		lines = append(lines, l)
+	}
+	rows.Close()
+
+	for _, edit := range req.Items {
+		i, ok := index[edit.PurchaseItemID]
+		if !ok {
+			c.JSON(400, gin.H{"error": "Purchase item " + edit.PurchaseItemID + " is not on this purchase"})
+			return
+		}
+		if edit.Quantity != nil {
+			lines[i].qty = *edit.Quantity
+		}
+		if edit.CostPrice != nil {
+			lines[i].costPrice = *edit.CostPrice
+		}
+	}
+
+	// Reprice every kept line with its product's tax class
+	rates := taxRateCache{}
+	var subtotal, taxTotal float64
+	kept := 0
+	for _, l := range lines {
+		if l.qty == 0 {
+			if _, err := tx.Exec("DELETE FROM purchase_items WHERE id=$1", l.id); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			continue
+		}
+		classRates, err := rates.classRates(tx, tenantID, l.taxClassID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		lineTotal := roundMoney(l.costPrice * l.qty)
+		net, tax, taxes := computeLineTax(lineTotal, classRates, req.PricesIncludeTax)
+		taxDetails, _ := json.Marshal(taxes)
+		_, err = tx.Exec(`UPDATE purchase_items SET cost_price=$1, quantity=$2, line_total=$3, net_amount=$4, tax_amount=$5, tax_details=$6 WHERE id=$7`,
+			l.costPrice, l.qty, lineTotal, net, tax, string(taxDetails), l.id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		subtotal += net
+		taxTotal += tax
+		kept++
+	}
+	if kept == 0 {
+		c.JSON(400, gin.H{"error": "No lines left to order; dismiss the suggestion instead"})
+		return
+	}
+	subtotal = roundMoney(subtotal)
+	taxTotal = roundMoney(taxTotal)
+
+	_, err = tx.Exec(`UPDATE purchases SET supplier_id=NULLIF($1, '')::uuid, subtotal=$2, tax_total=$3, grand_total=$4, prices_include_tax=$5,
+            notes=COALESCE(NULLIF($6, ''), notes), is_suggested=false
+        WHERE id=$7`,
+		supplierID, subtotal, taxTotal, roundMoney(subtotal+taxTotal), req.PricesIncludeTax, req.Notes, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Suggestion confirmed", "id": id})
+}
+
+// DismissPurchaseSuggestion cancels a suggested purchase. Its items are not
+// suggested again at the branch until their stock next moves.
+func DismissPurchaseSuggestion(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	res, err := db.DB.Exec("UPDATE purchases SET status='cancelled', dismissed_at=now() WHERE id=$1 AND tenant_id=$2 AND is_suggested AND status='draft'", c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Suggestion not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Suggestion dismissed"})
+}
@@ -13,13 +13,16 @@ type DailySales struct {
 
 func GetDailySalesReport(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
-	// simple last 30 days
+	branchID := c.Query("branch_id") // Empty = all branches
+	// simple last 30 days, by the day (in the tenant's timezone) sales were made:
+	// offline sales count on the day they were rung up, not the day they synced
 	rows, err := db.DB.Query(`
-        SELECT to_char(created_at, 'YYYY-MM-DD') as day, sum(final_amount), count(id) 
-        FROM sales 
-        WHERE tenant_id=$1 AND created_at > now() - interval '30 days'
+        SELECT to_char(s.sold_at AT TIME ZONE COALESCE(NULLIF(t.timezone, ''), 'UTC'), 'YYYY-MM-DD') as day, sum(s.final_amount), count(s.id)
+        FROM sales s JOIN tenants t ON t.id = s.tenant_id
+        WHERE s.tenant_id=$1 AND s.sold_at > now() - interval '30 days'
+          AND (NULLIF($2, '') IS NULL OR s.branch_id = NULLIF($2, '')::uuid)
         GROUP BY day ORDER BY day DESC
-    `, tenantID)
+    `, tenantID, branchID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -40,13 +43,18 @@ func GetDailySalesReport(c *gin.Context) {
 
 func GetStockAlerts(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
-	// Items with < 10 stock
+	branchID := c.Query("branch_id") // Empty = stock summed over all branches
+	// Items at or below their reorder level (the branch's when ?branch_id is given);
+	// items without one alert below 10. Kits hold no stock of their own.
 	rows, err := db.DB.Query(`
-        SELECT p.name, p.sku, COALESCE(sum(i.quantity), 0) as stock 
-        FROM products p LEFT JOIN inventory_stock i ON p.id=i.product_id 
-        WHERE p.tenant_id=$1 
-        GROUP BY p.id HAVING COALESCE(sum(i.quantity), 0) < 10
-    `, tenantID)
+        SELECT p.name, p.sku, COALESCE(sum(i.quantity), 0) as stock, COALESCE(r.reorder_level, p.reorder_level)
+        FROM products p LEFT JOIN inventory_stock i ON p.id=i.product_id AND (NULLIF($2, '') IS NULL OR i.branch_id = NULLIF($2, '')::uuid)
+        LEFT JOIN product_reorder_levels r ON r.product_id = p.id AND r.branch_id = NULLIF($2, '')::uuid
+        WHERE p.tenant_id=$1 AND p.product_type = 'standard'
+        GROUP BY p.id, r.reorder_level
+        HAVING CASE WHEN COALESCE(r.reorder_level, p.reorder_level) IS NULL THEN COALESCE(sum(i.quantity), 0) < 10
+            ELSE COALESCE(sum(i.quantity), 0) <= COALESCE(r.reorder_level, p.reorder_level) END
+    `, tenantID, branchID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -54,14 +62,15 @@ func GetStockAlerts(c *gin.Context) {
 	defer rows.Close()
 
 	type Alert struct {
-		Name  string `json:"name"`
-		Sku   string `json:"sku"`
-		Stock int    `json:"stock"`
+		Name         string   `json:"name"`
+		Sku          string   `json:"sku"`
+		Stock        float64  `json:"stock"`
+		ReorderLevel *float64 `json:"reorder_level"` // Nil = no level set
 	}
 	var alerts []Alert
 	for rows.Next() {
 		var a Alert
-		rows.Scan(&a.Name, &a.Sku, &a.Stock)
+		rows.Scan(&a.Name, &a.Sku, &a.Stock, &a.ReorderLevel)
 		alerts = append(alerts, a)
 	}
 	if alerts == nil {
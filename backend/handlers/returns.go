@@ -1,14 +1,25 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
+	"math"
+
 	"github.com/gin-gonic/gin"
 	"github.com/insaansher/sherpos/backend/db"
 	"github.com/insaansher/sherpos/backend/models"
 )
 
+// CreateSaleReturn takes goods back against the lines of a sale. Quantities are
+// checked against what is still returnable after earlier returns, and the refund
+// is what the customer paid for those units (after the sale discount, with tax).
+// Quantities are in the unit each line was sold in.
+// Restocked units go back into the branch taking the return, which need not be
+// the branch that made the sale; written-off units do not touch stock.
 func CreateSaleReturn(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
 	userID := c.GetString("userID")
+	branchID := c.GetString("branchID")
 	var req models.SaleReturnRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
@@ -22,37 +33,276 @@ func CreateSaleReturn(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	var returnID string
-	err = tx.QueryRow("INSERT INTO sale_returns (tenant_id, sale_id, reason, created_by) VALUES ($1, $2, $3, $4) RETURNING id",
-		tenantID, req.SaleID, req.Reason, userID).Scan(&returnID)
+	// 1. Lock Sale (serialises concurrent returns of the same sale)
+	var status string
+	var discount float64
+	err = tx.QueryRow("SELECT COALESCE(status, 'completed'), COALESCE(discount_amount, 0) FROM sales WHERE id=$1 AND tenant_id=$2 FOR UPDATE",
+		req.SaleID, tenantID).Scan(&status, &discount)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Sale not found"})
+		return
+	}
+	if status == "void" || status == "refunded" {
+		c.JSON(400, gin.H{"error": "Sale is " + status + " and cannot be returned"})
+		return
+	}
+
+	lines, err := loadReturnableLines(tx, req.SaleID, discount)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
+	// 2. Match requested quantities to sale lines
+	var items []models.SaleReturnItem
+	serialIDs := map[int][]string{} // Per return item, the serialized units coming back
+	var refundTotal float64
 	for _, item := range req.Items {
-		// Validate sold qty vs returned qty - Skipped for speed, assuming UI validation
-		// In real app: check if (already_returned + current_return) <= sold_qty
+		restock := true
+		if item.Restock != nil {
+			restock = *item.Restock
+		}
+
+		// Serialized units go back against the lines they were sold on
+		groups, err := saleReturnSerials(tx, tenantID, req.SaleID, item.ProductID, item.VariantID, item.SaleItemID, item.Serials, item.Quantity)
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+		if groups != nil {
+			for _, g := range groups {
+				for i := range lines {
+					l := &lines[i]
+					if l.ID != g.saleItemID {
+						continue
+					}
+					take := float64(len(g.ids)) // Serialized lines are sold by the piece
+					if roundQty(l.Quantity-l.Returned) < take {
+						c.JSON(400, gin.H{"error": fmt.Sprintf("Cannot return %g of product %s: only %g left to return on this sale", take, item.ProductID, roundQty(l.Quantity-l.Returned))})
+						return
+					}
+					refund := l.refundFor(take)
+					l.Returned = roundQty(l.Returned + take)
+					l.Refunded = roundMoney(l.Refunded + refund)
+					refundTotal += refund
+
+					serialIDs[len(items)] = g.ids
+					items = append(items, models.SaleReturnItem{
+						SaleItemID:   l.ID,
+						ProductID:    l.ProductID,
+						VariantID:    l.VariantID,
+						Quantity:     take,
+						RefundAmount: refund,
+						Restock:      restock,
+						Serials:      g.numbers,
+					})
+				}
+			}
+			continue
+		}
+
+		remaining := item.Quantity
+		for i := range lines {
+			l := &lines[i]
+			if item.SaleItemID != "" && l.ID != item.SaleItemID {
+				continue
+			}
+			if l.ProductID != item.ProductID || l.VariantID != item.VariantID || roundQty(l.Quantity-l.Returned) <= 0 {
+				continue
+			}
 
-		_, err = tx.Exec("INSERT INTO sale_return_items (sale_return_id, product_id, quantity) VALUES ($1, $2, $3)",
-			returnID, item.ProductID, item.Quantity)
+			take := roundQty(l.Quantity - l.Returned)
+			if take > remaining {
+				take = remaining
+			}
+			refund := l.refundFor(take)
+			l.Returned = roundQty(l.Returned + take)
+			l.Refunded = roundMoney(l.Refunded + refund)
+			refundTotal += refund
+			remaining = roundQty(remaining - take)
+
+			items = append(items, models.SaleReturnItem{
+				SaleItemID:   l.ID,
+				ProductID:    l.ProductID,
+				VariantID:    l.VariantID,
+				Quantity:     take,
+				RefundAmount: refund,
+				Restock:      restock,
+			})
+			if remaining <= 0 {
+				break
+			}
+		}
+		if remaining > 0 {
+			c.JSON(400, gin.H{
+				"error":      fmt.Sprintf("Cannot return %g of product %s: only %g left to return on this sale", item.Quantity, item.ProductID, roundQty(item.Quantity-remaining)),
+				"product_id": item.ProductID,
+				"variant_id": item.VariantID,
+			})
+			return
+		}
+	}
+	refundTotal = roundMoney(refundTotal)
+
+	// 3. Record Return
+	var returnID string
+	err = tx.QueryRow("INSERT INTO sale_returns (tenant_id, branch_id, sale_id, reason, refund_amount, created_by) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tenantID, branchID, req.SaleID, req.Reason, refundTotal, userID).Scan(&returnID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	factors := map[string]float64{}
+	sold := map[string]float64{}
+	for _, l := range lines {
+		factors[l.ID] = l.Factor
+		sold[l.ID] = l.Quantity
+	}
+	for idx, item := range items {
+		_, err = tx.Exec(`INSERT INTO sale_return_items (tenant_id, sale_return_id, sale_item_id, product_id, variant_id, quantity, refund_amount, restock)
+            VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7, $8)`,
+			tenantID, returnID, item.SaleItemID, item.ProductID, item.VariantID, item.Quantity, item.RefundAmount, item.Restock)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Item insert failed"})
 			return
 		}
 
-		// Return = Stock Increase
-		err = updateStockHelper(tx, tenantID, item.ProductID, item.Quantity, "sale_return", returnID, "Sale Return")
+		if ids := serialIDs[idx]; ids != nil {
+			if err := restoreSaleSerials(tx, tenantID, userID, branchID, returnID, ids, item.Restock); err != nil {
+				respondTxError(c, err)
+				return
+			}
+		}
+
+		if !item.Restock {
+			continue
+		}
+
+		// Kits go back as their components, in the share of the line returned
+		restocked, err := restockKitComponents(tx, tenantID, branchID, req.SaleID, returnID, item.SaleItemID, item.Quantity/sold[item.SaleItemID])
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+		if restocked {
+			continue
+		}
+
+		// Return = Stock Increase, back in at the cost it was sold at
+		move := stockMove{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			BranchID:  branchID,
+			QtyChange: roundQty(item.Quantity * factors[item.SaleItemID]),
+			RefType:   "sale_return",
+			RefID:     returnID,
+			Note:      "Sale Return",
+		}
+		var soldCost sql.NullFloat64
+		tx.QueryRow("SELECT unit_cost / unit_factor FROM sale_items WHERE id=$1", item.SaleItemID).Scan(&soldCost)
+		if soldCost.Valid {
+			move.UnitCost = &soldCost.Float64
+		}
+		err = returnToLots(tx, tenantID, req.SaleID, move)
 		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondTxError(c, err)
 			return
 		}
 	}
 
+	// 4. Sale Status
+	status = "refunded"
+	for _, l := range lines {
+		if l.Returned < l.Quantity {
+			status = "partially_refunded"
+			break
+		}
+	}
+	if _, err := tx.Exec("UPDATE sales SET status=$1 WHERE id=$2", status, req.SaleID); err != nil {
+		c.JSON(500, gin.H{"error": "Sale update failed"})
+		return
+	}
+
+	// 5. Take back the loyalty points the refunded share earned
+	if err := reverseSalePoints(tx, tenantID, userID, req.SaleID, returnID, status == "refunded"); err != nil {
+		respondTxError(c, err)
+		return
+	}
+
 	tx.Commit()
-	c.JSON(201, gin.H{"message": "Return Processed", "id": returnID})
+	c.JSON(201, gin.H{"message": "Return Processed", "id": returnID, "refund_amount": refundTotal, "sale_status": status, "items": items})
+}
+
+// returnableLine is a sale line with what has been returned and refunded so far
+type returnableLine struct {
+	ID        string
+	ProductID string
+	VariantID string
+	Quantity  float64 // In the unit sold
+	Factor    float64 // Stock units per unit sold
+	Returned  float64
+	Paid      float64 // What the customer paid for the whole line
+	Refunded  float64
+}
+
+// refundFor values qty units at the line's paid unit price. The units that
+// complete the line take up the rounding remainder (within a cent per unit, so
+// older returns recorded without a refund do not inflate the last one).
+func (l *returnableLine) refundFor(qty float64) float64 {
+	refund := roundMoney(l.Paid * qty / l.Quantity)
+	if roundQty(l.Returned+qty) >= l.Quantity {
+		if rest := roundMoney(l.Paid - l.Refunded); math.Abs(rest-refund) <= 0.01*math.Max(qty, 1) {
+			return rest
+		}
+	}
+	return refund
+}
+
+// loadReturnableLines reads a sale's lines with their returns so far. The paid
+// amount is net + tax as recorded at sale time; lines from before per-line tax
+// snapshots get their share of the sale discount allocated here instead.
+func loadReturnableLines(tx *sql.Tx, saleID string, discount float64) ([]returnableLine, error) {
+	rows, err := tx.Query(`SELECT si.id, COALESCE(si.product_id::text, ''), COALESCE(si.variant_id::text, ''), si.quantity, si.unit_factor, si.total_price,
+            COALESCE(si.net_amount, 0) + COALESCE(si.tax_amount, 0),
+            COALESCE(SUM(ri.quantity), 0), COALESCE(SUM(ri.refund_amount), 0)
+        FROM sale_items si LEFT JOIN sale_return_items ri ON ri.sale_item_id = si.id
+        WHERE si.sale_id=$1 GROUP BY si.id ORDER BY si.id`, saleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []returnableLine
+	var totals, recorded []float64
+	for rows.Next() {
+		var l returnableLine
+		var total, paid float64
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.Quantity, &l.Factor, &total, &paid, &l.Returned, &l.Refunded); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+		totals = append(totals, total)
+		recorded = append(recorded, paid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shares := allocateDiscount(totals, discount)
+	for i := range lines {
+		lines[i].Paid = recorded[i]
+		if recorded[i] <= 0 {
+			lines[i].Paid = roundMoney(totals[i] - shares[i])
+		}
+	}
+	return lines, nil
 }
 
+// CreatePurchaseReturn sends received goods back to the supplier from the branch
+// that received the purchase. Quantities are checked against what was received
+// less earlier returns, in the unit each line was ordered in; the refund is
+// valued at the purchase cost price and booked as a credit note against the supplier.
 func CreatePurchaseReturn(c *gin.Context) {
 	tenantID := c.GetString("tenantID")
 	userID := c.GetString("userID")
@@ -69,30 +319,260 @@ func CreatePurchaseReturn(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	// 1. Lock Purchase (serialises concurrent returns of the same purchase)
+	var status, branchID, referenceNo string
+	var supplierID sql.NullString
+	err = tx.QueryRow("SELECT status, COALESCE(branch_id::text, $3), supplier_id, reference_no FROM purchases WHERE id=$1 AND tenant_id=$2 FOR UPDATE",
+		req.PurchaseID, tenantID, c.GetString("branchID")).Scan(&status, &branchID, &supplierID, &referenceNo)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Purchase not found"})
+		return
+	}
+	if status == "draft" || status == "cancelled" {
+		c.JSON(400, gin.H{"error": "Nothing has been received on this purchase"})
+		return
+	}
+
+	lines, err := loadPurchaseReturnableLines(tx, req.PurchaseID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 2. Match requested quantities to received purchase lines
+	var items []models.PurchaseReturnItem
+	var stockQty []float64 // Per return item, in stock units
+	serialQty := make([]float64, len(req.Items))
+	var refundTotal float64
+	for idx, item := range req.Items {
+		remaining := item.Quantity
+		for i := range lines {
+			l := &lines[i]
+			if item.PurchaseItemID != "" && l.ID != item.PurchaseItemID {
+				continue
+			}
+			if l.ProductID != item.ProductID || l.VariantID != item.VariantID || roundQty(l.Received-l.Returned) <= 0 {
+				continue
+			}
+
+			take := roundQty(l.Received - l.Returned)
+			if take > remaining {
+				take = remaining
+			}
+			refund := roundMoney(l.CostPrice * take)
+			l.Returned = roundQty(l.Returned + take)
+			refundTotal += refund
+			remaining = roundQty(remaining - take)
+			stockQty = append(stockQty, roundQty(take*l.Factor))
+			serialQty[idx] += take * l.Factor
+
+			items = append(items, models.PurchaseReturnItem{
+				PurchaseItemID: l.ID,
+				ProductID:      l.ProductID,
+				VariantID:      l.VariantID,
+				Quantity:       take,
+				CostPrice:      l.CostPrice,
+				RefundAmount:   refund,
+			})
+			if remaining <= 0 {
+				break
+			}
+		}
+		if remaining > 0 {
+			c.JSON(400, gin.H{
+				"error":      fmt.Sprintf("Cannot return %g of product %s: only %g received and not yet returned", item.Quantity, item.ProductID, roundQty(item.Quantity-remaining)),
+				"product_id": item.ProductID,
+				"variant_id": item.VariantID,
+			})
+			return
+		}
+	}
+	refundTotal = roundMoney(refundTotal)
+
+	// 3. Record Return
 	var returnID string
-	err = tx.QueryRow("INSERT INTO purchase_returns (tenant_id, purchase_id, reason, created_by) VALUES ($1, $2, $3, $4) RETURNING id",
-		tenantID, req.PurchaseID, req.Reason, userID).Scan(&returnID)
+	err = tx.QueryRow("INSERT INTO purchase_returns (tenant_id, branch_id, purchase_id, reason, refund_amount, created_by) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tenantID, branchID, req.PurchaseID, req.Reason, refundTotal, userID).Scan(&returnID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	for _, item := range req.Items {
-		_, err = tx.Exec("INSERT INTO purchase_return_items (purchase_return_id, product_id, quantity) VALUES ($1, $2, $3)",
-			returnID, item.ProductID, item.Quantity)
+	for idx, item := range items {
+		_, err = tx.Exec(`INSERT INTO purchase_return_items (tenant_id, purchase_return_id, purchase_item_id, product_id, variant_id, quantity, cost_price, refund_amount)
+            VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7, $8)`,
+			tenantID, returnID, item.PurchaseItemID, item.ProductID, item.VariantID, item.Quantity, item.CostPrice, item.RefundAmount)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Item insert failed"})
 			return
 		}
 
 		// Purchase Return = Stock Decrease
-		err = updateStockHelper(tx, tenantID, item.ProductID, -item.Quantity, "purchase_return", returnID, "Purchase Return")
+		err = updateStockHelper(tx, tenantID, stockMove{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			BranchID:  branchID,
+			QtyChange: -stockQty[idx],
+			RefType:   "purchase_return",
+			RefID:     returnID,
+			Note:      "Purchase Return",
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	// Serialized units leave stock by serial
+	for idx, item := range req.Items {
+		if err := returnSerialsToSupplier(tx, tenantID, userID, item.ProductID, item.VariantID, branchID, returnID, item.Serials, roundQty(serialQty[idx])); err != nil {
+			respondTxError(c, err)
+			return
+		}
+	}
+
+	// 4. Supplier Credit Note
+	var creditNoteID string
+	if supplierID.Valid && refundTotal > 0 {
+		err = tx.QueryRow(`INSERT INTO supplier_credit_notes (tenant_id, supplier_id, purchase_return_id, amount, note)
+            VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			tenantID, supplierID.String, returnID, refundTotal, "Return on purchase "+referenceNo).Scan(&creditNoteID)
 		if err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			c.JSON(500, gin.H{"error": "Credit note failed"})
 			return
 		}
 	}
 
 	tx.Commit()
-	c.JSON(201, gin.H{"message": "Return Processed", "id": returnID})
+	c.JSON(201, gin.H{"message": "Return Processed", "id": returnID, "refund_amount": refundTotal, "credit_note_id": creditNoteID, "items": items})
+}
+
+// purchaseReturnableLine is a purchase line with its received and returned quantities
+type purchaseReturnableLine struct {
+	ID        string
+	ProductID string
+	VariantID string
+	CostPrice float64 // Per unit ordered
+	Factor    float64 // Stock units per unit ordered
+	Received  float64
+	Returned  float64
+}
+
+func loadPurchaseReturnableLines(tx *sql.Tx, purchaseID string) ([]purchaseReturnableLine, error) {
+	rows, err := tx.Query(`SELECT pi.id, pi.product_id, COALESCE(pi.variant_id::text, ''), COALESCE(pi.cost_price, 0), pi.unit_factor, pi.quantity_received,
+            COALESCE((SELECT SUM(ri.quantity) FROM purchase_return_items ri WHERE ri.purchase_item_id = pi.id), 0)
+        FROM purchase_items pi WHERE pi.purchase_id=$1 ORDER BY pi.id`, purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []purchaseReturnableLine
+	for rows.Next() {
+		var l purchaseReturnableLine
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.CostPrice, &l.Factor, &l.Received, &l.Returned); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// restockKitComponents puts back the components a kit sale line took out of
+// stock, share (of the line) of each at the cost it left at. Returns false when
+// the line is not a kit.
+func restockKitComponents(tx *sql.Tx, tenantID, branchID, saleID, returnID, saleItemID string, share float64) (bool, error) {
+	rows, err := tx.Query(`SELECT product_id, COALESCE(variant_id::text, ''), quantity, unit_cost
+        FROM sale_item_components WHERE sale_item_id=$1 AND product_id IS NOT NULL ORDER BY id`, saleItemID)
+	if err != nil {
+		return false, err
+	}
+	var moves []stockMove
+	for rows.Next() {
+		var m stockMove
+		var qty float64
+		var cost sql.NullFloat64
+		if err := rows.Scan(&m.ProductID, &m.VariantID, &qty, &cost); err != nil {
+			rows.Close()
+			return false, err
+		}
+		m.BranchID = branchID
+		m.QtyChange = roundQty(qty * share)
+		m.RefType = "sale_return"
+		m.RefID = returnID
+		m.Note = "Sale Return (kit)"
+		if cost.Valid {
+			m.UnitCost = &cost.Float64
+		}
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, m := range moves {
+		if m.QtyChange <= 0 {
+			continue
+		}
+		if err := returnToLots(tx, tenantID, saleID, m); err != nil {
+			return false, err
+		}
+	}
+	return moves != nil, nil
+}
+
+// returnToLots restocks returned goods into the lots the sale took them from
+// (opening the lot at the returning branch if need be), less what earlier
+// returns already put back into each lot. Units the sale did not take from a
+// lot, or that cannot be traced to one, go back outside any lot.
+func returnToLots(tx *sql.Tx, tenantID, saleID string, m stockMove) error {
+	rows, err := tx.Query(`SELECT l.lot_number, COALESCE(to_char(MIN(l.expiry_date), 'YYYY-MM-DD'), ''), -SUM(g.qty_change)
+        FROM stock_ledger g JOIN stock_lots l ON l.id = g.lot_id
+        WHERE g.tenant_id=$1 AND g.product_id=$2 AND g.variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid
+          AND ((g.ref_type = 'sale' AND g.ref_id = $4)
+            OR (g.ref_type = 'sale_return' AND g.ref_id IN (SELECT id FROM sale_returns WHERE sale_id = $4)))
+        GROUP BY l.lot_number HAVING -SUM(g.qty_change) > 0
+        ORDER BY MIN(l.expiry_date) NULLS LAST, l.lot_number`, tenantID, m.ProductID, m.VariantID, saleID)
+	if err != nil {
+		return err
+	}
+	type soldLot struct {
+		number, expiry string
+		open           float64
+	}
+	var sold []soldLot
+	for rows.Next() {
+		var l soldLot
+		rows.Scan(&l.number, &l.expiry, &l.open)
+		sold = append(sold, l)
+	}
+	rows.Close()
+
+	left := m.QtyChange
+	for _, l := range sold {
+		if left <= 0 {
+			break
+		}
+		take := l.open
+		if take > left {
+			take = left
+		}
+		lotID, err := receiptLot(tx, tenantID, m.ProductID, m.VariantID, m.BranchID, l.number, l.expiry)
+		if err != nil {
+			return err
+		}
+		part := m
+		part.QtyChange = take
+		part.LotID = lotID
+		if err := updateStockHelper(tx, tenantID, part); err != nil {
+			return err
+		}
+		left = roundQty(left - take)
+	}
+	if left > 0 {
+		m.QtyChange = left
+		return updateStockHelper(tx, tenantID, m)
+	}
+	return nil
 }
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// saleInput is everything needed to post a sale, wherever it came from (POS, offline sync)
+type saleInput struct {
+	BranchID        string // Branch selling (and losing the stock)
+	CustomerID      string // Optional
+	Items           []models.SaleItemRequest
+	DiscountAmount  float64
+	PaymentMethod   string
+	PaymentReceived float64
+	Payments        []models.SalePaymentRequest
+	CreatedAt       time.Time // Zero = now
+	SoldAt          time.Time // When the sale was made, if not when it is recorded (offline sales); zero = CreatedAt
+	Origin          *saleOrigin
+	StockNote       string // Ledger note, e.g. "POS Sale"
+	LockedPrices    bool   // Items carry their own unit price (quotation conversion)
+	AllowNegative   bool   // Sell stock the branch does not have (resolving offline sync conflicts)
+}
+
+// saleOrigin is where a sale was rung up, and for offline sales how
+type saleOrigin struct {
+	DeviceID        string     // Enrolled POS device (pos_devices), optional
+	DeviceCreatedAt *time.Time // Sale time by the device clock, as sent
+	ClockSkew       *int       // Seconds the device clock was behind the server's; nil = unknown
+	TimeRejected    bool       // The device time failed the sanity checks; sold at receipt
+	SyncedBy        string     // User whose session synced the sale
+}
+
+type saleResult struct {
+	SaleID         string
+	InvoiceNumber  string
+	TotalAmount    float64
+	DiscountAmount float64
+	TaxAmount      float64
+	FinalAmount    float64
+	Tenders        *saleTenders
+	Taxes          []models.TaxLine
+	PointsEarned   int
+	PointsRedeemed int
+}
+
+// saleLine is a priced and taxed line ready to insert
+type saleLine struct {
+	ProductID      string
+	VariantID      string
+	ProductName    string
+	Quantity       float64 // In UnitID
+	UnitID         string
+	UnitFactor     float64 // Stock units per unit sold
+	StockQty       float64 // Quantity in stock units
+	UnitPrice      float64 // Per unit sold
+	TotalPrice     float64 // qty * unit price, before the sale discount
+	DiscountAmount float64
+	NetAmount      float64
+	TaxAmount      float64
+	Taxes          []models.TaxLine
+	Serials        []string
+	Components     []kitPart // Kits: the stock taken out for the whole line
+	taxClassID     string
+	category       string // Product category, for loyalty multipliers
+}
+
+type productNotFoundError struct{ ProductID string }
+
+func (e *productNotFoundError) Error() string {
+	return fmt.Sprintf("Product %s not found", e.ProductID)
+}
+
+type insufficientStockError struct {
+	ProductID string
+	VariantID string
+	Name      string
+	Available float64
+	Requested float64
+}
+
+func (e *insufficientStockError) Error() string {
+	return fmt.Sprintf("Insufficient stock for %s. Available: %g", e.Name, e.Available)
+}
+
+// validationError marks a client mistake (400) as opposed to an internal failure
+type validationError struct{ msg string }
+
+func (e *validationError) Error() string { return e.msg }
+
+// planRestrictionError rejects an action the tenant's plan does not allow (403)
+type planRestrictionError struct {
+	Code    string // PLAN_FEATURE_REQUIRED or PLAN_LIMIT_REACHED
+	Feature string
+	msg     string
+}
+
+func (e *planRestrictionError) Error() string { return e.msg }
+
+// stockFrozenError rejects a stock movement of an item under a frozen stock count (409)
+type stockFrozenError struct {
+	ProductID   string
+	VariantID   string
+	ReferenceNo string
+}
+
+func (e *stockFrozenError) Error() string {
+	return fmt.Sprintf("Product %s is being counted (%s); its stock is frozen until the count is closed", e.ProductID, e.ReferenceNo)
+}
+
+// postSale prices, taxes and records a sale, takes its payments and deducts stock.
+// Must be called within a transaction; the caller commits.
+func postSale(tx *sql.Tx, tenantID, userID string, in saleInput) (*saleResult, error) {
+	// 1. Allocate Invoice Number (locked per-tenant or per-branch sequence)
+	invoiceNum, err := nextInvoiceNumber(tx, tenantID, in.BranchID)
+	if err != nil {
+		return nil, fmt.Errorf("invoice number allocation failed: %w", err)
+	}
+
+	inclusive, err := tenantPricesIncludeTax(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSaleCustomer(tx, tenantID, in.CustomerID); err != nil {
+		return nil, err
+	}
+
+	// 2. Price Items & Check Stock
+	lines := make([]saleLine, 0, len(in.Items))
+	requested := map[string]float64{} // Same item on several lines, in stock units
+	for _, item := range in.Items {
+		l, err := priceSaleItem(tx, tenantID, item, in.LockedPrices)
+		if err != nil {
+			return nil, err
+		}
+
+		// A kit takes its components out of stock instead of itself
+		stock := l.Components
+		if stock == nil {
+			stock = []kitPart{{ProductID: l.ProductID, VariantID: l.VariantID, Name: l.ProductName, Qty: l.StockQty}}
+		}
+		for _, s := range stock {
+			currentStock, _, err := lockStockQty(tx, tenantID, s.ProductID, s.VariantID, in.BranchID) // No record = 0 stock
+			if err != nil {
+				return nil, err
+			}
+
+			key := s.ProductID + "/" + s.VariantID
+			requested[key] = roundQty(requested[key] + s.Qty)
+			if currentStock < requested[key] && !in.AllowNegative {
+				return nil, &insufficientStockError{ProductID: s.ProductID, VariantID: s.VariantID, Name: s.Name, Available: currentStock, Requested: requested[key]}
+			}
+		}
+		lines = append(lines, l)
+	}
+
+	// 3. Discount & Tax
+	totals, err := taxSaleLines(tx, tenantID, lines, in.DiscountAmount, inclusive)
+	if err != nil {
+		return nil, err
+	}
+	totalAmount, discount, taxAmount, finalAmount, breakdown := totals.Total, totals.Discount, totals.Tax, totals.Final, totals.Taxes
+
+	// 4. Validate Tenders against the amount due
+	tenders, err := resolveTenders(in.Payments, in.PaymentMethod, in.PaymentReceived, finalAmount)
+	if err != nil {
+		return nil, &validationError{err.Error()}
+	}
+
+	createdAt := in.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	soldAt := in.SoldAt
+	if soldAt.IsZero() {
+		soldAt = createdAt
+	}
+	origin := in.Origin
+	if origin == nil {
+		origin = &saleOrigin{}
+	}
+
+	// 5. Insert Sale
+	var saleID string
+	err = tx.QueryRow(`INSERT INTO sales (tenant_id, branch_id, invoice_number, total_amount, discount_amount, tax_amount, final_amount, payment_method, payment_received, change_due, created_by, created_at, customer_id,
+            sold_at, device_id, device_created_at, device_clock_skew, device_time_rejected, synced_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, '')::uuid, $14, NULLIF($15, '')::uuid, $16, $17, $18, NULLIF($19, '')::uuid) RETURNING id`,
+		tenantID, in.BranchID, invoiceNum, totalAmount, discount, taxAmount, finalAmount, tenders.PaymentMethod, tenders.PaymentReceived, tenders.ChangeDue, userID, createdAt, in.CustomerID,
+		soldAt, origin.DeviceID, origin.DeviceCreatedAt, origin.ClockSkew, origin.TimeRejected, origin.SyncedBy).Scan(&saleID)
+	if err != nil {
+		return nil, fmt.Errorf("sale insert failed: %w", err)
+	}
+
+	// Loyalty: redeem points tenders (referencing the points taken), then earn
+	earned, redeemed, err := applySaleLoyalty(tx, tenantID, userID, saleID, in.CustomerID, invoiceNum, lines, finalAmount, tenders, soldAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertSalePayments(tx, tenantID, saleID, tenders); err != nil {
+		return nil, fmt.Errorf("payment insert failed: %w", err)
+	}
+
+	// 6. Deduct Stock with Ledger & Insert Items at their cost of goods.
+	// Kits deduct each component (one ledger line per component) and cost their sum.
+	for _, l := range lines {
+		var lineCost float64
+		partCosts := make([]float64, len(l.Components))
+		if l.Components == nil {
+			unitCost, err := moveStock(tx, tenantID, stockMove{
+				ProductID:     l.ProductID,
+				VariantID:     l.VariantID,
+				BranchID:      in.BranchID,
+				QtyChange:     -l.StockQty,
+				RefType:       "sale",
+				RefID:         saleID,
+				AllowNegative: in.AllowNegative,
+				Note:          in.StockNote,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("stock update failed: %w", err)
+			}
+			lineCost = unitCost * l.StockQty
+		}
+		for i, p := range l.Components {
+			unitCost, err := moveStock(tx, tenantID, stockMove{
+				ProductID:     p.ProductID,
+				VariantID:     p.VariantID,
+				BranchID:      in.BranchID,
+				QtyChange:     -p.Qty,
+				RefType:       "sale",
+				RefID:         saleID,
+				AllowNegative: in.AllowNegative,
+				Note:          in.StockNote + " (kit: " + l.ProductName + ")",
+			})
+			if err != nil {
+				return nil, fmt.Errorf("stock update failed: %w", err)
+			}
+			partCosts[i] = unitCost
+			lineCost += unitCost * p.Qty
+		}
+
+		taxDetails, _ := json.Marshal(l.Taxes)
+		var saleItemID string
+		err = tx.QueryRow(`INSERT INTO sale_items (sale_id, product_id, variant_id, product_name, quantity, unit_id, unit_factor, unit_price, total_price, discount_amount, net_amount, tax_amount, tax_details, unit_cost)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, NULLIF($6, '')::uuid, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`,
+			saleID, l.ProductID, l.VariantID, l.ProductName, l.Quantity, l.UnitID, l.UnitFactor, l.UnitPrice, l.TotalPrice, l.DiscountAmount, l.NetAmount, l.TaxAmount, string(taxDetails), roundCost(lineCost/l.Quantity)).Scan(&saleItemID)
+		if err != nil {
+			return nil, fmt.Errorf("item insert failed: %w", err)
+		}
+
+		for i, p := range l.Components {
+			_, err := tx.Exec(`INSERT INTO sale_item_components (tenant_id, sale_item_id, product_id, variant_id, quantity, unit_cost)
+                VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6)`,
+				tenantID, saleItemID, p.ProductID, p.VariantID, p.Qty, partCosts[i])
+			if err != nil {
+				return nil, fmt.Errorf("kit component insert failed: %w", err)
+			}
+		}
+
+		if err := sellSerials(tx, tenantID, userID, l.ProductID, l.VariantID, in.BranchID, saleID, saleItemID, l.Serials, l.StockQty); err != nil {
+			return nil, err
+		}
+	}
+
+	return &saleResult{
+		SaleID:         saleID,
+		InvoiceNumber:  invoiceNum,
+		TotalAmount:    totalAmount,
+		DiscountAmount: discount,
+		TaxAmount:      taxAmount,
+		FinalAmount:    finalAmount,
+		Tenders:        tenders,
+		Taxes:          breakdown,
+		PointsEarned:   earned,
+		PointsRedeemed: redeemed,
+	}, nil
+}
+
+// saleTotals are the amounts of a priced set of lines
+type saleTotals struct {
+	Total    float64 // Lines before the discount
+	Discount float64
+	Tax      float64
+	Final    float64 // Amount due
+	Taxes    []models.TaxLine
+}
+
+// priceSaleItem resolves an item's product, unit and price (the product's, or
+// the item's own when locked, e.g. by a quotation) and, for kits, the
+// components the line takes out of stock
+func priceSaleItem(tx *sql.Tx, tenantID string, item models.SaleItemRequest, locked bool) (saleLine, error) {
+	if err := checkStockItem(tx, tenantID, item.ProductID, item.VariantID, false); err != nil {
+		return saleLine{}, err
+	}
+
+	var price float64
+	var name string
+	var taxClassID sql.NullString
+	var serialized bool
+	var category string
+	err := tx.QueryRow("SELECT price, name, tax_class_id, track_serials, COALESCE(category, '') FROM products WHERE id=$1 AND tenant_id=$2", item.ProductID, tenantID).
+		Scan(&price, &name, &taxClassID, &serialized, &category)
+	if err != nil {
+		return saleLine{}, err
+	}
+
+	// Sold in the product's sale unit unless the line names another of its units
+	unitID, factor, err := lineUnit(tx, tenantID, item.ProductID, item.UnitID, "sale")
+	if err != nil {
+		return saleLine{}, err
+	}
+	if serialized && factor != 1 {
+		return saleLine{}, &validationError{fmt.Sprintf("Product %s is serialized and sold by the piece", item.ProductID)}
+	}
+
+	// Variant: own name suffix and optional price_override
+	if item.VariantID != "" {
+		var variantName string
+		var override sql.NullFloat64
+		err := tx.QueryRow("SELECT name, price_override FROM product_variants WHERE id=$1", item.VariantID).Scan(&variantName, &override)
+		if err != nil {
+			return saleLine{}, err
+		}
+		name = name + " - " + variantName
+		if override.Valid {
+			price = override.Float64
+		}
+	}
+
+	price = roundMoney(price * factor) // Prices are per stock unit
+	if locked {
+		if item.UnitPrice < 0 {
+			return saleLine{}, &validationError{"unit_price cannot be negative"}
+		}
+		price = roundMoney(item.UnitPrice)
+	}
+	stockQty := roundQty(item.Quantity * factor)
+
+	parts, err := kitParts(tx, tenantID, item.ProductID)
+	if err != nil {
+		return saleLine{}, err
+	}
+	for i := range parts {
+		parts[i].Qty = roundQty(parts[i].Qty * stockQty)
+	}
+
+	return saleLine{
+		ProductID:   item.ProductID,
+		VariantID:   item.VariantID,
+		ProductName: name,
+		Quantity:    item.Quantity,
+		UnitID:      unitID,
+		UnitFactor:  factor,
+		StockQty:    stockQty,
+		UnitPrice:   price, // Use DB price for security, ignoring req.UnitPrice unless locked
+		TotalPrice:  roundMoney(price * item.Quantity),
+		Serials:     item.Serials,
+		Components:  parts,
+		taxClassID:  taxClassID.String,
+		category:    category,
+	}, nil
+}
+
+// taxSaleLines spreads the discount over the lines (capped at their total) and
+// taxes each line, filling in its discount, net and tax amounts
+func taxSaleLines(tx *sql.Tx, tenantID string, lines []saleLine, discount float64, inclusive bool) (*saleTotals, error) {
+	var t saleTotals
+	for _, l := range lines {
+		t.Total += l.TotalPrice
+	}
+	t.Total = roundMoney(t.Total)
+
+	t.Discount = roundMoney(discount)
+	if t.Discount < 0 {
+		return nil, &validationError{"discount_amount cannot be negative"}
+	}
+	if t.Discount > t.Total {
+		t.Discount = t.Total
+	}
+
+	amounts := make([]float64, len(lines))
+	for i, l := range lines {
+		amounts[i] = l.TotalPrice
+	}
+	shares := allocateDiscount(amounts, t.Discount)
+
+	rates := taxRateCache{}
+	for i := range lines {
+		classRates, err := rates.classRates(tx, tenantID, lines[i].taxClassID)
+		if err != nil {
+			return nil, err
+		}
+		lines[i].DiscountAmount = shares[i]
+		lines[i].NetAmount, lines[i].TaxAmount, lines[i].Taxes = computeLineTax(lines[i].TotalPrice-shares[i], classRates, inclusive)
+		t.Tax += lines[i].TaxAmount
+		t.Taxes = mergeTaxLines(t.Taxes, lines[i].Taxes)
+	}
+	t.Tax = roundMoney(t.Tax)
+
+	t.Final = t.Total - t.Discount
+	if !inclusive {
+		t.Final += t.Tax
+	}
+	t.Final = roundMoney(t.Final)
+	return &t, nil
+}
+
+// respondTxError maps failures from the shared transaction helpers (postSale,
+// checkStockItem, ...) onto HTTP responses
+func respondTxError(c *gin.Context, err error) {
+	c.JSON(txErrorResponse(err))
+}
+
+// txErrorResponse is the status and body respondTxError answers err with
+func txErrorResponse(err error) (int, gin.H) {
+	var notFound *productNotFoundError
+	var noStock *insufficientStockError
+	var invalid *validationError
+	var restricted *planRestrictionError
+	var frozen *stockFrozenError
+	switch {
+	case errors.As(err, &notFound):
+		return 404, gin.H{"error": notFound.Error(), "product_id": notFound.ProductID}
+	case errors.As(err, &noStock):
+		return 409, gin.H{"error": noStock.Error(), "product_id": noStock.ProductID, "variant_id": noStock.VariantID}
+	case errors.As(err, &invalid):
+		return 400, gin.H{"error": invalid.Error()}
+	case errors.As(err, &restricted):
+		return 403, gin.H{"error": restricted.Error(), "code": restricted.Code, "feature": restricted.Feature}
+	case errors.As(err, &frozen):
+		return 409, gin.H{"error": frozen.Error(), "code": "STOCK_COUNT_IN_PROGRESS", "product_id": frozen.ProductID, "variant_id": frozen.VariantID}
+	default:
+		return 500, gin.H{"error": err.Error()}
+	}
+}
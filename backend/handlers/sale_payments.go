@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// allowedPaymentMethods mirrors the sale_payments.method CHECK constraint
+var allowedPaymentMethods = map[string]bool{
+	"cash":          true,
+	"card":          true,
+	"store_credit":  true,
+	"bank_transfer": true,
+	"mobile_wallet": true,
+	"points":        true, // Loyalty points, redeemed by the sale's customer
+}
+
+// saleTenders is the validated payment breakdown for a sale
+type saleTenders struct {
+	PaymentMethod   string // Stored on sales.payment_method: the single method, or "split"
+	PaymentReceived float64
+	ChangeDue       float64
+	Payments        []models.SalePayment
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// resolveTenders validates tendered payments against the amount due.
+// When payments is empty the legacy single-tender fields are used; a zero
+// received amount means "exact amount". Change is only ever given from cash.
+func resolveTenders(payments []models.SalePaymentRequest, method string, received, finalAmount float64) (*saleTenders, error) {
+	if len(payments) == 0 {
+		if method == "" {
+			return nil, fmt.Errorf("payment_method or payments is required")
+		}
+		if received <= 0 {
+			received = finalAmount
+		}
+		payments = []models.SalePaymentRequest{{Method: method, Amount: received}}
+	}
+
+	due := roundMoney(finalAmount)
+	var tendered, nonCash, cash float64
+	for _, p := range payments {
+		if !allowedPaymentMethods[p.Method] {
+			return nil, fmt.Errorf("unsupported payment method: %s", p.Method)
+		}
+		amt := roundMoney(p.Amount)
+		if amt <= 0 && due > 0 {
+			return nil, fmt.Errorf("payment amount must be positive")
+		}
+		tendered += amt
+		if p.Method == "cash" {
+			cash += amt
+		} else {
+			nonCash += amt
+		}
+	}
+	tendered = roundMoney(tendered)
+
+	if roundMoney(nonCash) > due {
+		return nil, fmt.Errorf("non-cash payments (%.2f) exceed the amount due (%.2f); change can only be given from cash", nonCash, due)
+	}
+	if tendered < due {
+		return nil, fmt.Errorf("tendered %.2f is less than the amount due %.2f", tendered, due)
+	}
+
+	change := roundMoney(tendered - due)
+	res := &saleTenders{PaymentReceived: tendered, ChangeDue: change}
+
+	// Apply change against cash tenders, last one first
+	remainingChange := change
+	res.Payments = make([]models.SalePayment, len(payments))
+	for i := len(payments) - 1; i >= 0; i-- {
+		p := payments[i]
+		amt := roundMoney(p.Amount)
+		applied := amt
+		if p.Method == "cash" && remainingChange > 0 {
+			give := math.Min(remainingChange, amt)
+			applied = roundMoney(amt - give)
+			remainingChange = roundMoney(remainingChange - give)
+		}
+		res.Payments[i] = models.SalePayment{Method: p.Method, Amount: applied, Tendered: amt, Reference: p.Reference}
+	}
+
+	res.PaymentMethod = payments[0].Method
+	for _, p := range payments[1:] {
+		if p.Method != res.PaymentMethod {
+			res.PaymentMethod = "split"
+			break
+		}
+	}
+	return res, nil
+}
+
+// insertSalePayments writes the tender breakdown. Must be called within the sale transaction.
+func insertSalePayments(tx *sql.Tx, tenantID, saleID string, tenders *saleTenders) error {
+	for i, p := range tenders.Payments {
+		_, err := tx.Exec(`INSERT INTO sale_payments (tenant_id, sale_id, line_no, method, amount, tendered, reference)
+            VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+			tenantID, saleID, i+1, p.Method, p.Amount, p.Tendered, p.Reference)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSalePayments returns the tender breakdown of a sale
+func loadSalePayments(saleID string) ([]models.SalePayment, error) {
+	rows, err := db.DB.Query("SELECT method, amount, tendered, COALESCE(reference, '') FROM sale_payments WHERE sale_id=$1 ORDER BY line_no", saleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.SalePayment
+	for rows.Next() {
+		var p models.SalePayment
+		if err := rows.Scan(&p.Method, &p.Amount, &p.Tendered, &p.Reference); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
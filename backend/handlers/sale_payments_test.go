@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+func TestResolveTenders(t *testing.T) {
+	pay := func(method string, amount float64) models.SalePaymentRequest {
+		return models.SalePaymentRequest{Method: method, Amount: amount}
+	}
+
+	tests := []struct {
+		name     string
+		payments []models.SalePaymentRequest
+		method   string
+		received float64
+		due      float64
+		wantErr  bool
+		stored   string // sales.payment_method
+		change   float64
+		applied  []float64 // Per payment, after change
+	}{
+		{name: "legacy exact amount", method: "card", due: 25, stored: "card", applied: []float64{25}},
+		{name: "legacy cash with change", method: "cash", received: 50, due: 42.5, stored: "cash", change: 7.5, applied: []float64{42.5}},
+		{name: "legacy needs a method", due: 10, wantErr: true},
+		{name: "split card and cash", payments: []models.SalePaymentRequest{pay("card", 30), pay("cash", 20)}, due: 45, stored: "split", change: 5, applied: []float64{30, 15}},
+		{name: "change from the last cash tender first", payments: []models.SalePaymentRequest{pay("cash", 10), pay("cash", 5)}, due: 8, stored: "cash", change: 7, applied: []float64{8, 0}},
+		{name: "same method twice is not split", payments: []models.SalePaymentRequest{pay("card", 5), pay("card", 5)}, due: 10, stored: "card", applied: []float64{5, 5}},
+		{name: "short", payments: []models.SalePaymentRequest{pay("cash", 9.99)}, due: 10, wantErr: true},
+		{name: "no change from card", payments: []models.SalePaymentRequest{pay("card", 20)}, due: 10, wantErr: true},
+		{name: "non-cash over due with cash", payments: []models.SalePaymentRequest{pay("card", 11), pay("cash", 5)}, due: 10, wantErr: true},
+		{name: "unknown method", payments: []models.SalePaymentRequest{pay("cheque", 10)}, due: 10, wantErr: true},
+		{name: "zero amount", payments: []models.SalePaymentRequest{pay("cash", 0), pay("card", 10)}, due: 10, wantErr: true},
+		{name: "zero amount on a free sale", payments: []models.SalePaymentRequest{pay("cash", 0)}, due: 0, stored: "cash", applied: []float64{0}},
+		{name: "amounts rounded to cents", payments: []models.SalePaymentRequest{pay("cash", 10.004)}, due: 10, stored: "cash", applied: []float64{10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := resolveTenders(tt.payments, tt.method, tt.received, tt.due)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want an error, got %+v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.PaymentMethod != tt.stored || res.ChangeDue != tt.change {
+				t.Errorf("method %q, change %v; want %q, %v", res.PaymentMethod, res.ChangeDue, tt.stored, tt.change)
+			}
+			var applied []float64
+			var sum float64
+			for _, p := range res.Payments {
+				applied = append(applied, p.Amount)
+				sum += p.Amount
+			}
+			if !reflect.DeepEqual(applied, tt.applied) {
+				t.Errorf("applied %v, want %v", applied, tt.applied)
+			}
+			if roundMoney(sum) != roundMoney(tt.due) {
+				t.Errorf("payments after change total %v, due %v", roundMoney(sum), tt.due)
+			}
+		})
+	}
+}
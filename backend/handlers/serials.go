@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// serialGroup is the returned units of one sale line
+type serialGroup struct {
+	saleItemID string
+	ids        []string
+	numbers    []string
+}
+
+// checkSerials validates the serials given for a document line. Serialized
+// products need exactly one distinct serial per unit; others take none.
+// Returns whether the product is serialized.
+func checkSerials(tx *sql.Tx, tenantID, productID string, serials []string, qty float64) (bool, error) {
+	var tracked bool
+	if err := tx.QueryRow("SELECT track_serials FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&tracked); err != nil {
+		return false, &productNotFoundError{ProductID: productID}
+	}
+	if !tracked {
+		if len(serials) > 0 {
+			return false, &validationError{fmt.Sprintf("Product %s is not serialized", productID)}
+		}
+		return false, nil
+	}
+
+	if !isWholeQty(qty) {
+		return true, &validationError{fmt.Sprintf("Product %s is serialized and takes whole quantities only", productID)}
+	}
+	if len(serials) != int(qty) {
+		return true, &validationError{fmt.Sprintf("Product %s is serialized: %g serials needed, %d given", productID, qty, len(serials))}
+	}
+	seen := map[string]bool{}
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return true, &validationError{"Serial numbers cannot be empty"}
+		}
+		if seen[s] {
+			return true, &validationError{"Serial " + s + " given twice"}
+		}
+		seen[s] = true
+	}
+	return true, nil
+}
+
+func addSerialEvent(tx *sql.Tx, tenantID, serialID, eventType, refID, branchID, userID string) error {
+	_, err := tx.Exec(`INSERT INTO serial_events (tenant_id, serial_id, event_type, ref_id, branch_id, created_by)
+        VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, '')::uuid)`,
+		tenantID, serialID, eventType, refID, branchID, userID)
+	return err
+}
+
+// receiveSerials books the units of a goods receipt line into stock. A serial
+// already in stock is a duplicate; one that left (sold, returned) may come back.
+func receiveSerials(tx *sql.Tx, tenantID, userID, productID, variantID, branchID, receiptID string, serials []string, qty float64) error {
+	tracked, err := checkSerials(tx, tenantID, productID, serials, qty)
+	if err != nil || !tracked {
+		return err
+	}
+
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		var id string
+		err := tx.QueryRow(`INSERT INTO product_serials (tenant_id, product_id, variant_id, branch_id, serial_number)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5)
+            ON CONFLICT (tenant_id, product_id, serial_number) DO UPDATE
+            SET status='in_stock', variant_id=EXCLUDED.variant_id, branch_id=EXCLUDED.branch_id, updated_at=now()
+            WHERE product_serials.status <> 'in_stock'
+            RETURNING id`,
+			tenantID, productID, variantID, branchID, s).Scan(&id)
+		if err == sql.ErrNoRows {
+			return &validationError{"Serial " + s + " is already in stock"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := addSerialEvent(tx, tenantID, id, "purchase", receiptID, branchID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sellSerials marks the units of a sale line as sold. Each serial must be in
+// stock at the selling branch.
+func sellSerials(tx *sql.Tx, tenantID, userID, productID, variantID, branchID, saleID, saleItemID string, serials []string, qty float64) error {
+	tracked, err := checkSerials(tx, tenantID, productID, serials, qty)
+	if err != nil || !tracked {
+		return err
+	}
+
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		var id string
+		err := tx.QueryRow(`UPDATE product_serials SET status='sold', sale_item_id=$1, updated_at=now()
+            WHERE tenant_id=$2 AND product_id=$3 AND variant_id IS NOT DISTINCT FROM NULLIF($4, '')::uuid
+              AND branch_id=$5 AND serial_number=$6 AND status='in_stock'
+            RETURNING id`,
+			saleItemID, tenantID, productID, variantID, branchID, s).Scan(&id)
+		if err == sql.ErrNoRows {
+			return &validationError{"Serial " + s + " is not in stock at this branch"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := addSerialEvent(tx, tenantID, id, "sale", saleID, branchID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saleReturnSerials resolves the serials of a returned item to the sale lines
+// they went out on. Only units sold on this sale (and not yet returned) qualify;
+// saleItemID, when given, must be their line. Nil for products that are not serialized.
+func saleReturnSerials(tx *sql.Tx, tenantID, saleID, productID, variantID, saleItemID string, serials []string, qty float64) ([]serialGroup, error) {
+	tracked, err := checkSerials(tx, tenantID, productID, serials, qty)
+	if err != nil || !tracked {
+		return nil, err
+	}
+
+	var groups []serialGroup
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		var id, lineID string
+		err := tx.QueryRow(`SELECT ps.id, ps.sale_item_id FROM product_serials ps JOIN sale_items si ON si.id = ps.sale_item_id
+            WHERE ps.tenant_id=$1 AND ps.product_id=$2 AND ps.variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid
+              AND ps.serial_number=$4 AND ps.status='sold' AND si.sale_id=$5
+            FOR UPDATE OF ps`,
+			tenantID, productID, variantID, s, saleID).Scan(&id, &lineID)
+		if err == sql.ErrNoRows {
+			return nil, &validationError{"Serial " + s + " was not sold on this sale"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if saleItemID != "" && lineID != saleItemID {
+			return nil, &validationError{"Serial " + s + " was sold on another line of this sale"}
+		}
+
+		found := false
+		for i := range groups {
+			if groups[i].saleItemID == lineID {
+				groups[i].ids = append(groups[i].ids, id)
+				groups[i].numbers = append(groups[i].numbers, s)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, serialGroup{saleItemID: lineID, ids: []string{id}, numbers: []string{s}})
+		}
+	}
+	return groups, nil
+}
+
+// restoreSaleSerials takes returned units back: into stock at the returning branch,
+// or set aside as 'returned' when they are not restocked
+func restoreSaleSerials(tx *sql.Tx, tenantID, userID, branchID, returnID string, ids []string, restock bool) error {
+	status := "returned"
+	if restock {
+		status = "in_stock"
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("UPDATE product_serials SET status=$1, branch_id=$2, updated_at=now() WHERE id=$3", status, branchID, id); err != nil {
+			return err
+		}
+		if err := addSerialEvent(tx, tenantID, id, "sale_return", returnID, branchID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnSerialsToSupplier sends in-stock units of a purchase return line back
+func returnSerialsToSupplier(tx *sql.Tx, tenantID, userID, productID, variantID, branchID, returnID string, serials []string, qty float64) error {
+	tracked, err := checkSerials(tx, tenantID, productID, serials, qty)
+	if err != nil || !tracked {
+		return err
+	}
+
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		var id string
+		err := tx.QueryRow(`UPDATE product_serials SET status='returned_to_supplier', updated_at=now()
+            WHERE tenant_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid
+              AND branch_id=$4 AND serial_number=$5 AND status='in_stock'
+            RETURNING id`,
+			tenantID, productID, variantID, branchID, s).Scan(&id)
+		if err == sql.ErrNoRows {
+			return &validationError{"Serial " + s + " is not in stock at this branch"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := addSerialEvent(tx, tenantID, id, "purchase_return", returnID, branchID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustSerials books the units of an adjustment line: a positive change brings
+// the serials (back) into stock at the branch, a negative one writes off units
+// in stock there
+func adjustSerials(tx *sql.Tx, tenantID, userID, productID, variantID, branchID, adjustmentID string, serials []string, qtyChange float64) error {
+	tracked, err := checkSerials(tx, tenantID, productID, serials, math.Abs(qtyChange))
+	if err != nil || !tracked {
+		return err
+	}
+
+	for _, s := range serials {
+		s = strings.TrimSpace(s)
+		var id string
+		if qtyChange > 0 {
+			err = tx.QueryRow(`INSERT INTO product_serials (tenant_id, product_id, variant_id, branch_id, serial_number)
+                VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5)
+                ON CONFLICT (tenant_id, product_id, serial_number) DO UPDATE
+                SET status='in_stock', variant_id=EXCLUDED.variant_id, branch_id=EXCLUDED.branch_id, updated_at=now()
+                WHERE product_serials.status <> 'in_stock'
+                RETURNING id`,
+				tenantID, productID, variantID, branchID, s).Scan(&id)
+			if err == sql.ErrNoRows {
+				return &validationError{"Serial " + s + " is already in stock"}
+			}
+		} else {
+			err = tx.QueryRow(`UPDATE product_serials SET status='written_off', updated_at=now()
+                WHERE tenant_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid
+                  AND branch_id=$4 AND serial_number=$5 AND status='in_stock'
+                RETURNING id`,
+				tenantID, productID, variantID, branchID, s).Scan(&id)
+			if err == sql.ErrNoRows {
+				return &validationError{"Serial " + s + " is not in stock at this branch"}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := addSerialEvent(tx, tenantID, id, "adjustment", adjustmentID, branchID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAvailableSerials returns the in-stock serials of a product at the current
+// branch for the POS to pick from (?variant_id for a variant)
+func ListAvailableSerials(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT serial_number FROM product_serials
+        WHERE tenant_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND branch_id=$4 AND status='in_stock'
+        ORDER BY serial_number`, tenantID, c.Param("id"), c.Query("variant_id"), c.GetString("branchID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	serials := []string{}
+	for rows.Next() {
+		var s string
+		rows.Scan(&s)
+		serials = append(serials, s)
+	}
+	c.JSON(200, serials)
+}
+
+// LookupSerial finds units by ?serial_number (any product) with their history
+func LookupSerial(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	number := strings.TrimSpace(c.Query("serial_number"))
+	if number == "" {
+		c.JSON(400, gin.H{"error": "serial_number is required"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT ps.id, ps.product_id, COALESCE(ps.variant_id::text, ''), p.name, COALESCE(v.name, ''),
+            COALESCE(ps.branch_id::text, ''), COALESCE(b.name, ''), ps.serial_number, ps.status, ps.created_at
+        FROM product_serials ps
+        JOIN products p ON p.id = ps.product_id
+        LEFT JOIN product_variants v ON v.id = ps.variant_id
+        LEFT JOIN branches b ON b.id = ps.branch_id
+        WHERE ps.tenant_id=$1 AND ps.serial_number=$2
+        ORDER BY p.name`, tenantID, number)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	serials := []models.ProductSerial{}
+	for rows.Next() {
+		var s models.ProductSerial
+		rows.Scan(&s.ID, &s.ProductID, &s.VariantID, &s.ProductName, &s.VariantName, &s.BranchID, &s.BranchName, &s.SerialNumber, &s.Status, &s.CreatedAt)
+		serials = append(serials, s)
+	}
+	rows.Close()
+
+	for i := range serials {
+		eRows, err := db.DB.Query(`SELECT e.event_type, e.ref_id, COALESCE(gr.receipt_number, s.invoice_number, ''),
+                COALESCE(e.branch_id::text, ''), COALESCE(b.name, ''), e.created_at
+            FROM serial_events e
+            LEFT JOIN goods_receipts gr ON e.event_type = 'purchase' AND gr.id = e.ref_id
+            LEFT JOIN sales s ON e.event_type = 'sale' AND s.id = e.ref_id
+            LEFT JOIN branches b ON b.id = e.branch_id
+            WHERE e.serial_id=$1 ORDER BY e.created_at`, serials[i].ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		serials[i].Events = []models.SerialEvent{}
+		for eRows.Next() {
+			var e models.SerialEvent
+			eRows.Scan(&e.EventType, &e.RefID, &e.RefNo, &e.BranchID, &e.BranchName, &e.CreatedAt)
+			serials[i].Events = append(serials[i].Events, e)
+		}
+		eRows.Close()
+	}
+
+	if len(serials) == 0 {
+		c.JSON(404, gin.H{"error": "Serial not found"})
+		return
+	}
+	c.JSON(200, serials)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// GetInvoiceSettings returns the tenant's invoice numbering configuration
+func GetInvoiceSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var s models.InvoiceSettings
+	err := db.DB.QueryRow("SELECT COALESCE(invoice_prefix_default, 'INV-'), COALESCE(invoice_reset_yearly, true), COALESCE(invoice_per_branch, false) FROM tenants WHERE id=$1", tenantID).
+		Scan(&s.InvoicePrefixDefault, &s.InvoiceResetYearly, &s.InvoicePerBranch)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Tenant not found"})
+		return
+	}
+	c.JSON(200, s)
+}
+
+// UpdateInvoiceSettings changes the invoice prefix, yearly reset and per-branch numbering.
+// Numbers already issued are untouched; the next sale picks up the new settings.
+func UpdateInvoiceSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.InvoiceSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.InvoicePrefixDefault = strings.TrimSpace(req.InvoicePrefixDefault)
+	if req.InvoicePrefixDefault == "" {
+		c.JSON(400, gin.H{"error": "Invoice prefix is required"})
+		return
+	}
+	if defaultPrefixTaken(tenantID, req.InvoicePrefixDefault) {
+		c.JSON(409, gin.H{"error": "Invoice prefix already used by a branch"})
+		return
+	}
+
+	_, err := db.DB.Exec("UPDATE tenants SET invoice_prefix_default=$1, invoice_reset_yearly=$2, invoice_per_branch=$3, updated_at=now() WHERE id=$4",
+		req.InvoicePrefixDefault, req.InvoiceResetYearly, req.InvoicePerBranch, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Invoice settings updated"})
+}
+
+// GetTaxSettings returns whether selling prices are entered tax-inclusive
+func GetTaxSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var s models.TaxSettings
+	err := db.DB.QueryRow("SELECT COALESCE(prices_include_tax, false) FROM tenants WHERE id=$1", tenantID).Scan(&s.PricesIncludeTax)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Tenant not found"})
+		return
+	}
+	c.JSON(200, s)
+}
+
+// UpdateTaxSettings switches the price list between tax-inclusive and tax-exclusive.
+// Product prices are not converted; they are read in the new mode from the next sale.
+func UpdateTaxSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.TaxSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := db.DB.Exec("UPDATE tenants SET prices_include_tax=$1, updated_at=now() WHERE id=$2", req.PricesIncludeTax, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tax settings updated"})
+}
+
+func GetCostingSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+
+	var s models.CostingSettings
+	err := db.DB.QueryRow("SELECT costing_method FROM tenants WHERE id=$1", tenantID).Scan(&s.CostingMethod)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Tenant not found"})
+		return
+	}
+	c.JSON(200, s)
+}
+
+// UpdateCostingSettings switches between weighted average and FIFO. Cost layers
+// and running averages are kept under both, so the switch applies from the next move.
+func UpdateCostingSettings(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.CostingSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := db.DB.Exec("UPDATE tenants SET costing_method=$1, updated_at=now() WHERE id=$2", req.CostingMethod, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Costing settings updated"})
+}
@@ -0,0 +1,564 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/lib/pq"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// CreateStockCount opens a stock-take at the current branch and snapshots the
+// expected quantities. Stock rows are locked while the snapshot is taken so a
+// sale in flight is either in the snapshot or in the movements after it.
+func CreateStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	branchID := c.GetString("branchID")
+	var req models.CreateStockCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Serialise numbering per tenant
+	var locked string
+	if err := tx.QueryRow("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID).Scan(&locked); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var open bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM stock_counts WHERE branch_id=$1 AND status='open')", branchID).Scan(&open)
+	if open {
+		c.JSON(409, gin.H{"error": "A stock count is already open at this branch"})
+		return
+	}
+
+	var count int
+	tx.QueryRow("SELECT COUNT(*) FROM stock_counts WHERE tenant_id=$1", tenantID).Scan(&count)
+	ref := fmt.Sprintf("SC-%06d", count+1)
+
+	var countID string
+	err = tx.QueryRow(`INSERT INTO stock_counts (tenant_id, branch_id, reference_no, freeze_stock, notes, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		tenantID, branchID, ref, req.FreezeStock, req.Notes, userID).Scan(&countID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := pq.Array(req.ProductIDs)
+	_, err = tx.Exec(`SELECT 1 FROM inventory_stock WHERE tenant_id=$1 AND branch_id=$2
+        AND (cardinality($3::uuid[]) = 0 OR product_id = ANY($3::uuid[])) FOR UPDATE`, tenantID, branchID, ids)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product_ids"})
+		return
+	}
+
+	// One line per active variant, or per product when it has none (kits are counted by their components)
+	res, err := tx.Exec(`INSERT INTO stock_count_items (tenant_id, count_id, product_id, variant_id, expected_qty)
+        SELECT $1, $2, p.id, v.id, COALESCE(i.quantity, 0)
+        FROM products p
+        LEFT JOIN product_variants v ON v.product_id = p.id AND COALESCE(v.is_active, true)
+        LEFT JOIN inventory_stock i ON i.product_id = p.id AND i.branch_id = $3 AND i.variant_id IS NOT DISTINCT FROM v.id
+        WHERE p.tenant_id=$1 AND COALESCE(p.is_active, true) AND p.product_type = 'standard' AND (cardinality($4::uuid[]) = 0 OR p.id = ANY($4::uuid[]))`,
+		tenantID, countID, branchID, ids)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": "No active products to count"})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": countID, "reference_no": ref})
+}
+
+// ListStockCounts lists stock-takes, optionally by ?status and ?branch_id
+func ListStockCounts(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT sc.id, sc.branch_id, b.name, sc.reference_no, sc.status, sc.freeze_stock, COALESCE(sc.notes, ''),
+            COALESCE(sc.adjustment_id::text, ''), sc.created_at, sc.approved_at,
+            (SELECT COUNT(*) FROM stock_count_items ci WHERE ci.count_id = sc.id),
+            (SELECT COUNT(DISTINCT e.item_id) FROM stock_count_entries e WHERE e.count_id = sc.id)
+        FROM stock_counts sc JOIN branches b ON b.id = sc.branch_id
+        WHERE sc.tenant_id=$1 AND (NULLIF($2, '') IS NULL OR sc.status = $2)
+          AND (NULLIF($3, '') IS NULL OR sc.branch_id = NULLIF($3, '')::uuid)
+        ORDER BY sc.created_at DESC`, tenantID, c.Query("status"), c.Query("branch_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	counts := []models.StockCount{}
+	for rows.Next() {
+		var sc models.StockCount
+		rows.Scan(&sc.ID, &sc.BranchID, &sc.BranchName, &sc.ReferenceNo, &sc.Status, &sc.FreezeStock, &sc.Notes,
+			&sc.AdjustmentID, &sc.CreatedAt, &sc.ApprovedAt, &sc.ItemCount, &sc.CountedItems)
+		counts = append(counts, sc)
+	}
+	c.JSON(200, counts)
+}
+
+// GetStockCount returns a stock-take with its variance report
+func GetStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var sc models.StockCount
+	err := db.DB.QueryRow(`SELECT sc.id, sc.branch_id, b.name, sc.reference_no, sc.status, sc.freeze_stock, COALESCE(sc.notes, ''),
+            COALESCE(sc.adjustment_id::text, ''), sc.created_at, sc.approved_at
+        FROM stock_counts sc JOIN branches b ON b.id = sc.branch_id
+        WHERE sc.id=$1 AND sc.tenant_id=$2`, id, tenantID).
+		Scan(&sc.ID, &sc.BranchID, &sc.BranchName, &sc.ReferenceNo, &sc.Status, &sc.FreezeStock, &sc.Notes,
+			&sc.AdjustmentID, &sc.CreatedAt, &sc.ApprovedAt)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+
+	sc.Lines, err = loadStockCountLines(db.DB, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	sc.ItemCount = len(sc.Lines)
+	for _, l := range sc.Lines {
+		if l.CountedQty != nil {
+			sc.CountedItems++
+		}
+	}
+	c.JSON(200, sc)
+}
+
+// ApproveStockCount books the variances as a single 'count' adjustment. Each line
+// moves the current stock by counted less expected, expected being the snapshot
+// plus whatever moved between the snapshot and the item's count. Refused while
+// any item's counters disagree without a reconciled quantity. Serialized items
+// are not adjusted by quantity: their variances are reported back, to be booked
+// by an adjustment naming the serials found or missing.
+func ApproveStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.ApproveStockCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, ref, branchID string
+	err = tx.QueryRow("SELECT status, reference_no, branch_id FROM stock_counts WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).
+		Scan(&status, &ref, &branchID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if status != "open" {
+		c.JSON(400, gin.H{"error": "Stock count is " + status})
+		return
+	}
+
+	lines, err := loadStockCountLines(tx, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	unreconciled := []string{}
+	for _, l := range lines {
+		if l.Disagreement && l.ReconciledQty == nil {
+			unreconciled = append(unreconciled, l.ItemID)
+		}
+	}
+	if len(unreconciled) > 0 {
+		c.JSON(409, gin.H{"error": "Counters disagree on some items; reconcile them before approving", "item_ids": unreconciled})
+		return
+	}
+
+	// Close the count first: frozen items may move again from here
+	_, err = tx.Exec("UPDATE stock_counts SET status='approved', approved_by=$1, approved_at=now() WHERE id=$2", userID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Update failed"})
+		return
+	}
+
+	var adjID string
+	posted := 0
+	bySerial := []models.StockCountLine{}
+	for _, l := range lines {
+		counted, variance := l.CountedQty, l.Variance
+		if counted == nil {
+			if !req.ZeroUncounted {
+				continue
+			}
+			zero, v := 0.0, roundQty(-(l.ExpectedQty + l.MovedQty))
+			counted, variance = &zero, &v
+		}
+
+		var serialized bool
+		if err := tx.QueryRow("SELECT track_serials FROM products WHERE id=$1", l.ProductID).Scan(&serialized); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if serialized && *variance != 0 {
+			// Recorded as counted; the stock moves when the serials are adjusted
+			if _, err := tx.Exec("UPDATE stock_count_items SET counted_qty=$1, qty_change=0 WHERE id=$2", *counted, l.ItemID); err != nil {
+				c.JSON(500, gin.H{"error": "Item update failed"})
+				return
+			}
+			l.CountedQty, l.Variance = counted, variance
+			bySerial = append(bySerial, l)
+			continue
+		}
+
+		if _, err := tx.Exec("UPDATE stock_count_items SET counted_qty=$1, qty_change=$2 WHERE id=$3", *counted, *variance, l.ItemID); err != nil {
+			c.JSON(500, gin.H{"error": "Item update failed"})
+			return
+		}
+		if *variance == 0 {
+			continue
+		}
+
+		if adjID == "" {
+			err = tx.QueryRow(`INSERT INTO adjustments (tenant_id, branch_id, reason, notes, created_by) VALUES ($1, $2, 'count', $3, $4) RETURNING id`,
+				tenantID, branchID, "Stock count "+ref, userID).Scan(&adjID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		_, err = tx.Exec(`INSERT INTO adjustment_items (adjustment_id, product_id, variant_id, qty_change) VALUES ($1, $2, NULLIF($3, '')::uuid, $4)`,
+			adjID, l.ProductID, l.VariantID, *variance)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Item err"})
+			return
+		}
+
+		err = updateStockHelper(tx, tenantID, stockMove{
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			BranchID:  branchID,
+			QtyChange: *variance,
+			RefType:   "adjustment",
+			RefID:     adjID,
+			Note:      "Stock count " + ref,
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+		posted++
+	}
+
+	if adjID != "" {
+		if _, err := tx.Exec("UPDATE stock_counts SET adjustment_id=$1 WHERE id=$2", adjID, id); err != nil {
+			c.JSON(500, gin.H{"error": "Update failed"})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Stock count approved", "adjustment_id": adjID, "lines_adjusted": posted, "adjust_by_serial": bySerial})
+}
+
+// ReconcileStockCount records the quantity to book for items of an open count,
+// typically those whose counters disagree. A later recount of the item clears it.
+func ReconcileStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.ReconcileStockCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM stock_counts WHERE id=$1 AND tenant_id=$2 FOR SHARE", id, tenantID).Scan(&status)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if status != "open" {
+		c.JSON(400, gin.H{"error": "Stock count is " + status})
+		return
+	}
+
+	for _, e := range req.Items {
+		res, err := tx.Exec("UPDATE stock_count_items SET reconciled_qty=$1, reconciled_by=$2 WHERE id::text=$3 AND count_id=$4",
+			e.CountedQty, userID, e.ItemID, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(400, gin.H{"error": "Stock count item not found: " + e.ItemID})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Counts reconciled", "items": len(req.Items)})
+}
+
+func CancelStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	res, err := db.DB.Exec("UPDATE stock_counts SET status='cancelled' WHERE id=$1 AND tenant_id=$2 AND status='open'", id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": "Only open stock counts can be cancelled"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Stock count cancelled"})
+}
+
+// ListOpenStockCounts returns the counts staff can count for at the current branch
+func ListOpenStockCounts(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query(`SELECT sc.id, sc.branch_id, b.name, sc.reference_no, sc.status, sc.freeze_stock, COALESCE(sc.notes, ''), sc.created_at,
+            (SELECT COUNT(*) FROM stock_count_items ci WHERE ci.count_id = sc.id)
+        FROM stock_counts sc JOIN branches b ON b.id = sc.branch_id
+        WHERE sc.tenant_id=$1 AND sc.branch_id=$2 AND sc.status='open'`, tenantID, c.GetString("branchID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	counts := []models.StockCount{}
+	for rows.Next() {
+		var sc models.StockCount
+		rows.Scan(&sc.ID, &sc.BranchID, &sc.BranchName, &sc.ReferenceNo, &sc.Status, &sc.FreezeStock, &sc.Notes, &sc.CreatedAt, &sc.ItemCount)
+		counts = append(counts, sc)
+	}
+	c.JSON(200, counts)
+}
+
+// GetStockCountSheet is the blind count sheet: items without their expected quantity
+func GetStockCountSheet(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	if !openStockCountAtBranch(c, id) {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT ci.id, ci.product_id, COALESCE(ci.variant_id::text, ''), p.name, COALESCE(v.name, ''),
+            COALESCE(v.sku, p.sku, ''), COALESCE(v.barcode, p.barcode, ''), e.counted_qty
+        FROM stock_count_items ci
+        JOIN products p ON p.id = ci.product_id
+        LEFT JOIN product_variants v ON v.id = ci.variant_id
+        LEFT JOIN stock_count_entries e ON e.item_id = ci.id AND e.user_id = $3
+        WHERE ci.count_id=$1 AND ci.tenant_id=$2
+        ORDER BY p.name, v.name`, id, tenantID, c.GetString("userID"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	sheet := []models.StockCountSheetLine{}
+	for rows.Next() {
+		var l models.StockCountSheetLine
+		rows.Scan(&l.ItemID, &l.ProductID, &l.VariantID, &l.ProductName, &l.VariantName, &l.Sku, &l.Barcode, &l.MyCount)
+		sheet = append(sheet, l)
+	}
+	c.JSON(200, sheet)
+}
+
+// SubmitStockCount records the current user's counts; a resubmitted item replaces
+// that user's earlier count
+func SubmitStockCount(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+	var req models.SubmitStockCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Share-lock the count so it cannot be approved halfway through a submission
+	var status, branchID string
+	err = tx.QueryRow("SELECT status, branch_id FROM stock_counts WHERE id=$1 AND tenant_id=$2 FOR SHARE", id, tenantID).Scan(&status, &branchID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return
+	}
+	if status != "open" {
+		c.JSON(400, gin.H{"error": "Stock count is " + status})
+		return
+	}
+	if branchID != c.GetString("branchID") {
+		c.JSON(403, gin.H{"error": "Stock count belongs to another branch"})
+		return
+	}
+
+	for _, e := range req.Items {
+		res, err := tx.Exec(`INSERT INTO stock_count_entries (tenant_id, count_id, item_id, user_id, counted_qty)
+            SELECT $1, $2, id, $3, $4 FROM stock_count_items WHERE id::text=$5 AND count_id=$2
+            ON CONFLICT (item_id, user_id) DO UPDATE SET counted_qty = EXCLUDED.counted_qty, updated_at = now()`,
+			tenantID, id, userID, e.CountedQty, e.ItemID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(400, gin.H{"error": "Stock count item not found: " + e.ItemID})
+			return
+		}
+		// A recount reopens the item's reconciliation
+		if _, err := tx.Exec("UPDATE stock_count_items SET reconciled_qty=NULL, reconciled_by=NULL WHERE id::text=$1", e.ItemID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Counts saved", "items": len(req.Items)})
+}
+
+// openStockCountAtBranch checks a count is open at the current branch, writing the error response if not
+func openStockCountAtBranch(c *gin.Context, countID string) bool {
+	var status, branchID string
+	err := db.DB.QueryRow("SELECT status, branch_id FROM stock_counts WHERE id=$1 AND tenant_id=$2", countID, c.GetString("tenantID")).Scan(&status, &branchID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Stock count not found"})
+		return false
+	}
+	if status != "open" {
+		c.JSON(400, gin.H{"error": "Stock count is " + status})
+		return false
+	}
+	if branchID != c.GetString("branchID") {
+		c.JSON(403, gin.H{"error": "Stock count belongs to another branch"})
+		return false
+	}
+	return true
+}
+
+// loadStockCountLines builds the variance report with each counter's entries.
+// An item's counted quantity is the one its counters agree on, else the
+// reconciled one. Movements are taken from the snapshot up to the item's last
+// count (or approval / now when uncounted); the count's own adjustment is booked
+// at approval time and so never included.
+func loadStockCountLines(q queryer, countID string) ([]models.StockCountLine, error) {
+	rows, err := q.Query(`SELECT ci.id, ci.product_id, COALESCE(ci.variant_id::text, ''), p.name, COALESCE(v.name, ''), ci.expected_qty,
+            COALESCE(m.qty, 0), COALESCE(ci.counted_qty, ci.reconciled_qty, CASE WHEN e.low = e.high THEN e.low END), COALESCE(e.counters, 0),
+            COALESCE(e.low <> e.high, false), ci.reconciled_qty, COALESCE(NULLIF(i.avg_cost, 0), p.cost_price, 0)
+        FROM stock_count_items ci
+        JOIN stock_counts sc ON sc.id = ci.count_id
+        JOIN products p ON p.id = ci.product_id
+        LEFT JOIN product_variants v ON v.id = ci.variant_id
+        LEFT JOIN inventory_stock i ON i.product_id = ci.product_id AND i.branch_id = sc.branch_id AND i.variant_id IS NOT DISTINCT FROM ci.variant_id
+        LEFT JOIN LATERAL (
+            SELECT MIN(counted_qty) AS low, MAX(counted_qty) AS high, COUNT(*) AS counters, MAX(updated_at) AS last_at
+            FROM stock_count_entries WHERE item_id = ci.id
+        ) e ON true
+        LEFT JOIN LATERAL (
+            SELECT SUM(l.qty_change) AS qty FROM stock_ledger l
+            WHERE l.tenant_id = sc.tenant_id AND l.branch_id = sc.branch_id AND l.product_id = ci.product_id
+              AND l.variant_id IS NOT DISTINCT FROM ci.variant_id
+              AND l.created_at > sc.created_at AND l.created_at < COALESCE(e.last_at, sc.approved_at, now())
+        ) m ON true
+        WHERE ci.count_id=$1
+        ORDER BY p.name, v.name`, countID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.StockCountLine{}
+	byItem := map[string]int{}
+	for rows.Next() {
+		var l models.StockCountLine
+		var counted, reconciled sql.NullFloat64
+		var avgCost float64
+		if err := rows.Scan(&l.ItemID, &l.ProductID, &l.VariantID, &l.ProductName, &l.VariantName, &l.ExpectedQty,
+			&l.MovedQty, &counted, &l.Counters, &l.Disagreement, &reconciled, &avgCost); err != nil {
+			return nil, err
+		}
+		if counted.Valid {
+			qty := counted.Float64
+			variance := roundQty(qty - (l.ExpectedQty + l.MovedQty))
+			l.CountedQty, l.Variance = &qty, &variance
+			l.VarianceValue = roundMoney(variance * avgCost)
+		}
+		if reconciled.Valid {
+			l.ReconciledQty = &reconciled.Float64
+		}
+		l.Entries = []models.StockCountEntry{}
+		byItem[l.ItemID] = len(lines)
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT e.item_id, e.user_id, COALESCE(u.full_name, u.email, ''), e.counted_qty, e.updated_at
+        FROM stock_count_entries e LEFT JOIN users u ON u.id = e.user_id
+        WHERE e.count_id=$1 ORDER BY e.updated_at`, countID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemID string
+		var e models.StockCountEntry
+		if err := rows.Scan(&itemID, &e.UserID, &e.UserName, &e.CountedQty, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := byItem[itemID]; ok {
+			lines[i].Entries = append(lines[i].Entries, e)
+		}
+	}
+	return lines, rows.Err()
+}
@@ -2,42 +2,169 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 )
 
+// stockMove is one stock change of a product (or one of its variants) at a
+// branch and the document it belongs to. An empty VariantID means product-level stock.
+type stockMove struct {
+	ProductID string
+	VariantID string
+	BranchID  string
+	QtyChange float64 // Can be negative; in the product's stock unit
+	RefType   string
+	RefID     string
+	Note      string
+	UnitCost  *float64 // Cost of incoming units; nil = the item's current cost
+	LotID     string   // Lot moved; outgoing moves of lot-tracked products without one are allocated FEFO
+	// Let an outgoing move take stock below zero (offline sales resolved as such)
+	AllowNegative bool
+}
+
+// roundQty rounds a quantity to the 3 decimals stock is held in
+func roundQty(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// lockStockQty returns the current quantity of a stock item at a branch and locks its row.
+// A missing row reads as 0.
+func lockStockQty(tx *sql.Tx, tenantID, productID, variantID, branchID string) (qty float64, exists bool, err error) {
+	err = tx.QueryRow(`SELECT quantity FROM inventory_stock
+        WHERE product_id=$1 AND tenant_id=$2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid AND branch_id=$4 FOR UPDATE`,
+		productID, tenantID, variantID, branchID).Scan(&qty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return qty, err == nil, err
+}
+
 // updateStockHelper handles both inventory_stock update and stock_ledger insert
 // Must be called within an existing transaction
-func updateStockHelper(tx *sql.Tx, tenantID, productID string, qtyChange int, refType, refID, note string) error {
+func updateStockHelper(tx *sql.Tx, tenantID string, m stockMove) error {
+	_, err := moveStock(tx, tenantID, m)
+	return err
+}
+
+// moveStock applies a stock move, values it with the tenant's costing method and
+// returns the unit cost it was booked at. Outgoing stock of a lot-tracked product
+// is split over its lots (one ledger row each). Must be called within an existing transaction
+func moveStock(tx *sql.Tx, tenantID string, m stockMove) (float64, error) {
+	unitCost, _, err := moveStockLots(tx, tenantID, m)
+	return unitCost, err
+}
+
+// moveStockLots is moveStock that also reports the lots an outgoing move was
+// taken from (nil when the product does not track lots), for moves whose goods
+// must keep their lot on the way back in
+func moveStockLots(tx *sql.Tx, tenantID string, m stockMove) (float64, []lotPart, error) {
+	if m.QtyChange >= 0 || m.LotID != "" {
+		unitCost, err := applyStockMove(tx, tenantID, m)
+		return unitCost, nil, err
+	}
+
+	parts, err := allocateLots(tx, tenantID, m)
+	if err != nil {
+		return 0, nil, err
+	}
+	if parts == nil {
+		unitCost, err := applyStockMove(tx, tenantID, m)
+		return unitCost, nil, err
+	}
+
+	var total float64
+	for _, p := range parts {
+		part := m
+		part.QtyChange = -p.qty
+		part.LotID = p.lotID
+		unitCost, err := applyStockMove(tx, tenantID, part)
+		if err != nil {
+			return 0, nil, err
+		}
+		total += unitCost * p.qty
+	}
+	return roundCost(total / -m.QtyChange), parts, nil
+}
+
+// applyStockMove books a single stock move (at most one lot)
+func applyStockMove(tx *sql.Tx, tenantID string, m stockMove) (float64, error) {
+	if m.BranchID == "" {
+		return 0, errors.New("stock move has no branch")
+	}
+
 	// 1. Get Current Stock (Locking)
-	var currentQty int
-	err := tx.QueryRow("SELECT quantity FROM inventory_stock WHERE product_id=$1 AND tenant_id=$2 FOR UPDATE", productID, tenantID).Scan(&currentQty)
-	if err == sql.ErrNoRows {
-		// If product has no stock record, treat as 0 and insert
-		currentQty = 0
-		// Insert record first if not exists
-		_, err = tx.Exec("INSERT INTO inventory_stock (tenant_id, product_id, quantity) VALUES ($1, $2, 0) ON CONFLICT DO NOTHING", tenantID, productID)
+	currentQty, exists, err := lockStockQty(tx, tenantID, m.ProductID, m.VariantID, m.BranchID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		// If item has no stock record at this branch, treat as 0 and insert
+		_, err = tx.Exec("INSERT INTO inventory_stock (tenant_id, product_id, variant_id, branch_id, quantity) VALUES ($1, $2, NULLIF($3, '')::uuid, $4, 0) ON CONFLICT DO NOTHING",
+			tenantID, m.ProductID, m.VariantID, m.BranchID)
 		if err != nil {
-			return err
+			return 0, err
 		}
-	} else if err != nil {
-		return err
+		// Lock the row (ours, or one a concurrent transaction just created)
+		if currentQty, _, err = lockStockQty(tx, tenantID, m.ProductID, m.VariantID, m.BranchID); err != nil {
+			return 0, err
+		}
+	}
+
+	// A frozen stock count holds the item still until it is approved or cancelled
+	var countRef string
+	err = tx.QueryRow(`SELECT sc.reference_no FROM stock_counts sc JOIN stock_count_items ci ON ci.count_id = sc.id
+        WHERE sc.tenant_id=$1 AND sc.branch_id=$2 AND sc.status='open' AND sc.freeze_stock
+          AND ci.product_id=$3 AND ci.variant_id IS NOT DISTINCT FROM NULLIF($4, '')::uuid`,
+		tenantID, m.BranchID, m.ProductID, m.VariantID).Scan(&countRef)
+	if err == nil {
+		return 0, &stockFrozenError{ProductID: m.ProductID, VariantID: m.VariantID, ReferenceNo: countRef}
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if err := checkStockUnit(tx, tenantID, m.ProductID, m.QtyChange); err != nil {
+		return 0, err
+	}
+
+	newQty := roundQty(currentQty + m.QtyChange)
+	if newQty < 0 && !m.AllowNegative {
+		return 0, fmt.Errorf("insufficient stock for product %s. Current: %g, Requested Change: %g", m.ProductID, currentQty, m.QtyChange)
 	}
 
-	newQty := currentQty + qtyChange
-	if newQty < 0 {
-		return fmt.Errorf("insufficient stock for product %s. Current: %d, Requested Change: %d", productID, currentQty, qtyChange)
+	if m.LotID != "" {
+		res, err := tx.Exec(`UPDATE stock_lots SET quantity = quantity + $1, updated_at=now()
+            WHERE id=$2 AND tenant_id=$3 AND product_id=$4 AND variant_id IS NOT DISTINCT FROM NULLIF($5, '')::uuid AND branch_id=$6 AND quantity + $1 >= 0`,
+			m.QtyChange, m.LotID, tenantID, m.ProductID, m.VariantID, m.BranchID)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, &validationError{fmt.Sprintf("Lot %s does not hold %g of product %s at this branch", m.LotID, -m.QtyChange, m.ProductID)}
+		}
 	}
 
-	// 2. Update Stock
-	_, err = tx.Exec("UPDATE inventory_stock SET quantity=$1, updated_at=now() WHERE product_id=$2 AND tenant_id=$3", newQty, productID, tenantID)
+	// 2. Value the move (cost layers, weighted average or FIFO)
+	c, err := costMove(tx, tenantID, m, currentQty)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// 3. Insert Ledger
-	_, err = tx.Exec(`INSERT INTO stock_ledger (tenant_id, product_id, ref_type, ref_id, qty_change, qty_after, note)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		tenantID, productID, refType, refID, qtyChange, newQty, note)
+	// 3. Update Stock
+	_, err = tx.Exec(`UPDATE inventory_stock SET quantity=$1, stock_value=$2, avg_cost=$3, updated_at=now()
+        WHERE product_id=$4 AND tenant_id=$5 AND variant_id IS NOT DISTINCT FROM NULLIF($6, '')::uuid AND branch_id=$7`,
+		newQty, c.StockValue, c.AvgCost, m.ProductID, tenantID, m.VariantID, m.BranchID)
+	if err != nil {
+		return 0, err
+	}
 
-	return err
+	// 4. Insert Ledger
+	_, err = tx.Exec(`INSERT INTO stock_ledger (tenant_id, product_id, variant_id, branch_id, ref_type, ref_id, qty_change, qty_after, note, unit_cost, cost_amount, lot_id)
+        VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, '')::uuid)`,
+		tenantID, m.ProductID, m.VariantID, m.BranchID, m.RefType, m.RefID, m.QtyChange, newQty, m.Note, c.UnitCost, c.Amount, m.LotID)
+	if err != nil {
+		return 0, err
+	}
+	return c.UnitCost, nil
 }
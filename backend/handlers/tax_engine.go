@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// taxRateCache memoises tax class lookups for the duration of one transaction
+type taxRateCache map[string][]models.TaxRate
+
+// classRates returns the active rates of a tax class in application order.
+// An empty classID means the product is untaxed.
+func (tc taxRateCache) classRates(tx *sql.Tx, tenantID, classID string) ([]models.TaxRate, error) {
+	if classID == "" {
+		return nil, nil
+	}
+	if rates, ok := tc[classID]; ok {
+		return rates, nil
+	}
+
+	rows, err := tx.Query(`SELECT r.id, r.name, r.rate, r.is_compound, r.is_active
+        FROM tax_class_rates cr JOIN tax_rates r ON r.id = cr.tax_rate_id
+        WHERE cr.tax_class_id=$1 AND cr.tenant_id=$2 AND r.is_active = true
+        ORDER BY cr.priority`, classID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.TaxRate
+	for rows.Next() {
+		var r models.TaxRate
+		if err := rows.Scan(&r.ID, &r.Name, &r.Rate, &r.IsCompound, &r.IsActive); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	tc[classID] = rates
+	return rates, nil
+}
+
+// tenantPricesIncludeTax reports whether the tenant's selling prices are tax-inclusive
+func tenantPricesIncludeTax(tx *sql.Tx, tenantID string) (bool, error) {
+	var inclusive bool
+	err := tx.QueryRow("SELECT COALESCE(prices_include_tax, false) FROM tenants WHERE id=$1", tenantID).Scan(&inclusive)
+	return inclusive, err
+}
+
+// taxMultiplier is the factor a net amount grows by once every rate is applied
+func taxMultiplier(rates []models.TaxRate) float64 {
+	var running float64
+	for _, r := range rates {
+		base := 1.0
+		if r.IsCompound {
+			base += running
+		}
+		running += base * r.Rate / 100
+	}
+	return 1 + running
+}
+
+// computeLineTax splits a line amount into its taxable net and the taxes on it.
+// Exclusive pricing: amount is the net and taxes are added on top.
+// Inclusive pricing: amount already contains the taxes; the net is backed out
+// so that net + tax equals amount exactly after rounding.
+func computeLineTax(amount float64, rates []models.TaxRate, inclusive bool) (net, tax float64, lines []models.TaxLine) {
+	net = roundMoney(amount)
+	if len(rates) == 0 {
+		return net, 0, nil
+	}
+	if inclusive {
+		net = roundMoney(amount / taxMultiplier(rates))
+	}
+
+	var running float64
+	for _, r := range rates {
+		base := net
+		if r.IsCompound {
+			base = roundMoney(net + running)
+		}
+		t := roundMoney(base * r.Rate / 100)
+		running += t
+		lines = append(lines, models.TaxLine{
+			TaxRateID:     r.ID,
+			Name:          r.Name,
+			Rate:          r.Rate,
+			IsCompound:    r.IsCompound,
+			TaxableAmount: base,
+			TaxAmount:     t,
+		})
+	}
+	tax = roundMoney(running)
+
+	if inclusive {
+		// Absorb rounding drift into the net so the line still totals to the shelf price
+		adjusted := roundMoney(amount - tax)
+		if adjusted != net {
+			for i := range lines {
+				if !lines[i].IsCompound {
+					lines[i].TaxableAmount = adjusted
+				}
+			}
+			net = adjusted
+		}
+	}
+	return net, tax, lines
+}
+
+// mergeTaxLines folds line taxes into a per-rate document breakdown
+func mergeTaxLines(breakdown []models.TaxLine, lines []models.TaxLine) []models.TaxLine {
+	for _, l := range lines {
+		found := false
+		for i := range breakdown {
+			if breakdown[i].TaxRateID == l.TaxRateID {
+				breakdown[i].TaxableAmount = roundMoney(breakdown[i].TaxableAmount + l.TaxableAmount)
+				breakdown[i].TaxAmount = roundMoney(breakdown[i].TaxAmount + l.TaxAmount)
+				found = true
+				break
+			}
+		}
+		if !found {
+			breakdown = append(breakdown, l)
+		}
+	}
+	return breakdown
+}
+
+// allocateDiscount spreads a document-level discount across lines in proportion
+// to their amounts. The last line takes the rounding remainder.
+func allocateDiscount(amounts []float64, discount float64) []float64 {
+	shares := make([]float64, len(amounts))
+	var total float64
+	for _, a := range amounts {
+		total += a
+	}
+	if discount <= 0 || total <= 0 {
+		return shares
+	}
+	if discount > total {
+		discount = total
+	}
+
+	remaining := roundMoney(discount)
+	for i, a := range amounts {
+		if i == len(amounts)-1 {
+			shares[i] = remaining
+			break
+		}
+		s := roundMoney(discount * a / total)
+		shares[i] = s
+		remaining = roundMoney(remaining - s)
+	}
+	return shares
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+func TestComputeLineTax(t *testing.T) {
+	vat := models.TaxRate{ID: "vat", Name: "VAT", Rate: 10}
+	gst := models.TaxRate{ID: "gst", Name: "GST", Rate: 5}
+	pst := models.TaxRate{ID: "pst", Name: "PST", Rate: 10, IsCompound: true}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		rates     []models.TaxRate
+		inclusive bool
+		net, tax  float64
+		taxable   []float64 // Per rate
+	}{
+		{"untaxed", 10, nil, false, 10, 0, nil},
+		{"untaxed inclusive", 10, nil, true, 10, 0, nil},
+		{"exclusive", 100, []models.TaxRate{vat}, false, 100, 10, []float64{100}},
+		{"exclusive compound on the running total", 100, []models.TaxRate{gst, pst}, false, 100, 15.5, []float64{100, 105}},
+		{"inclusive backs out the net", 110, []models.TaxRate{vat}, true, 100, 10, []float64{100}},
+		{"inclusive compound", 115.5, []models.TaxRate{gst, pst}, true, 100, 15.5, []float64{100, 105}},
+		{"inclusive drift goes to the net", 0.05, []models.TaxRate{vat}, true, 0.04, 0.01, []float64{0.04}},
+		{"exclusive per-rate rounding", 9.99, []models.TaxRate{gst, vat}, false, 9.99, 1.5, []float64{9.99, 9.99}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net, tax, lines := computeLineTax(tt.amount, tt.rates, tt.inclusive)
+			if net != tt.net || tax != tt.tax {
+				t.Fatalf("computeLineTax(%v) = net %v, tax %v; want net %v, tax %v", tt.amount, net, tax, tt.net, tt.tax)
+			}
+			if len(lines) != len(tt.taxable) {
+				t.Fatalf("got %d tax lines, want %d", len(lines), len(tt.taxable))
+			}
+			var sum float64
+			for i, l := range lines {
+				if l.TaxableAmount != tt.taxable[i] {
+					t.Errorf("line %d (%s) taxable %v, want %v", i, l.Name, l.TaxableAmount, tt.taxable[i])
+				}
+				sum += l.TaxAmount
+			}
+			if roundMoney(sum) != tax {
+				t.Errorf("tax lines sum to %v, tax is %v", roundMoney(sum), tax)
+			}
+			if tt.inclusive && roundMoney(net+tax) != roundMoney(tt.amount) {
+				t.Errorf("inclusive net %v + tax %v does not total %v", net, tax, tt.amount)
+			}
+		})
+	}
+}
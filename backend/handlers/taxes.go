@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// Tax Rates
+func ListTaxRates(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query("SELECT id, name, rate, is_compound, is_active FROM tax_rates WHERE tenant_id=$1 ORDER BY name", tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var rates []models.TaxRate
+	for rows.Next() {
+		var r models.TaxRate
+		rows.Scan(&r.ID, &r.Name, &r.Rate, &r.IsCompound, &r.IsActive)
+		rates = append(rates, r)
+	}
+	if rates == nil {
+		rates = []models.TaxRate{}
+	}
+	c.JSON(200, rates)
+}
+
+func CreateTaxRate(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	var id string
+	err := db.DB.QueryRow("INSERT INTO tax_rates (tenant_id, name, rate, is_compound, is_active) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		tenantID, req.Name, req.Rate, req.IsCompound, isActive).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id})
+}
+
+// UpdateTaxRate changes a rate for future documents only; sale and purchase
+// lines keep the snapshot taken when they were recorded.
+func UpdateTaxRate(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	res, err := db.DB.Exec("UPDATE tax_rates SET name=$1, rate=$2, is_compound=$3, is_active=$4, updated_at=now() WHERE id=$5 AND tenant_id=$6",
+		req.Name, req.Rate, req.IsCompound, isActive, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Tax rate not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+// Tax Classes
+func ListTaxClasses(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(description, '') FROM tax_classes WHERE tenant_id=$1 ORDER BY name", tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var classes []models.TaxClass
+	for rows.Next() {
+		var tc models.TaxClass
+		rows.Scan(&tc.ID, &tc.Name, &tc.Description)
+		classes = append(classes, tc)
+	}
+	rows.Close()
+
+	for i := range classes {
+		rRows, err := db.DB.Query(`SELECT r.id, r.name, r.rate, r.is_compound, r.is_active
+            FROM tax_class_rates cr JOIN tax_rates r ON r.id = cr.tax_rate_id
+            WHERE cr.tax_class_id=$1 ORDER BY cr.priority`, classes[i].ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		for rRows.Next() {
+			var r models.TaxRate
+			rRows.Scan(&r.ID, &r.Name, &r.Rate, &r.IsCompound, &r.IsActive)
+			classes[i].Rates = append(classes[i].Rates, r)
+		}
+		rRows.Close()
+		if classes[i].Rates == nil {
+			classes[i].Rates = []models.TaxRate{}
+		}
+	}
+
+	if classes == nil {
+		classes = []models.TaxClass{}
+	}
+	c.JSON(200, classes)
+}
+
+func CreateTaxClass(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.TaxClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow("INSERT INTO tax_classes (tenant_id, name, description) VALUES ($1, $2, $3) RETURNING id",
+		tenantID, req.Name, req.Description).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setTaxClassRates(tx, tenantID, id, req.RateIDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": id})
+}
+
+// UpdateTaxClass renames a class and replaces its ordered rate list
+func UpdateTaxClass(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.TaxClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE tax_classes SET name=$1, description=$2, updated_at=now() WHERE id=$3 AND tenant_id=$4",
+		req.Name, req.Description, id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Tax class not found"})
+		return
+	}
+
+	if err := setTaxClassRates(tx, tenantID, id, req.RateIDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+// setTaxClassRates replaces the rates of a class, keeping the given order as priority
+func setTaxClassRates(tx *sql.Tx, tenantID, classID string, rateIDs []string) error {
+	if _, err := tx.Exec("DELETE FROM tax_class_rates WHERE tax_class_id=$1", classID); err != nil {
+		return err
+	}
+	for i, rateID := range rateIDs {
+		// Tenant check via INSERT ... SELECT so a foreign rate id inserts nothing
+		res, err := tx.Exec(`INSERT INTO tax_class_rates (tenant_id, tax_class_id, tax_rate_id, priority)
+            SELECT $1, $2, id, $3 FROM tax_rates WHERE id=$4 AND tenant_id=$1`,
+			tenantID, classID, i, rateID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return &validationError{"Tax rate not found: " + rateID}
+		}
+	}
+	return nil
+}
+
+// taxClassBelongsToTenant guards product tax class assignment; empty means untaxed
+func taxClassBelongsToTenant(tenantID, classID string) bool {
+	if classID == "" {
+		return true
+	}
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM tax_classes WHERE id=$1 AND tenant_id=$2)", classID, tenantID).Scan(&exists)
+	return exists
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// tenantLocation is the tenant's timezone; unknown zone names read as UTC
+func tenantLocation(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, tenantID string) *time.Location {
+	var timezone string
+	q.QueryRow("SELECT COALESCE(timezone, 'UTC') FROM tenants WHERE id=$1", tenantID).Scan(&timezone)
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// tenantToday is the current date (YYYY-MM-DD) in the tenant's timezone, for
+// date comparisons such as lot expiry that CURRENT_DATE would make in the
+// database's timezone
+func tenantToday(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, tenantID string) string {
+	return time.Now().In(tenantLocation(q, tenantID)).Format("2006-01-02")
+}
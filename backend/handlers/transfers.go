@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// Stock transfers move goods between branches in two steps: dispatch takes the
+// stock out of the source branch (transfer_out), receipt books it into the
+// destination (transfer_in). What is dispatched but not yet received is in transit.
+
+func ListTransfers(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	status := c.Query("status")
+	branchID := c.Query("branch_id") // Either side of the transfer
+
+	query := `SELECT t.id, t.reference_no, t.from_branch_id, fb.name, t.to_branch_id, tb.name, t.status, COALESCE(t.notes, ''), t.created_at, t.dispatched_at, t.received_at
+              FROM stock_transfers t
+              JOIN branches fb ON fb.id = t.from_branch_id
+              JOIN branches tb ON tb.id = t.to_branch_id
+              WHERE t.tenant_id=$1`
+	args := []interface{}{tenantID}
+	if status != "" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND t.status=$%d", len(args))
+	}
+	if branchID != "" {
+		args = append(args, branchID)
+		query += fmt.Sprintf(" AND (t.from_branch_id=$%d OR t.to_branch_id=$%d)", len(args), len(args))
+	}
+	query += " ORDER BY t.created_at DESC LIMIT 100"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var transfers []models.StockTransfer
+	for rows.Next() {
+		var t models.StockTransfer
+		scanTransfer(rows, &t)
+		transfers = append(transfers, t)
+	}
+	if transfers == nil {
+		transfers = []models.StockTransfer{}
+	}
+	c.JSON(200, transfers)
+}
+
+func GetTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	var t models.StockTransfer
+	err := scanTransfer(db.DB.QueryRow(`SELECT t.id, t.reference_no, t.from_branch_id, fb.name, t.to_branch_id, tb.name, t.status, COALESCE(t.notes, ''), t.created_at, t.dispatched_at, t.received_at
+        FROM stock_transfers t
+        JOIN branches fb ON fb.id = t.from_branch_id
+        JOIN branches tb ON tb.id = t.to_branch_id
+        WHERE t.id=$1 AND t.tenant_id=$2`, id, tenantID), &t)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT i.id, i.product_id, COALESCE(i.variant_id::text, ''), p.name, COALESCE(v.name, ''), i.quantity, i.quantity_received, i.quantity_short, COALESCE(i.discrepancy_note, '')
+        FROM stock_transfer_items i JOIN products p ON p.id = i.product_id
+        LEFT JOIN product_variants v ON v.id = i.variant_id
+        WHERE i.transfer_id=$1 ORDER BY p.name`, t.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load items"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.StockTransferItem
+		rows.Scan(&i.ID, &i.ProductID, &i.VariantID, &i.ProductName, &i.VariantName, &i.Quantity, &i.QuantityReceived, &i.QuantityShort, &i.DiscrepancyNote)
+		if t.Status == "dispatched" || t.Status == "partially_received" {
+			i.InTransit = i.Quantity - i.QuantityReceived - i.QuantityShort
+		}
+		t.Items = append(t.Items, i)
+	}
+	if t.Items == nil {
+		t.Items = []models.StockTransferItem{}
+	}
+	c.JSON(200, t)
+}
+
+// CreateTransfer drafts a transfer; no stock moves until it is dispatched
+func CreateTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	var req models.CreateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	fromBranch := req.FromBranchID
+	if fromBranch == "" {
+		fromBranch = c.GetString("branchID")
+	}
+	if fromBranch == req.ToBranchID {
+		c.JSON(400, gin.H{"error": "Source and destination branch must differ"})
+		return
+	}
+	if !branchUsable(c, fromBranch) {
+		return
+	}
+	found, active, _, err := services.BranchAccess(tenantID, userID, c.GetString("role"), req.ToBranchID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found || !active {
+		c.JSON(400, gin.H{"error": "Destination branch not found or inactive"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, item := range req.Items {
+		if err := checkStockItem(tx, tenantID, item.ProductID, item.VariantID, false); err != nil {
+			respondTxError(c, err)
+			return
+		}
+		// Transfers move quantities, not serials: a serialized unit would stay on the old branch
+		var serialized bool
+		tx.QueryRow("SELECT track_serials FROM products WHERE id=$1", item.ProductID).Scan(&serialized)
+		if serialized {
+			c.JSON(400, gin.H{"error": "Serialized products cannot be transferred", "product_id": item.ProductID})
+			return
+		}
+	}
+
+	ref := req.ReferenceNo
+	if ref == "" {
+		// Serialise numbering per tenant
+		var locked string
+		if err := tx.QueryRow("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID).Scan(&locked); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		var count int
+		tx.QueryRow("SELECT COUNT(*) FROM stock_transfers WHERE tenant_id=$1", tenantID).Scan(&count)
+		ref = fmt.Sprintf("TRF-%06d", count+1)
+	}
+	var taken bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM stock_transfers WHERE tenant_id=$1 AND reference_no=$2)", tenantID, ref).Scan(&taken)
+	if taken {
+		c.JSON(409, gin.H{"error": "Reference number already in use"})
+		return
+	}
+
+	var transferID string
+	err = tx.QueryRow(`INSERT INTO stock_transfers (tenant_id, reference_no, from_branch_id, to_branch_id, notes, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		tenantID, ref, fromBranch, req.ToBranchID, req.Notes, userID).Scan(&transferID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, item := range req.Items {
+		_, err = tx.Exec(`INSERT INTO stock_transfer_items (tenant_id, transfer_id, product_id, variant_id, quantity)
+            VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5)`,
+			tenantID, transferID, item.ProductID, item.VariantID, item.Quantity)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Item insert failed"})
+			return
+		}
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": transferID, "reference_no": ref})
+}
+
+// DispatchTransfer takes the goods out of the source branch; they are in transit until received
+func DispatchTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	userID := c.GetString("userID")
+	id := c.Param("id")
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, ref, fromBranch string
+	err = tx.QueryRow("SELECT status, reference_no, from_branch_id FROM stock_transfers WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).
+		Scan(&status, &ref, &fromBranch)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if status != "draft" {
+		c.JSON(400, gin.H{"error": "Only draft transfers can be dispatched"})
+		return
+	}
+	if !branchUsable(c, fromBranch) {
+		return
+	}
+
+	items, err := loadTransferItems(tx, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Items fetch failed"})
+		return
+	}
+
+	for _, item := range items {
+		// Re-check: the product may have changed since the draft
+		if err := checkStockItem(tx, tenantID, item.ProductID, item.VariantID, false); err != nil {
+			respondTxError(c, err)
+			return
+		}
+		available, _, err := lockStockQty(tx, tenantID, item.ProductID, item.VariantID, fromBranch)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if available < item.Quantity {
+			var name string
+			tx.QueryRow("SELECT name FROM products WHERE id=$1", item.ProductID).Scan(&name)
+			respondTxError(c, &insufficientStockError{ProductID: item.ProductID, VariantID: item.VariantID, Name: name, Available: available, Requested: item.Quantity})
+			return
+		}
+
+		unitCost, lots, err := moveStockLots(tx, tenantID, stockMove{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			BranchID:  fromBranch,
+			QtyChange: -item.Quantity,
+			RefType:   "transfer_out",
+			RefID:     id,
+			Note:      "Transfer " + ref + " dispatched",
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+		// The receiving branch takes the goods in at this cost
+		if _, err := tx.Exec("UPDATE stock_transfer_items SET unit_cost=$1 WHERE id=$2", unitCost, item.ID); err != nil {
+			c.JSON(500, gin.H{"error": "Item update failed"})
+			return
+		}
+		// ...and in the lots they left from
+		for _, l := range lots {
+			_, err := tx.Exec(`INSERT INTO stock_transfer_item_lots (tenant_id, transfer_item_id, lot_id, lot_number, expiry_date, quantity)
+                SELECT $1, $2, NULLIF($3, '')::uuid, l.lot_number, l.expiry_date, $4
+                FROM (SELECT 1) one LEFT JOIN stock_lots l ON l.id = NULLIF($3, '')::uuid`,
+				tenantID, item.ID, l.lotID, l.qty)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Item lot save failed"})
+				return
+			}
+		}
+	}
+
+	_, err = tx.Exec("UPDATE stock_transfers SET status='dispatched', dispatched_by=$1, dispatched_at=now(), updated_at=now() WHERE id=$2", userID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Update failed"})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Transfer dispatched"})
+}
+
+// ReceiveTransfer books (part of) the goods in transit into the destination branch.
+// Closing the transfer records anything still outstanding as short.
+func ReceiveTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+	var req models.ReceiveTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) == 0 && !req.Close {
+		c.JSON(400, gin.H{"error": "Nothing to receive"})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, ref, toBranch string
+	err = tx.QueryRow("SELECT status, reference_no, to_branch_id FROM stock_transfers WHERE id=$1 AND tenant_id=$2 FOR UPDATE", id, tenantID).
+		Scan(&status, &ref, &toBranch)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if status != "dispatched" && status != "partially_received" {
+		c.JSON(400, gin.H{"error": "Only dispatched transfers can be received"})
+		return
+	}
+	if !branchUsable(c, toBranch) {
+		return
+	}
+
+	for _, r := range req.Items {
+		var productID, variantID string
+		var outstanding float64
+		var unitCost sql.NullFloat64
+		err := tx.QueryRow(`SELECT product_id, COALESCE(variant_id::text, ''), quantity - quantity_received - quantity_short, unit_cost
+            FROM stock_transfer_items WHERE id::text=$1 AND transfer_id=$2 FOR UPDATE`, r.ItemID, id).
+			Scan(&productID, &variantID, &outstanding, &unitCost)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Transfer item not found: " + r.ItemID})
+			return
+		}
+		if r.Quantity > outstanding {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Cannot receive %g of item %s; %g in transit", r.Quantity, r.ItemID, outstanding)})
+			return
+		}
+
+		if r.Quantity > 0 {
+			move := stockMove{
+				ProductID: productID,
+				VariantID: variantID,
+				BranchID:  toBranch,
+				QtyChange: r.Quantity,
+				RefType:   "transfer_in",
+				RefID:     id,
+				Note:      "Transfer " + ref + " received",
+			}
+			if unitCost.Valid {
+				move.UnitCost = &unitCost.Float64
+			}
+			if err := receiveTransferLots(tx, tenantID, r.ItemID, move); err != nil {
+				respondTxError(c, err)
+				return
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE stock_transfer_items SET quantity_received = quantity_received + $1,
+            discrepancy_note = COALESCE(NULLIF($2, ''), discrepancy_note) WHERE id=$3`, r.Quantity, r.Note, r.ItemID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Item update failed"})
+			return
+		}
+	}
+
+	if req.Close {
+		_, err = tx.Exec("UPDATE stock_transfer_items SET quantity_short = quantity - quantity_received WHERE transfer_id=$1", id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Item update failed"})
+			return
+		}
+	}
+
+	var outstanding, received float64
+	tx.QueryRow("SELECT COALESCE(SUM(quantity - quantity_received - quantity_short), 0), COALESCE(SUM(quantity_received), 0) FROM stock_transfer_items WHERE transfer_id=$1", id).
+		Scan(&outstanding, &received)
+
+	newStatus := "dispatched"
+	var receivedAt *time.Time
+	if outstanding == 0 {
+		newStatus = "received"
+		now := time.Now()
+		receivedAt = &now
+	} else if received > 0 {
+		newStatus = "partially_received"
+	}
+
+	_, err = tx.Exec("UPDATE stock_transfers SET status=$1, received_at=$2, updated_at=now() WHERE id=$3", newStatus, receivedAt, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Update failed"})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(200, gin.H{"message": "Transfer updated", "status": newStatus, "in_transit": outstanding})
+}
+
+// CancelTransfer drops a draft; dispatched goods must be received (or closed short) instead
+func CancelTransfer(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	id := c.Param("id")
+
+	res, err := db.DB.Exec("UPDATE stock_transfers SET status='cancelled', updated_at=now() WHERE id=$1 AND tenant_id=$2 AND status='draft'", id, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(400, gin.H{"error": "Only draft transfers can be cancelled"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Transfer cancelled"})
+}
+
+// GetInTransitStock lists goods dispatched but not yet received, per transfer line
+func GetInTransitStock(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	branchID := c.Query("branch_id") // Either side of the transfer
+
+	rows, err := db.DB.Query(`SELECT t.id, t.reference_no, t.from_branch_id, t.to_branch_id, i.product_id, COALESCE(i.variant_id::text, ''), p.name, COALESCE(v.name, ''),
+            i.quantity - i.quantity_received - i.quantity_short
+        FROM stock_transfer_items i
+        JOIN stock_transfers t ON t.id = i.transfer_id
+        JOIN products p ON p.id = i.product_id
+        LEFT JOIN product_variants v ON v.id = i.variant_id
+        WHERE t.tenant_id=$1 AND t.status IN ('dispatched', 'partially_received')
+          AND i.quantity - i.quantity_received - i.quantity_short > 0
+          AND (NULLIF($2, '') IS NULL OR t.from_branch_id = NULLIF($2, '')::uuid OR t.to_branch_id = NULLIF($2, '')::uuid)
+        ORDER BY t.dispatched_at, p.name`, tenantID, branchID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var stock []models.InTransitStock
+	for rows.Next() {
+		var s models.InTransitStock
+		rows.Scan(&s.TransferID, &s.ReferenceNo, &s.FromBranchID, &s.ToBranchID, &s.ProductID, &s.VariantID, &s.ProductName, &s.VariantName, &s.Quantity)
+		stock = append(stock, s)
+	}
+	if stock == nil {
+		stock = []models.InTransitStock{}
+	}
+	c.JSON(200, stock)
+}
+
+func scanTransfer(row interface{ Scan(...interface{}) error }, t *models.StockTransfer) error {
+	var dispatchedAt, receivedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.ReferenceNo, &t.FromBranchID, &t.FromBranchName, &t.ToBranchID, &t.ToBranchName, &t.Status, &t.Notes, &t.CreatedAt, &dispatchedAt, &receivedAt)
+	if dispatchedAt.Valid {
+		t.DispatchedAt = &dispatchedAt.Time
+	}
+	if receivedAt.Valid {
+		t.ReceivedAt = &receivedAt.Time
+	}
+	return err
+}
+
+func loadTransferItems(tx *sql.Tx, transferID string) ([]models.StockTransferItem, error) {
+	rows, err := tx.Query("SELECT id, product_id, COALESCE(variant_id::text, ''), quantity FROM stock_transfer_items WHERE transfer_id=$1", transferID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.StockTransferItem
+	for rows.Next() {
+		var i models.StockTransferItem
+		if err := rows.Scan(&i.ID, &i.ProductID, &i.VariantID, &i.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+// branchUsable answers the request itself when the current user cannot work in branchID
+func branchUsable(c *gin.Context, branchID string) bool {
+	found, active, allowed, err := services.BranchAccess(c.GetString("tenantID"), c.GetString("userID"), c.GetString("role"), branchID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return false
+	}
+	if !found || !active {
+		c.JSON(400, gin.H{"code": "BRANCH_NOT_FOUND", "error": "Branch not found or inactive"})
+		return false
+	}
+	if !allowed {
+		c.JSON(403, gin.H{"code": "BRANCH_ACCESS_DENIED", "error": "You are not assigned to this branch"})
+		return false
+	}
+	return true
+}
+
+// receiveTransferLots books received goods into the destination's copies of the
+// lots they were dispatched from, earliest expiry first. Goods that left outside
+// any lot (or lines dispatched before lots were carried) arrive outside any lot.
+func receiveTransferLots(tx *sql.Tx, tenantID, itemID string, m stockMove) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(lot_number, ''), COALESCE(to_char(expiry_date, 'YYYY-MM-DD'), ''), quantity - quantity_received
+        FROM stock_transfer_item_lots WHERE transfer_item_id=$1 AND quantity > quantity_received
+        ORDER BY expiry_date NULLS LAST, id FOR UPDATE`, itemID)
+	if err != nil {
+		return err
+	}
+	type sentLot struct {
+		id, number, expiry string
+		open               float64
+	}
+	var sent []sentLot
+	for rows.Next() {
+		var l sentLot
+		rows.Scan(&l.id, &l.number, &l.expiry, &l.open)
+		sent = append(sent, l)
+	}
+	rows.Close()
+
+	left := m.QtyChange
+	for _, l := range sent {
+		if left <= 0 {
+			break
+		}
+		take := l.open
+		if take > left {
+			take = left
+		}
+		part := m
+		part.QtyChange = take
+		if l.number != "" {
+			lotID, err := receiptLot(tx, tenantID, m.ProductID, m.VariantID, m.BranchID, l.number, l.expiry)
+			if err != nil {
+				return err
+			}
+			part.LotID = lotID
+		}
+		if err := updateStockHelper(tx, tenantID, part); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE stock_transfer_item_lots SET quantity_received = quantity_received + $1 WHERE id=$2", take, l.id); err != nil {
+			return err
+		}
+		left = roundQty(left - take)
+	}
+	if left > 0 {
+		m.QtyChange = left
+		return updateStockHelper(tx, tenantID, m)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// Units of Measure
+func ListUnits(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	rows, err := db.DB.Query("SELECT id, name, short_name, allow_decimals, created_at FROM units WHERE tenant_id=$1 ORDER BY name", tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	units := []models.Unit{}
+	for rows.Next() {
+		var u models.Unit
+		rows.Scan(&u.ID, &u.Name, &u.ShortName, &u.AllowDecimals, &u.CreatedAt)
+		units = append(units, u)
+	}
+	c.JSON(200, units)
+}
+
+func CreateUnit(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.UnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var id string
+	err := db.DB.QueryRow("INSERT INTO units (tenant_id, name, short_name, allow_decimals) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant_id, short_name) DO NOTHING RETURNING id",
+		tenantID, req.Name, strings.TrimSpace(req.ShortName), req.AllowDecimals).Scan(&id)
+	if err == sql.ErrNoRows {
+		c.JSON(409, gin.H{"error": "A unit with this short name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, gin.H{"id": id})
+}
+
+// UpdateUnit renames a unit or changes whether it takes decimals. Stock already
+// held is kept; the rule applies to moves from now on.
+func UpdateUnit(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	var req models.UnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.DB.Exec("UPDATE units SET name=$1, short_name=$2, allow_decimals=$3 WHERE id=$4 AND tenant_id=$5",
+		req.Name, strings.TrimSpace(req.ShortName), req.AllowDecimals, c.Param("id"), tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Unit not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+func unitBelongsToTenant(tenantID, unitID string) bool {
+	if unitID == "" {
+		return true
+	}
+	var exists bool
+	db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM units WHERE id::text=$1 AND tenant_id=$2)", unitID, tenantID).Scan(&exists)
+	return exists
+}
+
+// checkProductUnits validates the units of a product; returns the problem, or ""
+func checkProductUnits(tenantID string, p *models.Product) string {
+	if p.PurchaseUnitFactor == 0 {
+		p.PurchaseUnitFactor = 1
+	}
+	if p.SaleUnitFactor == 0 {
+		p.SaleUnitFactor = 1
+	}
+	if p.PurchaseUnitFactor < 0 || p.SaleUnitFactor < 0 {
+		return "Unit factors must be positive"
+	}
+	if p.TrackSerials && p.SaleUnitFactor != 1 {
+		return "Serialized products are sold by the piece"
+	}
+	for _, id := range []string{p.UnitID, p.PurchaseUnitID, p.SaleUnitID} {
+		if !unitBelongsToTenant(tenantID, id) {
+			return "Unit not found"
+		}
+	}
+	return ""
+}
+
+// lineUnit resolves the unit a purchase or sale line is entered in and how many
+// stock units one of it holds. An empty unitID takes the product's purchase or
+// sale unit (use "purchase" or "sale"); otherwise it must be one of the product's units.
+func lineUnit(tx *sql.Tx, tenantID, productID, unitID, use string) (string, float64, error) {
+	var stockUnit, purchaseUnit, saleUnit string
+	var purchaseFactor, saleFactor float64
+	err := tx.QueryRow(`SELECT COALESCE(unit_id::text, ''), COALESCE(purchase_unit_id::text, ''), purchase_unit_factor,
+            COALESCE(sale_unit_id::text, ''), sale_unit_factor
+        FROM products WHERE id=$1 AND tenant_id=$2`, productID, tenantID).
+		Scan(&stockUnit, &purchaseUnit, &purchaseFactor, &saleUnit, &saleFactor)
+	if err != nil {
+		return "", 0, &productNotFoundError{ProductID: productID}
+	}
+
+	switch {
+	case unitID == "" && use == "purchase" && purchaseUnit != "":
+		return purchaseUnit, purchaseFactor, nil
+	case unitID == "" && use == "sale" && saleUnit != "":
+		return saleUnit, saleFactor, nil
+	case unitID == "" || unitID == stockUnit:
+		return stockUnit, 1, nil
+	case unitID == purchaseUnit:
+		return purchaseUnit, purchaseFactor, nil
+	case unitID == saleUnit:
+		return saleUnit, saleFactor, nil
+	}
+	return "", 0, &validationError{fmt.Sprintf("Unit %s is not a unit of product %s", unitID, productID)}
+}
+
+// checkStockUnit rejects stock moves of kits, which hold no stock of their own,
+// and fractional quantities of a product whose stock unit only takes whole
+// numbers (pieces, unless the unit allows decimals)
+func checkStockUnit(tx *sql.Tx, tenantID, productID string, qty float64) error {
+	var productType, unit string
+	var allowDecimals bool
+	err := tx.QueryRow(`SELECT p.product_type, COALESCE(u.allow_decimals, false), COALESCE(u.short_name, 'pieces')
+        FROM products p LEFT JOIN units u ON u.id = p.unit_id WHERE p.id=$1 AND p.tenant_id=$2`, productID, tenantID).
+		Scan(&productType, &allowDecimals, &unit)
+	if err != nil {
+		return &productNotFoundError{ProductID: productID}
+	}
+	if productType == "kit" {
+		return &validationError{fmt.Sprintf("Product %s is a kit; its stock is held by its components", productID)}
+	}
+	if !isWholeQty(qty) && !allowDecimals {
+		return &validationError{fmt.Sprintf("Product %s is stocked in %s, which take whole quantities only (got %g)", productID, unit, qty)}
+	}
+	return nil
+}
+
+func isWholeQty(qty float64) bool {
+	return qty == math.Trunc(qty)
+}
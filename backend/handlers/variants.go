@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// ListProductVariants returns all variants of a product with their stock
+// (all branches, or the one given by ?branch_id)
+func ListProductVariants(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	branchID := c.Query("branch_id")
+
+	var price float64
+	if err := db.DB.QueryRow("SELECT price FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&price); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT v.id, v.product_id, v.name, COALESCE(v.sku, ''), COALESCE(v.barcode, ''), v.price_override, COALESCE(v.is_active, true), COALESCE(SUM(i.quantity), 0)
+        FROM product_variants v LEFT JOIN inventory_stock i ON i.variant_id = v.id AND (NULLIF($3, '') IS NULL OR i.branch_id = NULLIF($3, '')::uuid)
+        WHERE v.product_id=$1 AND v.tenant_id=$2
+        GROUP BY v.id ORDER BY v.name`, productID, tenantID, branchID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var variants []models.ProductVariant
+	for rows.Next() {
+		var v models.ProductVariant
+		var override sql.NullFloat64
+		rows.Scan(&v.ID, &v.ProductID, &v.Name, &v.Sku, &v.Barcode, &override, &v.IsActive, &v.StockQuantity)
+		setVariantPrice(&v, override, price)
+		variants = append(variants, v)
+	}
+	if variants == nil {
+		variants = []models.ProductVariant{}
+	}
+	c.JSON(200, variants)
+}
+
+func CreateProductVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	var req models.ProductVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Tx failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var productType string
+	if err := tx.QueryRow("SELECT product_type FROM products WHERE id=$1 AND tenant_id=$2", productID, tenantID).Scan(&productType); err != nil {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if productType == "kit" {
+		c.JSON(400, gin.H{"error": "Kits cannot have variants"})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	var id string
+	err = tx.QueryRow(`INSERT INTO product_variants (tenant_id, product_id, name, sku, barcode, price_override, is_active)
+        VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7) RETURNING id`,
+		tenantID, productID, req.Name, req.Sku, req.Barcode, req.PriceOverride, isActive).Scan(&id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initial stock 0 at the default branch
+	_, err = tx.Exec("INSERT INTO inventory_stock (tenant_id, product_id, variant_id, branch_id, quantity) SELECT $1, $2, $3, id, 0 FROM branches WHERE tenant_id=$1 AND is_default",
+		tenantID, productID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx.Commit()
+	c.JSON(201, gin.H{"id": id})
+}
+
+func UpdateProductVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	variantID := c.Param("variantId")
+	var req models.ProductVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	res, err := db.DB.Exec(`UPDATE product_variants SET name=$1, sku=NULLIF($2, ''), barcode=NULLIF($3, ''), price_override=$4, is_active=$5, updated_at=now()
+        WHERE id=$6 AND product_id=$7 AND tenant_id=$8`,
+		req.Name, req.Sku, req.Barcode, req.PriceOverride, isActive, variantID, productID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Variant not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Updated"})
+}
+
+// DeleteProductVariant retires a variant. The row is kept so stock history and
+// past sale lines still resolve; it just stops being sellable.
+func DeleteProductVariant(c *gin.Context) {
+	tenantID := c.GetString("tenantID")
+	productID := c.Param("id")
+	variantID := c.Param("variantId")
+
+	res, err := db.DB.Exec("UPDATE product_variants SET is_active=false, updated_at=now() WHERE id=$1 AND product_id=$2 AND tenant_id=$3",
+		variantID, productID, tenantID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(404, gin.H{"error": "Variant not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Variant deactivated"})
+}
+
+func setVariantPrice(v *models.ProductVariant, override sql.NullFloat64, productPrice float64) {
+	v.EffectivePrice = productPrice
+	if override.Valid {
+		o := override.Float64
+		v.PriceOverride = &o
+		v.EffectivePrice = o
+	}
+}
+
+// checkStockItem validates the product/variant pair a document line refers to.
+// Products with variants must name one; retired variants are only accepted when
+// allowInactive (e.g. returning an item of a discontinued size).
+func checkStockItem(tx *sql.Tx, tenantID, productID, variantID string, allowInactive bool) error {
+	var hasVariants bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND COALESCE(v.is_active, true))
+        FROM products p WHERE p.id=$1 AND p.tenant_id=$2`, productID, tenantID).Scan(&hasVariants)
+	if err != nil {
+		return &productNotFoundError{ProductID: productID}
+	}
+
+	if variantID == "" {
+		if hasVariants {
+			return &validationError{fmt.Sprintf("Product %s has variants; variant_id is required", productID)}
+		}
+		return nil
+	}
+
+	var active bool
+	err = tx.QueryRow("SELECT COALESCE(is_active, true) FROM product_variants WHERE id=$1 AND product_id=$2 AND tenant_id=$3",
+		variantID, productID, tenantID).Scan(&active)
+	if err != nil {
+		return &validationError{fmt.Sprintf("Variant %s not found for product %s", variantID, productID)}
+	}
+	if !active && !allowInactive {
+		return &validationError{fmt.Sprintf("Variant %s is no longer active", variantID)}
+	}
+	return nil
+}
@@ -26,6 +26,8 @@ func main() {
 	// Start background workers
 	go workers.StartSubscriptionWorker()
 	go workers.StartDeletionWorker()
+	go workers.StartReorderWorker()
+	go workers.StartLoyaltyWorker()
 
 	r := gin.Default()
 
@@ -39,7 +41,7 @@ func main() {
 	config.AllowOrigins = []string{"http://localhost:3000"}
 	config.AllowCredentials = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-CSRF-Token", "X-Request-ID"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-CSRF-Token", "X-Request-ID", "X-Branch-ID", "X-Device-Token"}
 	r.Use(cors.New(config))
 
 	// Static Serving
@@ -93,37 +95,164 @@ func main() {
 			pos := tenantRoutes.Group("/pos")
 			pos.Use(middleware.EnsureOnboarding())
 			pos.Use(middleware.RequireRole("owner", "manager", "cashier"))
+			// Enrollment issues the device token: registered before POSDevice so it doesn't need one
+			pos.POST("/devices/enroll", middleware.RequireRole("owner", "manager"), handlers.EnrollPOSDevice)
+			pos.Use(middleware.POSDevice())
+			pos.Use(middleware.BranchContext())
 			{
 				pos.GET("/ping", func(c *gin.Context) { c.JSON(200, gin.H{"message": "POS Ready"}) })
+				pos.GET("/branches", handlers.ListMyBranches)
 				pos.GET("/products", handlers.GetPOSProducts)
+				pos.GET("/catalog", handlers.GetPOSCatalog)
+				pos.GET("/catalog/changes", handlers.GetPOSCatalogChanges)
+				pos.GET("/products/:id/serials", handlers.ListAvailableSerials)
 				pos.POST("/sales", handlers.CreateSale)
+				pos.GET("/customers", handlers.ListCustomers)
+				pos.POST("/customers", handlers.CreateCustomer)
+				pos.GET("/customers/:id/points", middleware.RequirePlanFeature("reward_points"), handlers.GetCustomerPoints)
+				pos.GET("/quotations/:id", middleware.RequirePlanFeature("quotations"), handlers.GetQuotation)
+				pos.POST("/quotations/:id/convert", middleware.RequirePlanFeature("quotations"), handlers.ConvertQuotation)
 				pos.GET("/sales/:id", handlers.GetSale)
 				pos.POST("/offline-sync/sales", handlers.SyncOfflineSale)
+				pos.POST("/offline-sync/batch", handlers.SyncOfflineSalesBatch)
+				pos.GET("/stock-counts", handlers.ListOpenStockCounts)
+				pos.GET("/stock-counts/:id/sheet", handlers.GetStockCountSheet)
+				pos.POST("/stock-counts/:id/entries", handlers.SubmitStockCount)
 			}
 
 			// PHASE 5: INVENTORY & OPS
 			ops := tenantRoutes.Group("/")
 			ops.Use(middleware.EnsureOnboarding())
 			ops.Use(middleware.RequireRole("owner", "manager"))
+			ops.Use(middleware.BranchContext())
 			{
+				// Branches
+				ops.GET("/branches", handlers.ListBranches)
+				ops.POST("/branches", middleware.RequireRole("owner"), handlers.CreateBranch)
+				ops.PUT("/branches/:id", middleware.RequireRole("owner"), handlers.UpdateBranch)
+				ops.GET("/users/:id/branches", middleware.RequireRole("owner"), handlers.GetUserBranches)
+				ops.PUT("/users/:id/branches", middleware.RequireRole("owner"), handlers.SetUserBranches)
+
+				// POS Devices
+				ops.GET("/devices", middleware.RequireRole("owner"), handlers.ListPOSDevices)
+				ops.PUT("/devices/:id", middleware.RequireRole("owner"), handlers.UpdatePOSDevice)
+				ops.POST("/devices/:id/revoke", middleware.RequireRole("owner"), handlers.RevokePOSDevice)
+
 				// Products (Management)
 				ops.GET("/products", handlers.ListProducts)
 				ops.POST("/products", handlers.CreateProduct)
 				ops.GET("/products/:id", handlers.GetProduct)
 				ops.PUT("/products/:id", handlers.UpdateProduct)
+				ops.GET("/products/:id/variants", handlers.ListProductVariants)
+				ops.POST("/products/:id/variants", handlers.CreateProductVariant)
+				ops.PUT("/products/:id/variants/:variantId", handlers.UpdateProductVariant)
+				ops.DELETE("/products/:id/variants/:variantId", handlers.DeleteProductVariant)
+				ops.GET("/products/:id/reorder-levels", handlers.ListProductReorderLevels)
+				ops.PUT("/products/:id/reorder-levels/:branchId", handlers.SetProductReorderLevel)
+				ops.DELETE("/products/:id/reorder-levels/:branchId", handlers.DeleteProductReorderLevel)
+				ops.GET("/products/:id/components", handlers.ListKitComponents)
+				ops.PUT("/products/:id/components", handlers.SetKitComponents)
+
+				// Units of measure
+				ops.GET("/units", handlers.ListUnits)
+				ops.POST("/units", handlers.CreateUnit)
+				ops.PUT("/units/:id", handlers.UpdateUnit)
 
 				// Inventory
 				ops.GET("/inventory/ledger", handlers.GetStockLedger)
 				ops.POST("/inventory/adjustments", handlers.CreateAdjustment)
+				ops.GET("/inventory/lots", handlers.ListLots)
+				ops.GET("/inventory/serials", handlers.LookupSerial)
+				ops.POST("/inventory/lots/:id/write-off", handlers.WriteOffLot)
+
+				// Stock Counts
+				ops.GET("/stock-counts", handlers.ListStockCounts)
+				ops.POST("/stock-counts", handlers.CreateStockCount)
+				ops.GET("/stock-counts/:id", handlers.GetStockCount)
+				ops.POST("/stock-counts/:id/reconcile", handlers.ReconcileStockCount)
+				ops.POST("/stock-counts/:id/approve", handlers.ApproveStockCount)
+				ops.POST("/stock-counts/:id/cancel", handlers.CancelStockCount)
+
+				// Stock Transfers
+				transfers := ops.Group("/")
+				transfers.Use(middleware.RequirePlanFeature("stock_transfer"))
+				{
+					transfers.GET("/transfers", handlers.ListTransfers)
+					transfers.POST("/transfers", handlers.CreateTransfer)
+					transfers.GET("/transfers/:id", handlers.GetTransfer)
+					transfers.POST("/transfers/:id/dispatch", handlers.DispatchTransfer)
+					transfers.POST("/transfers/:id/receive", handlers.ReceiveTransfer)
+					transfers.POST("/transfers/:id/cancel", handlers.CancelTransfer)
+					transfers.GET("/inventory/in-transit", handlers.GetInTransitStock)
+				}
+
+				// Manufacturing
+				manufacturing := ops.Group("/manufacturing")
+				manufacturing.Use(middleware.RequirePlanFeature("manufacturing"))
+				{
+					manufacturing.GET("/boms", handlers.ListBoms)
+					manufacturing.POST("/boms", handlers.CreateBom)
+					manufacturing.GET("/boms/:id", handlers.GetBom)
+					manufacturing.PUT("/boms/:id", handlers.UpdateBom)
+					manufacturing.GET("/orders", handlers.ListProductionOrders)
+					manufacturing.POST("/orders", handlers.CreateProductionOrder)
+					manufacturing.GET("/orders/:id", handlers.GetProductionOrder)
+					manufacturing.POST("/orders/:id/complete", handlers.CompleteProductionOrder)
+					manufacturing.POST("/orders/:id/cancel", handlers.CancelProductionOrder)
+				}
+
+				// Customers
+				ops.GET("/customers", handlers.ListCustomers)
+				ops.POST("/customers", handlers.CreateCustomer)
+				ops.GET("/customers/:id", handlers.GetCustomer)
+				ops.PUT("/customers/:id", handlers.UpdateCustomer)
+				ops.DELETE("/customers/:id", handlers.DeleteCustomer)
+				ops.GET("/customers/:id/sales", handlers.GetCustomerSales)
+
+				// Offline sync conflicts
+				ops.GET("/offline-sync/conflicts", handlers.ListSyncConflicts)
+				ops.GET("/offline-sync/conflicts/:id", handlers.GetSyncConflict)
+				ops.POST("/offline-sync/conflicts/:id/resolve", handlers.ResolveSyncConflict)
+
+				// Loyalty
+				loyalty := ops.Group("/")
+				loyalty.Use(middleware.RequirePlanFeature("reward_points"))
+				{
+					loyalty.GET("/settings/loyalty", handlers.GetLoyaltySettings)
+					loyalty.PUT("/settings/loyalty", handlers.UpdateLoyaltySettings)
+					loyalty.GET("/customers/:id/points", handlers.GetCustomerPoints)
+					loyalty.POST("/customers/:id/points/adjust", handlers.AdjustCustomerPoints)
+				}
+
+				// Quotations
+				quotations := ops.Group("/quotations")
+				quotations.Use(middleware.RequirePlanFeature("quotations"))
+				{
+					quotations.GET("", handlers.ListQuotations)
+					quotations.POST("", handlers.CreateQuotation)
+					quotations.GET("/:id", handlers.GetQuotation)
+					quotations.PUT("/:id", handlers.UpdateQuotation)
+					quotations.GET("/:id/export", handlers.ExportQuotation)
+					quotations.POST("/:id/send", handlers.SendQuotation)
+					quotations.POST("/:id/accept", handlers.AcceptQuotation)
+					quotations.POST("/:id/convert", handlers.ConvertQuotation)
+				}
 
 				// Suppliers
 				ops.GET("/suppliers", handlers.ListSuppliers)
 				ops.POST("/suppliers", handlers.CreateSupplier)
+				ops.GET("/suppliers/:id/balance", handlers.GetSupplierBalance)
 
 				// Purchases
 				ops.GET("/purchases", handlers.ListPurchases)
 				ops.POST("/purchases", handlers.CreatePurchase)
+				ops.GET("/purchases/suggestions", handlers.ListPurchaseSuggestions)
+				ops.POST("/purchases/suggestions/generate", handlers.GeneratePurchaseSuggestions)
+				ops.POST("/purchases/suggestions/:id/confirm", handlers.ConfirmPurchaseSuggestion)
+				ops.POST("/purchases/suggestions/:id/dismiss", handlers.DismissPurchaseSuggestion)
+				ops.GET("/purchases/:id", handlers.GetPurchase)
 				ops.PUT("/purchases/:id/receive", handlers.ReceivePurchase)
+				ops.GET("/purchases/:id/receipts", handlers.ListPurchaseReceipts)
 
 				// Returns
 				ops.POST("/returns/sales", handlers.CreateSaleReturn)
@@ -132,6 +261,26 @@ func main() {
 				// Reports
 				ops.GET("/reports/daily-sales", handlers.GetDailySalesReport)
 				ops.GET("/reports/stock-alerts", handlers.GetStockAlerts)
+				ops.GET("/reports/expiring-lots", handlers.GetExpiringLots)
+				ops.GET("/reports/inventory-valuation", handlers.GetInventoryValuation)
+
+				// Settings
+				ops.GET("/settings/invoicing", handlers.GetInvoiceSettings)
+				ops.PUT("/settings/invoicing", handlers.UpdateInvoiceSettings)
+				ops.GET("/settings/tax", handlers.GetTaxSettings)
+				ops.PUT("/settings/tax", handlers.UpdateTaxSettings)
+				ops.GET("/settings/costing", handlers.GetCostingSettings)
+				ops.PUT("/settings/costing", handlers.UpdateCostingSettings)
+				ops.GET("/settings/offline-sync", handlers.GetOfflineSyncSettings)
+				ops.PUT("/settings/offline-sync", handlers.UpdateOfflineSyncSettings)
+
+				// Taxes
+				ops.GET("/taxes/rates", handlers.ListTaxRates)
+				ops.POST("/taxes/rates", handlers.CreateTaxRate)
+				ops.PUT("/taxes/rates/:id", handlers.UpdateTaxRate)
+				ops.GET("/taxes/classes", handlers.ListTaxClasses)
+				ops.POST("/taxes/classes", handlers.CreateTaxClass)
+				ops.PUT("/taxes/classes/:id", handlers.UpdateTaxClass)
 			}
 		}
 
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// BranchContext resolves the branch the request works in and stores it as "branchID".
+// The X-Branch-ID header selects a branch, else the POS device's branch (POSDevice);
+// without either the user's primary branch is used, falling back to the tenant's
+// default branch. Access rules: services.BranchAccess.
+func BranchContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID := c.GetString("tenantID")
+		userID := c.GetString("userID")
+		requested := c.GetHeader("X-Branch-ID")
+		if requested == "" {
+			requested = c.GetString("deviceBranchID")
+		}
+
+		var branchID string
+		if requested != "" {
+			found, active, allowed, err := services.BranchAccess(tenantID, userID, c.GetString("role"), requested)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve branch"})
+				c.Abort()
+				return
+			}
+			if !found || !active {
+				c.JSON(http.StatusNotFound, gin.H{"code": "BRANCH_NOT_FOUND", "error": "Branch not found or inactive"})
+				c.Abort()
+				return
+			}
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"code": "BRANCH_ACCESS_DENIED", "error": "You are not assigned to this branch"})
+				c.Abort()
+				return
+			}
+			branchID = requested
+		} else {
+			err := db.DB.QueryRow(`SELECT b.id FROM user_branches ub JOIN branches b ON b.id = ub.branch_id
+                WHERE ub.user_id = $1 AND b.tenant_id = $2 AND b.is_active
+                ORDER BY ub.is_primary DESC, b.created_at LIMIT 1`, userID, tenantID).Scan(&branchID)
+			if err == sql.ErrNoRows {
+				err = db.DB.QueryRow("SELECT id FROM branches WHERE tenant_id = $1 AND is_default", tenantID).Scan(&branchID)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"code": "BRANCH_NOT_FOUND", "error": "No branch available for this user"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("branchID", branchID)
+		c.Next()
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// RequirePlanFeature blocks routes whose feature (plan_features column name) is
+// not part of the tenant's current plan
+func RequirePlanFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, features, err := services.GetTenantEntitlements(c.GetString("tenantID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load plan features"})
+			c.Abort()
+			return
+		}
+
+		if !features.Enabled(feature) {
+			c.JSON(http.StatusForbidden, gin.H{"code": "PLAN_FEATURE_REQUIRED", "feature": feature, "error": "Your plan does not include this feature"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/utils"
+)
+
+// POSDevice requires the X-Device-Token header of a device enrolled by the tenant
+// and stores the device as "deviceID"; its branch, if it has one, is stored as
+// "deviceBranchID" for BranchContext. Marks the device active (at most once a minute).
+func POSDevice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Device-Token")
+		if token == "" {
+			c.JSON(http.StatusForbidden, gin.H{"code": "DEVICE_NOT_REGISTERED", "error": "This device is not enrolled for POS use"})
+			c.Abort()
+			return
+		}
+
+		var deviceID, branchID string
+		err := db.DB.QueryRow(`SELECT id, COALESCE(branch_id::text, '') FROM pos_devices
+            WHERE token_hash = $1 AND tenant_id = $2 AND status = 'active'`,
+			utils.HashDeviceToken(token), c.GetString("tenantID")).Scan(&deviceID, &branchID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusForbidden, gin.H{"code": "DEVICE_NOT_REGISTERED", "error": "Device token is invalid or the device was revoked"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify device"})
+			c.Abort()
+			return
+		}
+
+		db.DB.Exec(`UPDATE pos_devices SET last_active_at = now()
+            WHERE id = $1 AND (last_active_at IS NULL OR last_active_at < now() - interval '1 minute')`, deviceID)
+
+		c.Set("deviceID", deviceID)
+		c.Set("deviceBranchID", branchID)
+		c.Next()
+	}
+}
@@ -14,43 +14,133 @@ type Product struct {
 	Description   string  `json:"description"`
 	Sku           string  `json:"sku"`
 	Barcode       string  `json:"barcode"`
+	Category      string  `json:"category"`
 	Price         float64 `json:"price"`
 	CostPrice     float64 `json:"cost_price"`
-	StockQuantity int     `json:"stock_quantity"`
+	StockQuantity float64 `json:"stock_quantity"`
 	IsActive      bool    `json:"is_active"`
+	TaxClassID    string  `json:"tax_class_id"`
+	TrackLots     bool    `json:"track_lots"`    // Lot number (and expiry) captured on receipt
+	TrackSerials  bool    `json:"track_serials"` // Every unit carries a serial number
+	ProductType   string  `json:"product_type"`  // standard, or kit: sold as a bundle of other products
+	// Reorder levels (nil = not managed); branches may override them
+	ReorderLevel        *float64 `json:"reorder_level"` // Reorder when stock plus stock on order is at or below this
+	MaxLevel            *float64 `json:"max_level"`     // Order up to this
+	ReorderQty          *float64 `json:"reorder_qty"`   // Fixed order quantity, takes precedence over max_level
+	PreferredSupplierID string   `json:"preferred_supplier_id"`
+	// Units: stock is held in UnitID (empty = pieces); purchases and sales default
+	// to their own unit holding factor stock units, e.g. a case of 24
+	UnitID             string  `json:"unit_id"`
+	Unit               string  `json:"unit,omitempty"` // Short name of the stock unit
+	AllowDecimals      bool    `json:"allow_decimals"` // Stock unit takes fractional quantities
+	PurchaseUnitID     string  `json:"purchase_unit_id"`
+	PurchaseUnitFactor float64 `json:"purchase_unit_factor"`
+	SaleUnitID         string  `json:"sale_unit_id"`
+	SaleUnitFactor     float64 `json:"sale_unit_factor"`
+	// Kits: what one kit is made of
+	Components []KitComponent `json:"components,omitempty"`
+	// POS only: sellable variants, and the one whose barcode matched the search
+	Variants         []ProductVariant `json:"variants,omitempty"`
+	MatchedVariantID string           `json:"matched_variant_id,omitempty"`
+}
+
+type ProductVariant struct {
+	ID             string   `json:"id"`
+	ProductID      string   `json:"product_id"`
+	Name           string   `json:"name"`
+	Sku            string   `json:"sku"`
+	Barcode        string   `json:"barcode"`
+	PriceOverride  *float64 `json:"price_override"` // Null = product price
+	EffectivePrice float64  `json:"effective_price"`
+	StockQuantity  float64  `json:"stock_quantity"`
+	IsActive       bool     `json:"is_active"`
+}
+
+type ProductVariantRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Sku           string   `json:"sku"`
+	Barcode       string   `json:"barcode"`
+	PriceOverride *float64 `json:"price_override" binding:"omitempty,min=0"`
+	IsActive      *bool    `json:"is_active"`
 }
 
 type SaleItemRequest struct {
-	ProductID string  `json:"product_id" binding:"required"`
-	VariantID string  `json:"variant_id"`
-	Quantity  int     `json:"quantity" binding:"required,min=1"`
-	UnitPrice float64 `json:"unit_price"`
+	ProductID string   `json:"product_id" binding:"required"`
+	VariantID string   `json:"variant_id"`
+	Quantity  float64  `json:"quantity" binding:"required,gt=0"` // In UnitID
+	UnitID    string   `json:"unit_id"`                          // Empty = the product's sale unit
+	UnitPrice float64  `json:"unit_price"`
+	Serials   []string `json:"serials"` // One per unit, required for serialized products
+}
+
+// SalePaymentRequest is one tender on a sale. A sale may carry several
+// (part cash, part card, ...); PaymentMethod/PaymentReceived remain as the single-tender shorthand.
+type SalePaymentRequest struct {
+	Method    string  `json:"method" binding:"required"`
+	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	Reference string  `json:"reference"`
 }
 
 type CreateSaleRequest struct {
-	Items           []SaleItemRequest `json:"items" binding:"required,min=1"`
-	DiscountAmount  float64           `json:"discount_amount"`
-	PaymentMethod   string            `json:"payment_method" binding:"required"`
-	PaymentReceived float64           `json:"payment_received"`
+	Items           []SaleItemRequest    `json:"items" binding:"required,min=1,dive"`
+	DiscountAmount  float64              `json:"discount_amount"`
+	PaymentMethod   string               `json:"payment_method"`
+	PaymentReceived float64              `json:"payment_received"`
+	Payments        []SalePaymentRequest `json:"payments" binding:"omitempty,dive"`
+	CustomerID      string               `json:"customer_id"` // Optional
 }
 
 type Sale struct {
-	ID             string     `json:"id"`
-	InvoiceNumber  string     `json:"invoice_number"`
-	TotalAmount    float64    `json:"total_amount"`
-	DiscountAmount float64    `json:"discount_amount"`
-	FinalAmount    float64    `json:"final_amount"`
-	PaymentMethod  string     `json:"payment_method"`
-	CreatedAt      time.Time  `json:"created_at"`
-	Items          []SaleItem `json:"items,omitempty"`
+	ID              string    `json:"id"`
+	BranchID        string    `json:"branch_id"`
+	CustomerID      string    `json:"customer_id,omitempty"`
+	CustomerName    string    `json:"customer_name,omitempty"`
+	InvoiceNumber   string    `json:"invoice_number"`
+	TotalAmount     float64   `json:"total_amount"`
+	DiscountAmount  float64   `json:"discount_amount"`
+	TaxAmount       float64   `json:"tax_amount"`
+	FinalAmount     float64   `json:"final_amount"`
+	PaymentMethod   string    `json:"payment_method"` // single method, or "split"
+	Status          string    `json:"status"`         // completed, partially_refunded, refunded
+	PaymentReceived float64   `json:"payment_received"`
+	ChangeDue       float64   `json:"change_due"`
+	CreatedAt       time.Time `json:"created_at"` // Recorded by the server
+	SoldAt          time.Time `json:"sold_at"`    // Made (offline sales: by the corrected device clock)
+	// Offline sales: the device that made the sale and the time by its clock
+	DeviceID           string        `json:"device_id,omitempty"`
+	DeviceCreatedAt    *time.Time    `json:"device_created_at,omitempty"`
+	DeviceTimeRejected bool          `json:"device_time_rejected,omitempty"`
+	CashierID          string        `json:"cashier_id,omitempty"`
+	SyncedBy           string        `json:"synced_by,omitempty"`
+	Items              []SaleItem    `json:"items,omitempty"`
+	Payments           []SalePayment `json:"payments,omitempty"`
+	TaxBreakdown       []TaxLine     `json:"tax_breakdown,omitempty"`
+}
+
+type SalePayment struct {
+	Method    string  `json:"method"`
+	Amount    float64 `json:"amount"`   // Applied to the sale (cash net of change)
+	Tendered  float64 `json:"tendered"` // Handed over by the customer
+	Reference string  `json:"reference,omitempty"`
 }
 
 type SaleItem struct {
-	ProductID   string  `json:"product_id"`
-	ProductName string  `json:"product_name"`
-	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-	TotalPrice  float64 `json:"total_price"`
+	ID             string    `json:"id"`
+	ProductID      string    `json:"product_id"`
+	VariantID      string    `json:"variant_id,omitempty"`
+	ProductName    string    `json:"product_name"`
+	Quantity       float64   `json:"quantity"`
+	UnitID         string    `json:"unit_id,omitempty"`
+	Unit           string    `json:"unit,omitempty"`
+	UnitFactor     float64   `json:"unit_factor"` // Stock units per unit sold
+	UnitPrice      float64   `json:"unit_price"`
+	TotalPrice     float64   `json:"total_price"`
+	DiscountAmount float64   `json:"discount_amount"`
+	NetAmount      float64   `json:"net_amount"`
+	TaxAmount      float64   `json:"tax_amount"`
+	Taxes          []TaxLine `json:"taxes,omitempty"`
+	ReturnedQty    float64   `json:"returned_quantity"`
+	Serials        []string  `json:"serials,omitempty"` // Units sold on the line
 }
 
 // --- Phase 5 Extended Models ---
@@ -73,68 +163,198 @@ type CreateSupplierRequest struct {
 type Purchase struct {
 	ID           string    `json:"id"`
 	SupplierID   string    `json:"supplier_id"`
+	BranchID     string    `json:"branch_id"` // Branch receiving the goods
 	ReferenceNo  string    `json:"reference_no"`
+	Subtotal     float64   `json:"subtotal"`
+	TaxTotal     float64   `json:"tax_total"`
 	GrandTotal   float64   `json:"grand_total"`
-	Status       string    `json:"status"` // draft, received
+	Status       string    `json:"status"` // draft, partially_received, received, cancelled
 	Notes        string    `json:"notes"`
 	CreatedAt    time.Time `json:"created_at"`
 	ReceivedAt   time.Time `json:"received_at,omitempty"`
+	IsSuggested  bool      `json:"is_suggested"` // Raised from reorder levels, awaiting confirmation
 	SupplierName string    `json:"supplier_name,omitempty"`
+
+	Items    []PurchaseItem `json:"items,omitempty"`
+	Receipts []GoodsReceipt `json:"receipts,omitempty"`
+}
+
+type PurchaseItem struct {
+	ID               string  `json:"id"`
+	ProductID        string  `json:"product_id"`
+	VariantID        string  `json:"variant_id,omitempty"`
+	Name             string  `json:"name"`
+	UnitID           string  `json:"unit_id,omitempty"`
+	Unit             string  `json:"unit,omitempty"`
+	UnitFactor       float64 `json:"unit_factor"` // Stock units per unit ordered
+	CostPrice        float64 `json:"cost_price"`  // Per unit ordered
+	Quantity         float64 `json:"quantity"`    // Quantities in the unit ordered
+	QuantityReceived float64 `json:"quantity_received"`
+	Outstanding      float64 `json:"outstanding"` // Ordered, not yet received
+	LineTotal        float64 `json:"line_total"`
 }
 
 type PurchaseItemRequest struct {
 	ProductID string  `json:"product_id" binding:"required"`
-	Quantity  int     `json:"quantity" binding:"required,min=1"`
-	CostPrice float64 `json:"cost_price" binding:"required"`
+	VariantID string  `json:"variant_id"`
+	Quantity  float64 `json:"quantity" binding:"required,gt=0"` // In UnitID
+	UnitID    string  `json:"unit_id"`                          // Empty = the product's purchase unit
+	CostPrice float64 `json:"cost_price" binding:"min=0"`       // Per UnitID
+	// Lot received, when the purchase is created as received
+	LotNumber  string   `json:"lot_number"`
+	ExpiryDate string   `json:"expiry_date"` // YYYY-MM-DD
+	Serials    []string `json:"serials"`
 }
 
 type CreatePurchaseRequest struct {
-	SupplierID  string                `json:"supplier_id"`
-	ReferenceNo string                `json:"reference_no" binding:"required"`
-	Status      string                `json:"status"` // draft or received
-	Notes       string                `json:"notes"`
-	Items       []PurchaseItemRequest `json:"items" binding:"required,min=1"`
+	SupplierID  string `json:"supplier_id"`
+	ReferenceNo string `json:"reference_no" binding:"required"`
+	Status      string `json:"status"` // draft or received
+	Notes       string `json:"notes"`
+	// PricesIncludeTax: supplier cost prices already contain tax
+	PricesIncludeTax bool                  `json:"prices_include_tax"`
+	Items            []PurchaseItemRequest `json:"items" binding:"required,min=1,dive"`
 	// Simple totals calculation expected from backend usually, but can accept from FE or calc
 }
 
+// GoodsReceiptRequest books one delivery against a purchase. Without items,
+// everything still outstanding is received.
+type GoodsReceiptRequest struct {
+	Items []GoodsReceiptItemRequest `json:"items" binding:"dive"`
+	Notes string                    `json:"notes"`
+}
+
+type GoodsReceiptItemRequest struct {
+	PurchaseItemID string   `json:"purchase_item_id" binding:"required"`
+	Quantity       float64  `json:"quantity" binding:"required,gt=0"` // In the unit ordered
+	LotNumber      string   `json:"lot_number"`                       // Required for lot-tracked products
+	ExpiryDate     string   `json:"expiry_date"`                      // YYYY-MM-DD
+	Serials        []string `json:"serials"`                          // One per unit, required for serialized products
+}
+
+// GoodsReceipt (GRN) is one delivery received against a purchase
+type GoodsReceipt struct {
+	ID            string             `json:"id"`
+	PurchaseID    string             `json:"purchase_id"`
+	BranchID      string             `json:"branch_id"`
+	ReceiptNumber string             `json:"receipt_number"`
+	Notes         string             `json:"notes"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Items         []GoodsReceiptItem `json:"items"`
+}
+
+type GoodsReceiptItem struct {
+	PurchaseItemID string  `json:"purchase_item_id"`
+	ProductID      string  `json:"product_id"`
+	VariantID      string  `json:"variant_id,omitempty"`
+	Name           string  `json:"name"`
+	Quantity       float64 `json:"quantity"`
+	LotID          string  `json:"lot_id,omitempty"`
+	LotNumber      string  `json:"lot_number,omitempty"`
+}
+
 type StockLedger struct {
 	ID          string    `json:"id"`
 	ProductID   string    `json:"product_id"`
+	VariantID   string    `json:"variant_id,omitempty"`
+	BranchID    string    `json:"branch_id"`
 	RefType     string    `json:"ref_type"`
 	RefID       string    `json:"ref_id"`
-	QtyChange   int       `json:"qty_change"`
-	QtyAfter    int       `json:"qty_after"`
+	QtyChange   float64   `json:"qty_change"`
+	QtyAfter    float64   `json:"qty_after"`
 	Note        string    `json:"note"`
+	UnitCost    *float64  `json:"unit_cost"`   // Nil for moves booked before costing
+	CostAmount  *float64  `json:"cost_amount"` // Signed like qty_change
+	LotID       string    `json:"lot_id,omitempty"`
+	LotNumber   string    `json:"lot_number,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	ProductName string    `json:"product_name,omitempty"`
+	VariantName string    `json:"variant_name,omitempty"`
+	BranchName  string    `json:"branch_name,omitempty"`
 }
 
 type AdjustmentRequest struct {
 	Reason string                  `json:"reason" binding:"required"`
 	Notes  string                  `json:"notes"`
-	Items  []AdjustmentItemRequest `json:"items" binding:"required,min=1"`
+	Items  []AdjustmentItemRequest `json:"items" binding:"required,min=1,dive"`
 }
 
 type AdjustmentItemRequest struct {
-	ProductID string `json:"product_id" binding:"required"`
-	QtyChange int    `json:"qty_change" binding:"required"` // Can be negative
+	ProductID string   `json:"product_id" binding:"required"`
+	VariantID string   `json:"variant_id"`
+	QtyChange float64  `json:"qty_change" binding:"required"` // Can be negative
+	LotID     string   `json:"lot_id"`                        // Lot adjusted; negative changes default to FEFO
+	Serials   []string `json:"serials"`                       // Units found or written off; one per unit for serialized products
 }
 
 type SaleReturnRequest struct {
-	SaleID string              `json:"sale_id" binding:"required"`
-	Reason string              `json:"reason"`
-	Items  []ReturnItemRequest `json:"items" binding:"required"`
+	SaleID string                  `json:"sale_id" binding:"required"`
+	Reason string                  `json:"reason"`
+	Items  []SaleReturnItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// SaleReturnItemRequest returns units of a sold product. SaleItemID picks the sale
+// line; without it the quantity is taken from the matching lines in order.
+type SaleReturnItemRequest struct {
+	SaleItemID string   `json:"sale_item_id"`
+	ProductID  string   `json:"product_id" binding:"required"`
+	VariantID  string   `json:"variant_id"`
+	Quantity   float64  `json:"quantity" binding:"required,gt=0"`
+	Restock    *bool    `json:"restock"` // Default true; false writes the units off
+	Serials    []string `json:"serials"` // Units returned, required for serialized products
+}
+
+type SaleReturnItem struct {
+	SaleItemID   string   `json:"sale_item_id"`
+	ProductID    string   `json:"product_id"`
+	VariantID    string   `json:"variant_id,omitempty"`
+	Quantity     float64  `json:"quantity"`
+	RefundAmount float64  `json:"refund_amount"`
+	Restock      bool     `json:"restock"`
+	Serials      []string `json:"serials,omitempty"`
 }
 
 type PurchaseReturnRequest struct {
-	PurchaseID string              `json:"purchase_id" binding:"required"`
-	Reason     string              `json:"reason"`
-	Items      []ReturnItemRequest `json:"items" binding:"required"`
+	PurchaseID string                      `json:"purchase_id" binding:"required"`
+	Reason     string                      `json:"reason"`
+	Items      []PurchaseReturnItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// PurchaseReturnItemRequest sends back received units. PurchaseItemID picks the
+// purchase line; without it the quantity is taken from the matching lines in order.
+type PurchaseReturnItemRequest struct {
+	PurchaseItemID string   `json:"purchase_item_id"`
+	ProductID      string   `json:"product_id" binding:"required"`
+	VariantID      string   `json:"variant_id"`
+	Quantity       float64  `json:"quantity" binding:"required,gt=0"`
+	Serials        []string `json:"serials"` // Units sent back, required for serialized products
+}
+
+type PurchaseReturnItem struct {
+	PurchaseItemID string  `json:"purchase_item_id"`
+	ProductID      string  `json:"product_id"`
+	VariantID      string  `json:"variant_id,omitempty"`
+	Quantity       float64 `json:"quantity"`
+	CostPrice      float64 `json:"cost_price"`
+	RefundAmount   float64 `json:"refund_amount"`
+}
+
+type SupplierCreditNote struct {
+	ID               string    `json:"id"`
+	SupplierID       string    `json:"supplier_id"`
+	PurchaseReturnID string    `json:"purchase_return_id,omitempty"`
+	Amount           float64   `json:"amount"`
+	Note             string    `json:"note"`
+	CreatedAt        time.Time `json:"created_at"`
 }
 
-type ReturnItemRequest struct {
-	ProductID string `json:"product_id" binding:"required"`
-	Quantity  int    `json:"quantity" binding:"required,min=1"`
+// SupplierBalance is what we owe a supplier: purchases less credit notes
+type SupplierBalance struct {
+	SupplierID     string               `json:"supplier_id"`
+	TotalPurchases float64              `json:"total_purchases"`
+	TotalCredits   float64              `json:"total_credits"`
+	BalanceOwed    float64              `json:"balance_owed"`
+	CreditNotes    []SupplierCreditNote `json:"credit_notes"`
 }
 
 // ... Previous Models (Keep them to avoid breaking compilation) ...
@@ -182,6 +402,12 @@ type OnboardingRequest struct {
 	Confirmed            bool   `json:"confirmed" binding:"required"`
 }
 
+type InvoiceSettings struct {
+	InvoicePrefixDefault string `json:"invoice_prefix_default" binding:"required,max=20"`
+	InvoiceResetYearly   bool   `json:"invoice_reset_yearly"`
+	InvoicePerBranch     bool   `json:"invoice_per_branch"` // Separate sequence and prefix per branch
+}
+
 type Plan struct {
 	ID           string       `json:"id"`
 	Code         string       `json:"code"`
@@ -220,6 +446,29 @@ type PlanFeatures struct {
 	OfflinePos         bool `json:"offline_pos"`
 }
 
+// Enabled looks a feature up by its JSON name (as used by RequirePlanFeature)
+func (f PlanFeatures) Enabled(name string) bool {
+	switch name {
+	case "multi_branch":
+		return f.MultiBranch
+	case "stock_transfer":
+		return f.StockTransfer
+	case "advanced_reports":
+		return f.AdvancedReports
+	case "manufacturing":
+		return f.Manufacturing
+	case "reward_points":
+		return f.RewardPoints
+	case "quotations":
+		return f.Quotations
+	case "delivery_management":
+		return f.DeliveryManagement
+	case "offline_pos":
+		return f.OfflinePos
+	}
+	return false
+}
+
 type TenantSubscription struct {
 	Status             string    `json:"status"`
 	CurrentPeriodStart time.Time `json:"current_period_start"`
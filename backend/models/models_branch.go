@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// --- Branch Models ---
+
+type Branch struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Code          string    `json:"code"`
+	Address       string    `json:"address"`
+	Phone         string    `json:"phone"`
+	InvoicePrefix string    `json:"invoice_prefix"`
+	IsDefault     bool      `json:"is_default"`
+	IsActive      bool      `json:"is_active"`
+	IsPrimary     bool      `json:"is_primary,omitempty"` // Only on a user's own branch list
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type BranchRequest struct {
+	Name          string `json:"name" binding:"required"`
+	Code          string `json:"code" binding:"required,max=20"`
+	Address       string `json:"address"`
+	Phone         string `json:"phone"`
+	InvoicePrefix string `json:"invoice_prefix" binding:"max=20"`
+	IsActive      *bool  `json:"is_active"`
+}
+
+// UserBranchesRequest replaces a user's branch assignments
+type UserBranchesRequest struct {
+	BranchIDs       []string `json:"branch_ids" binding:"required"`
+	PrimaryBranchID string   `json:"primary_branch_id"` // Defaults to the first branch
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// --- Inventory Costing Models ---
+
+type CostingSettings struct {
+	CostingMethod string `json:"costing_method" binding:"required,oneof=weighted_average fifo"`
+}
+
+// InventoryValuation is the stock on hand and its cost at a point in time
+type InventoryValuation struct {
+	CostingMethod string          `json:"costing_method"`
+	AsOf          time.Time       `json:"as_of"`
+	TotalQuantity float64         `json:"total_quantity"`
+	TotalValue    float64         `json:"total_value"`
+	Items         []ValuationItem `json:"items"`
+}
+
+type ValuationItem struct {
+	ProductID   string  `json:"product_id"`
+	VariantID   string  `json:"variant_id,omitempty"`
+	BranchID    string  `json:"branch_id"`
+	ProductName string  `json:"product_name"`
+	VariantName string  `json:"variant_name,omitempty"`
+	BranchName  string  `json:"branch_name"`
+	Quantity    float64 `json:"quantity"`
+	UnitCost    float64 `json:"unit_cost"`
+	Value       float64 `json:"value"`
+}
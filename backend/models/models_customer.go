@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// --- Customer Models ---
+
+type Customer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Phone     string    `json:"phone"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+	Tags      []string  `json:"tags"`
+	Notes     string    `json:"notes"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	// Over the customer's sales: lifetime value is what they paid less refunds
+	SalesCount     int        `json:"sales_count"`
+	LifetimeValue  float64    `json:"lifetime_value"`
+	LastPurchaseAt *time.Time `json:"last_purchase_at"`
+	PointsBalance  int        `json:"points_balance"` // Unexpired loyalty points
+}
+
+type CustomerRequest struct {
+	Name     string   `json:"name" binding:"required"`
+	Phone    string   `json:"phone"`
+	Email    string   `json:"email" binding:"omitempty,email"`
+	Address  string   `json:"address"`
+	Tags     []string `json:"tags"`
+	Notes    string   `json:"notes"`
+	IsActive *bool    `json:"is_active"`
+}
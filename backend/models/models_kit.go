@@ -0,0 +1,22 @@
+package models
+
+// --- Kit & Bundle Models ---
+
+// KitComponent is a product (or variant) taken out of stock for every kit sold
+type KitComponent struct {
+	ProductID     string  `json:"product_id"`
+	VariantID     string  `json:"variant_id"`
+	Name          string  `json:"name"`
+	Quantity      float64 `json:"quantity"`                 // Component stock units per kit
+	StockQuantity float64 `json:"stock_quantity,omitempty"` // At the branch asked for
+}
+
+type KitComponentRequest struct {
+	ProductID string  `json:"product_id" binding:"required"`
+	VariantID string  `json:"variant_id"`
+	Quantity  float64 `json:"quantity" binding:"required,gt=0"`
+}
+
+type SetKitComponentsRequest struct {
+	Components []KitComponentRequest `json:"components" binding:"required,min=1,dive"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// --- Lot Models ---
+
+type StockLot struct {
+	ID           string     `json:"id"`
+	ProductID    string     `json:"product_id"`
+	VariantID    string     `json:"variant_id,omitempty"`
+	BranchID     string     `json:"branch_id"`
+	ProductName  string     `json:"product_name"`
+	VariantName  string     `json:"variant_name,omitempty"`
+	BranchName   string     `json:"branch_name"`
+	LotNumber    string     `json:"lot_number"`
+	ExpiryDate   *time.Time `json:"expiry_date"`
+	Quantity     float64    `json:"quantity"`
+	DaysToExpiry int        `json:"days_to_expiry"` // Negative once expired
+	Expired      bool       `json:"expired"`
+	Value        float64    `json:"value"` // At the item's average cost
+}
+
+type WriteOffLotRequest struct {
+	Notes string `json:"notes"`
+}
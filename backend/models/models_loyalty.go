@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// --- Loyalty Models ---
+
+type LoyaltySettings struct {
+	Enabled         bool    `json:"enabled"`
+	PointsPerUnit   float64 `json:"points_per_unit" binding:"min=0"`     // Earned per currency unit paid, before tax
+	PointValue      float64 `json:"point_value" binding:"required,gt=0"` // Currency one point pays
+	MinRedeemPoints int     `json:"min_redeem_points" binding:"min=0"`
+	ExpiryDays      *int    `json:"expiry_days" binding:"omitempty,min=1"` // Nil = points never expire
+	// Earn multipliers by product category, e.g. {"Coffee": 2}
+	CategoryMultipliers map[string]float64 `json:"category_multipliers"`
+}
+
+type LoyaltyPointEntry struct {
+	ID            string     `json:"id"`
+	EntryType     string     `json:"entry_type"` // earn, redeem, reverse, expire, adjust
+	Points        int        `json:"points"`     // Signed
+	Remaining     int        `json:"remaining"`  // Of a positive entry, not yet spent or expired
+	SaleID        string     `json:"sale_id,omitempty"`
+	InvoiceNumber string     `json:"invoice_number,omitempty"`
+	SaleReturnID  string     `json:"sale_return_id,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	Note          string     `json:"note"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CustomerPoints struct {
+	CustomerID   string              `json:"customer_id"`
+	Balance      int                 `json:"balance"`
+	BalanceValue float64             `json:"balance_value"` // What the balance pays at the current point value
+	Entries      []LoyaltyPointEntry `json:"entries"`
+}
+
+// PointsAdjustmentRequest credits (positive) or debits (negative) points by hand
+type PointsAdjustmentRequest struct {
+	Points int    `json:"points" binding:"required"`
+	Note   string `json:"note" binding:"required"`
+}
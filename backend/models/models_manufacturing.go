@@ -0,0 +1,99 @@
+package models
+
+import "time"
+
+// --- Manufacturing Models ---
+
+// Bom is a bill of materials: the ingredients one batch of a finished good takes
+type Bom struct {
+	ID          string    `json:"id"`
+	ProductID   string    `json:"product_id"`
+	VariantID   string    `json:"variant_id,omitempty"`
+	ProductName string    `json:"product_name"`
+	Name        string    `json:"name"`
+	OutputQty   float64   `json:"output_qty"` // Finished stock units per batch
+	IsActive    bool      `json:"is_active"`
+	Notes       string    `json:"notes"`
+	CreatedAt   time.Time `json:"created_at"`
+	Items       []BomItem `json:"items,omitempty"`
+	// Rolled up from the ingredients' current average cost (or cost price)
+	EstimatedCost     float64 `json:"estimated_cost,omitempty"`      // Per batch
+	EstimatedUnitCost float64 `json:"estimated_unit_cost,omitempty"` // Per finished stock unit
+}
+
+type BomItem struct {
+	ID          string  `json:"id"`
+	ProductID   string  `json:"product_id"`
+	VariantID   string  `json:"variant_id,omitempty"`
+	ProductName string  `json:"product_name"`
+	Quantity    float64 `json:"quantity"`  // Ingredient stock units per batch, before waste
+	WastePct    float64 `json:"waste_pct"` // Consumed on top of the quantity
+	GrossQty    float64 `json:"gross_qty"` // Quantity with waste
+	UnitCost    float64 `json:"unit_cost"`
+}
+
+type BomRequest struct {
+	ProductID string           `json:"product_id" binding:"required"`
+	VariantID string           `json:"variant_id"`
+	Name      string           `json:"name" binding:"required"`
+	OutputQty float64          `json:"output_qty" binding:"omitempty,gt=0"` // Default 1
+	IsActive  *bool            `json:"is_active"`
+	Notes     string           `json:"notes"`
+	Items     []BomItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type BomItemRequest struct {
+	ProductID string  `json:"product_id" binding:"required"`
+	VariantID string  `json:"variant_id"`
+	Quantity  float64 `json:"quantity" binding:"required,gt=0"`
+	WastePct  float64 `json:"waste_pct" binding:"min=0,lt=100"`
+}
+
+type ProductionOrder struct {
+	ID          string                `json:"id"`
+	ReferenceNo string                `json:"reference_no"`
+	BranchID    string                `json:"branch_id"`
+	BranchName  string                `json:"branch_name"`
+	BomID       string                `json:"bom_id"`
+	ProductID   string                `json:"product_id"`
+	VariantID   string                `json:"variant_id,omitempty"`
+	ProductName string                `json:"product_name"`
+	Quantity    float64               `json:"quantity"`
+	Status      string                `json:"status"`     // draft, completed, cancelled
+	TotalCost   *float64              `json:"total_cost"` // Set on completion
+	UnitCost    *float64              `json:"unit_cost"`
+	LotNumber   string                `json:"lot_number,omitempty"`
+	Notes       string                `json:"notes"`
+	CreatedAt   time.Time             `json:"created_at"`
+	CompletedAt *time.Time            `json:"completed_at"`
+	Items       []ProductionOrderItem `json:"items,omitempty"`
+}
+
+type ProductionOrderItem struct {
+	ID          string   `json:"id"`
+	ProductID   string   `json:"product_id"`
+	VariantID   string   `json:"variant_id,omitempty"`
+	ProductName string   `json:"product_name"`
+	Quantity    float64  `json:"quantity"`  // Consumed, waste included
+	UnitCost    *float64 `json:"unit_cost"` // Set on completion
+}
+
+// CreateProductionOrderRequest raises an order from a BOM; Complete produces it
+// straight away (taking the lot fields of CompleteProductionOrderRequest)
+type CreateProductionOrderRequest struct {
+	BomID       string  `json:"bom_id" binding:"required"`
+	Quantity    float64 `json:"quantity" binding:"required,gt=0"` // Finished stock units
+	BranchID    string  `json:"branch_id"`                        // Defaults to the current branch
+	ReferenceNo string  `json:"reference_no"`                     // Generated when empty
+	Notes       string  `json:"notes"`
+	Complete    bool    `json:"complete"`
+	LotNumber   string  `json:"lot_number"`
+	ExpiryDate  string  `json:"expiry_date"`
+}
+
+// CompleteProductionOrderRequest names the lot the output goes into, required
+// when the finished good tracks lots
+type CompleteProductionOrderRequest struct {
+	LotNumber  string `json:"lot_number"`
+	ExpiryDate string `json:"expiry_date"` // YYYY-MM-DD
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// --- POS Device Models ---
+
+type PosDevice struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Fingerprint    string     `json:"fingerprint"`
+	BranchID       string     `json:"branch_id,omitempty"`
+	BranchName     string     `json:"branch_name,omitempty"`
+	EnrolledBy     string     `json:"enrolled_by,omitempty"`
+	EnrolledByName string     `json:"enrolled_by_name,omitempty"`
+	UserAgent      string     `json:"user_agent"`
+	Status         string     `json:"status"` // active, revoked
+	LastActiveAt   *time.Time `json:"last_active_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// EnrollDeviceRequest enrolls the calling terminal (once per fingerprint)
+type EnrollDeviceRequest struct {
+	Fingerprint string `json:"fingerprint" binding:"required,max=255"`
+	Name        string `json:"name" binding:"required,max=100"`
+	BranchID    string `json:"branch_id" binding:"omitempty,uuid"` // Default branch of the terminal, optional
+}
+
+type UpdateDeviceRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	BranchID string `json:"branch_id" binding:"omitempty,uuid"`
+}
@@ -5,10 +5,79 @@ import "time"
 // ... Existing models ...
 
 type OfflineSyncSaleRequest struct {
-	LocalSaleID     string            `json:"local_sale_id" binding:"required"`
-	Items           []SaleItemRequest `json:"items" binding:"required,min=1"`
-	DiscountAmount  float64           `json:"discount_amount"`
-	PaymentMethod   string            `json:"payment_method" binding:"required"`
-	PaymentReceived float64           `json:"payment_received"`
-	CreatedAt       time.Time         `json:"created_at"` // Client time
+	LocalSaleID     string               `json:"local_sale_id" binding:"required,uuid"`
+	Items           []SaleItemRequest    `json:"items" binding:"required,min=1,dive"`
+	DiscountAmount  float64              `json:"discount_amount"`
+	PaymentMethod   string               `json:"payment_method"`
+	PaymentReceived float64              `json:"payment_received"`
+	Payments        []SalePaymentRequest `json:"payments" binding:"omitempty,dive"`
+	CreatedAt       time.Time            `json:"created_at"`  // Client time
+	CustomerID      string               `json:"customer_id"` // Optional
+	// Where the sale was made: the POS device (set from the device token; a value
+	// sent is ignored) and the cashier who rang it up (default: the user syncing)
+	DeviceID  string `json:"device_id" binding:"omitempty,uuid"`
+	CashierID string `json:"cashier_id" binding:"omitempty,uuid"`
+	// Device clock when the sale was sent; with created_at it corrects for a wrong device clock
+	SentAt time.Time `json:"sent_at"`
+}
+
+// OfflineSyncBatchRequest carries a terminal's queued sales, oldest first; they
+// are posted in that order
+type OfflineSyncBatchRequest struct {
+	Sales []OfflineSyncSaleRequest `json:"sales" binding:"required,min=1,max=500"`
+	// Device clock when the batch was sent, for sales without their own sent_at
+	SentAt time.Time `json:"sent_at"`
+}
+
+// OfflineSyncResult is the outcome of one sale of a batch, at its index
+type OfflineSyncResult struct {
+	Index       int    `json:"index"`
+	LocalSaleID string `json:"local_sale_id"`
+	// synced, already_synced, held (short of stock, queued for a manager), voided (held, then voided),
+	// conflict (stock count in progress, retry later), rejected (invalid), error (retry later)
+	Status        string `json:"status"`
+	SaleID        string `json:"sale_id,omitempty"`
+	InvoiceNumber string `json:"invoice_number,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Error details, e.g. the product_id of a stock conflict
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// OfflineSyncConflict is an offline sale held at sync time for being short of stock
+type OfflineSyncConflict struct {
+	ID             string                  `json:"id"`
+	BranchID       string                  `json:"branch_id"`
+	BranchName     string                  `json:"branch_name"`
+	LocalSaleID    string                  `json:"local_sale_id"`
+	Sale           *OfflineSyncSaleRequest `json:"sale,omitempty"` // As the terminal sent it
+	Reason         string                  `json:"reason"`
+	ProductID      string                  `json:"product_id,omitempty"`
+	VariantID      string                  `json:"variant_id,omitempty"`
+	Available      *float64                `json:"available"`
+	Requested      *float64                `json:"requested"`
+	Status         string                  `json:"status"`     // open, resolved
+	Resolution     string                  `json:"resolution"` // negative_stock, adjustment, void
+	SaleID         string                  `json:"sale_id,omitempty"`
+	InvoiceNumber  string                  `json:"invoice_number,omitempty"`
+	RefundAmount   *float64                `json:"refund_amount"`
+	RefundMethod   string                  `json:"refund_method,omitempty"`
+	ResolutionNote string                  `json:"resolution_note"`
+	SyncedBy       string                  `json:"synced_by"`
+	ResolvedBy     string                  `json:"resolved_by"`
+	ResolvedAt     *time.Time              `json:"resolved_at"`
+	CreatedAt      time.Time               `json:"created_at"`
+}
+
+// ResolveSyncConflictRequest settles a held offline sale. negative_stock posts it
+// taking stock below zero; adjustment first books the missing stock in, then posts
+// it; void posts nothing and records the tender refunded to the customer.
+type ResolveSyncConflictRequest struct {
+	Action       string   `json:"action" binding:"required,oneof=negative_stock adjustment void"`
+	Note         string   `json:"note"`
+	RefundMethod string   `json:"refund_method"` // void: how the money went back, default the sale's first tender
+	RefundAmount *float64 `json:"refund_amount"` // void: default what the customer paid
+}
+
+type OfflineSyncSettings struct {
+	StockConflicts string `json:"stock_conflicts" binding:"required,oneof=hold allow_negative"`
 }
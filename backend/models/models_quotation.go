@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// --- Quotation Models ---
+
+type Quotation struct {
+	ID             string          `json:"id"`
+	ReferenceNo    string          `json:"reference_no"`
+	BranchID       string          `json:"branch_id,omitempty"`
+	CustomerID     string          `json:"customer_id,omitempty"`
+	CustomerName   string          `json:"customer_name,omitempty"`
+	Status         string          `json:"status"`      // draft, sent, accepted, expired (open past valid_until), converted
+	ValidUntil     string          `json:"valid_until"` // YYYY-MM-DD, last day the prices hold
+	TotalAmount    float64         `json:"total_amount"`
+	DiscountAmount float64         `json:"discount_amount"`
+	TaxAmount      float64         `json:"tax_amount"`
+	FinalAmount    float64         `json:"final_amount"`
+	Notes          string          `json:"notes"`
+	SaleID         string          `json:"sale_id,omitempty"` // Set on conversion
+	InvoiceNumber  string          `json:"invoice_number,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ConvertedAt    *time.Time      `json:"converted_at"`
+	Items          []QuotationItem `json:"items,omitempty"`
+	TaxBreakdown   []TaxLine       `json:"tax_breakdown,omitempty"`
+}
+
+type QuotationItem struct {
+	ID             string    `json:"id"`
+	ProductID      string    `json:"product_id"`
+	VariantID      string    `json:"variant_id,omitempty"`
+	ProductName    string    `json:"product_name"`
+	Quantity       float64   `json:"quantity"`
+	UnitID         string    `json:"unit_id,omitempty"`
+	Unit           string    `json:"unit,omitempty"`
+	UnitFactor     float64   `json:"unit_factor"`
+	UnitPrice      float64   `json:"unit_price"` // Locked at quote time
+	TotalPrice     float64   `json:"total_price"`
+	DiscountAmount float64   `json:"discount_amount"`
+	NetAmount      float64   `json:"net_amount"`
+	TaxAmount      float64   `json:"tax_amount"`
+	Taxes          []TaxLine `json:"taxes,omitempty"`
+}
+
+// QuotationRequest creates or (while draft or sent) replaces a quotation. Lines
+// are priced from the product list when saved; item unit_price is ignored.
+type QuotationRequest struct {
+	CustomerID     string            `json:"customer_id"`
+	ValidUntil     string            `json:"valid_until"` // YYYY-MM-DD, default 30 days out
+	DiscountAmount float64           `json:"discount_amount"`
+	Notes          string            `json:"notes"`
+	ReferenceNo    string            `json:"reference_no"` // Generated when empty (create only)
+	Items          []SaleItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// ConvertQuotationRequest pays for a quotation turned into a sale. Serials are
+// keyed by quotation item id, for serialized products.
+type ConvertQuotationRequest struct {
+	PaymentMethod   string               `json:"payment_method"`
+	PaymentReceived float64              `json:"payment_received"`
+	Payments        []SalePaymentRequest `json:"payments" binding:"omitempty,dive"`
+	Serials         map[string][]string  `json:"serials"`
+}
@@ -0,0 +1,34 @@
+package models
+
+// --- Reorder Models ---
+
+// BranchReorderLevel overrides a product's reorder levels at one branch
+type BranchReorderLevel struct {
+	ProductID    string   `json:"product_id"`
+	BranchID     string   `json:"branch_id"`
+	BranchName   string   `json:"branch_name"`
+	ReorderLevel float64  `json:"reorder_level"`
+	MaxLevel     *float64 `json:"max_level"`
+	ReorderQty   *float64 `json:"reorder_qty"`
+}
+
+type SetReorderLevelRequest struct {
+	ReorderLevel *float64 `json:"reorder_level" binding:"required,min=0"`
+	MaxLevel     *float64 `json:"max_level" binding:"omitempty,gt=0"`
+	ReorderQty   *float64 `json:"reorder_qty" binding:"omitempty,gt=0"`
+}
+
+// ConfirmSuggestionRequest turns a suggested purchase into a regular draft.
+// Lines not listed keep their quantity and cost; quantity 0 drops a line.
+type ConfirmSuggestionRequest struct {
+	SupplierID       string                  `json:"supplier_id"` // Empty = keep the suggested supplier
+	PricesIncludeTax bool                    `json:"prices_include_tax"`
+	Notes            string                  `json:"notes"`
+	Items            []ConfirmSuggestionItem `json:"items" binding:"dive"`
+}
+
+type ConfirmSuggestionItem struct {
+	PurchaseItemID string   `json:"purchase_item_id" binding:"required"`
+	Quantity       *float64 `json:"quantity" binding:"omitempty,min=0"`
+	CostPrice      *float64 `json:"cost_price" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// --- Serial Number Models ---
+
+type ProductSerial struct {
+	ID           string        `json:"id"`
+	ProductID    string        `json:"product_id"`
+	VariantID    string        `json:"variant_id,omitempty"`
+	ProductName  string        `json:"product_name"`
+	VariantName  string        `json:"variant_name,omitempty"`
+	BranchID     string        `json:"branch_id"`
+	BranchName   string        `json:"branch_name"`
+	SerialNumber string        `json:"serial_number"`
+	Status       string        `json:"status"` // in_stock, sold, returned, returned_to_supplier, written_off
+	CreatedAt    time.Time     `json:"created_at"`
+	Events       []SerialEvent `json:"events,omitempty"`
+}
+
+// SerialEvent is one step in a unit's history
+type SerialEvent struct {
+	EventType  string    `json:"event_type"` // purchase, sale, sale_return, purchase_return, adjustment
+	RefID      string    `json:"ref_id"`
+	RefNo      string    `json:"ref_no"` // Receipt or invoice number, where there is one
+	BranchID   string    `json:"branch_id"`
+	BranchName string    `json:"branch_name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import "time"
+
+// --- Stock Count Models ---
+
+type StockCount struct {
+	ID           string           `json:"id"`
+	BranchID     string           `json:"branch_id"`
+	BranchName   string           `json:"branch_name"`
+	ReferenceNo  string           `json:"reference_no"`
+	Status       string           `json:"status"` // open, approved, cancelled
+	FreezeStock  bool             `json:"freeze_stock"`
+	Notes        string           `json:"notes"`
+	AdjustmentID string           `json:"adjustment_id,omitempty"`
+	ItemCount    int              `json:"item_count"`
+	CountedItems int              `json:"counted_items"`
+	CreatedAt    time.Time        `json:"created_at"`
+	ApprovedAt   *time.Time       `json:"approved_at"`
+	Lines        []StockCountLine `json:"lines,omitempty"`
+}
+
+// StockCountLine is one item of the variance report
+type StockCountLine struct {
+	ItemID        string   `json:"item_id"`
+	ProductID     string   `json:"product_id"`
+	VariantID     string   `json:"variant_id,omitempty"`
+	ProductName   string   `json:"product_name"`
+	VariantName   string   `json:"variant_name,omitempty"`
+	ExpectedQty   float64  `json:"expected_qty"`   // Snapshot when the count was opened
+	MovedQty      float64  `json:"moved_qty"`      // Sold, received, ... since the snapshot
+	CountedQty    *float64 `json:"counted_qty"`    // Nil until counted, or while counters disagree unreconciled
+	Counters      int      `json:"counters"`       // Staff who submitted a count
+	Disagreement  bool     `json:"disagreement"`   // Counters submitted different quantities
+	ReconciledQty *float64 `json:"reconciled_qty"` // The manager's quantity where counters disagree
+	Variance      *float64 `json:"variance"`       // Counted less expected
+	VarianceValue float64  `json:"variance_value"` // At the item's average cost
+
+	Entries []StockCountEntry `json:"entries"` // Each counter's submission
+}
+
+// StockCountEntry is one staff member's count of an item
+type StockCountEntry struct {
+	UserID     string    `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	CountedQty float64   `json:"counted_qty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// StockCountSheetLine is what counting staff see: no expected quantity
+type StockCountSheetLine struct {
+	ItemID      string   `json:"item_id"`
+	ProductID   string   `json:"product_id"`
+	VariantID   string   `json:"variant_id,omitempty"`
+	ProductName string   `json:"product_name"`
+	VariantName string   `json:"variant_name,omitempty"`
+	Sku         string   `json:"sku"`
+	Barcode     string   `json:"barcode"`
+	MyCount     *float64 `json:"my_count"` // This user's last submission
+}
+
+// CreateStockCountRequest opens a count at the current branch for every active
+// product, or only ProductIDs. FreezeStock blocks sales and other stock movements
+// of the counted items until the count is approved or cancelled.
+type CreateStockCountRequest struct {
+	ProductIDs  []string `json:"product_ids"`
+	FreezeStock bool     `json:"freeze_stock"`
+	Notes       string   `json:"notes"`
+}
+
+type SubmitStockCountRequest struct {
+	Items []StockCountEntryRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type StockCountEntryRequest struct {
+	ItemID     string  `json:"item_id" binding:"required"`
+	CountedQty float64 `json:"counted_qty" binding:"min=0"`
+}
+
+// ReconcileStockCountRequest sets the quantity to book for items whose counters disagree
+type ReconcileStockCountRequest struct {
+	Items []StockCountEntryRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// ApproveStockCountRequest: ZeroUncounted books items nobody counted as 0;
+// otherwise they are left unchanged. Items whose counters disagree must be
+// reconciled first.
+type ApproveStockCountRequest struct {
+	ZeroUncounted bool `json:"zero_uncounted"`
+}
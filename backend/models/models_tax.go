@@ -0,0 +1,45 @@
+package models
+
+// --- Tax Engine Models ---
+
+type TaxRate struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Rate       float64 `json:"rate"` // Percent
+	IsCompound bool    `json:"is_compound"`
+	IsActive   bool    `json:"is_active"`
+}
+
+type TaxRateRequest struct {
+	Name       string  `json:"name" binding:"required"`
+	Rate       float64 `json:"rate" binding:"min=0,max=100"`
+	IsCompound bool    `json:"is_compound"`
+	IsActive   *bool   `json:"is_active"`
+}
+
+type TaxClass struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rates       []TaxRate `json:"rates"` // In application order
+}
+
+type TaxClassRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	RateIDs     []string `json:"rate_ids"` // In application order
+}
+
+type TaxSettings struct {
+	PricesIncludeTax bool `json:"prices_include_tax"`
+}
+
+// TaxLine is one tax charged on a line (or, aggregated, on a whole document)
+type TaxLine struct {
+	TaxRateID     string  `json:"tax_rate_id"`
+	Name          string  `json:"name"`
+	Rate          float64 `json:"rate"`
+	IsCompound    bool    `json:"is_compound"`
+	TaxableAmount float64 `json:"taxable_amount"`
+	TaxAmount     float64 `json:"tax_amount"`
+}
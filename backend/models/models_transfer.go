@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// --- Stock Transfer Models ---
+
+type StockTransfer struct {
+	ID             string              `json:"id"`
+	ReferenceNo    string              `json:"reference_no"`
+	FromBranchID   string              `json:"from_branch_id"`
+	FromBranchName string              `json:"from_branch_name"`
+	ToBranchID     string              `json:"to_branch_id"`
+	ToBranchName   string              `json:"to_branch_name"`
+	Status         string              `json:"status"` // draft, dispatched, partially_received, received, cancelled
+	Notes          string              `json:"notes"`
+	CreatedAt      time.Time           `json:"created_at"`
+	DispatchedAt   *time.Time          `json:"dispatched_at"`
+	ReceivedAt     *time.Time          `json:"received_at"`
+	Items          []StockTransferItem `json:"items,omitempty"`
+}
+
+type StockTransferItem struct {
+	ID               string  `json:"id"`
+	ProductID        string  `json:"product_id"`
+	VariantID        string  `json:"variant_id,omitempty"`
+	ProductName      string  `json:"product_name"`
+	VariantName      string  `json:"variant_name,omitempty"`
+	Quantity         float64 `json:"quantity"`
+	QuantityReceived float64 `json:"quantity_received"`
+	QuantityShort    float64 `json:"quantity_short"`
+	InTransit        float64 `json:"in_transit"` // Dispatched, not yet received or written off
+	DiscrepancyNote  string  `json:"discrepancy_note,omitempty"`
+}
+
+type CreateTransferRequest struct {
+	ReferenceNo  string                `json:"reference_no"`   // Generated when empty
+	FromBranchID string                `json:"from_branch_id"` // Defaults to the current branch
+	ToBranchID   string                `json:"to_branch_id" binding:"required"`
+	Notes        string                `json:"notes"`
+	Items        []TransferItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type TransferItemRequest struct {
+	ProductID string  `json:"product_id" binding:"required"`
+	VariantID string  `json:"variant_id"`
+	Quantity  float64 `json:"quantity" binding:"required,gt=0"`
+}
+
+// ReceiveTransferRequest books a (partial) receipt. Close ends the transfer and
+// records whatever is still in transit as short.
+type ReceiveTransferRequest struct {
+	Items []ReceiveTransferItemRequest `json:"items" binding:"dive"`
+	Close bool                         `json:"close"`
+}
+
+type ReceiveTransferItemRequest struct {
+	ItemID   string  `json:"item_id" binding:"required"`
+	Quantity float64 `json:"quantity" binding:"min=0"`
+	Note     string  `json:"note"` // Discrepancy note, e.g. "2 broken"
+}
+
+type InTransitStock struct {
+	TransferID   string  `json:"transfer_id"`
+	ReferenceNo  string  `json:"reference_no"`
+	FromBranchID string  `json:"from_branch_id"`
+	ToBranchID   string  `json:"to_branch_id"`
+	ProductID    string  `json:"product_id"`
+	VariantID    string  `json:"variant_id,omitempty"`
+	ProductName  string  `json:"product_name"`
+	VariantName  string  `json:"variant_name,omitempty"`
+	Quantity     float64 `json:"quantity"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// --- Unit of Measure Models ---
+
+type Unit struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	ShortName     string    `json:"short_name"`
+	AllowDecimals bool      `json:"allow_decimals"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type UnitRequest struct {
+	Name          string `json:"name" binding:"required"`
+	ShortName     string `json:"short_name" binding:"required"`
+	AllowDecimals bool   `json:"allow_decimals"`
+}
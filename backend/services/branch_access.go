@@ -0,0 +1,26 @@
+package services
+
+import (
+	"database/sql"
+
+	"github.com/insaansher/sherpos/backend/db"
+)
+
+// BranchAccess reports whether a tenant branch exists and is active, and whether
+// the user may work in it. Owners may use every branch; other users the branches
+// assigned to them, or only the default branch while they have no assignments.
+func BranchAccess(tenantID, userID, role, branchID string) (found, active, allowed bool, err error) {
+	err = db.DB.QueryRow(`SELECT b.is_active,
+            $3 = 'owner'
+            OR EXISTS(SELECT 1 FROM user_branches ub WHERE ub.user_id = $4 AND ub.branch_id = b.id)
+            OR (b.is_default AND NOT EXISTS(SELECT 1 FROM user_branches ub WHERE ub.user_id = $4))
+        FROM branches b WHERE b.id::text = $1 AND b.tenant_id = $2`,
+		branchID, tenantID, role, userID).Scan(&active, &allowed)
+	if err == sql.ErrNoRows {
+		return false, false, false, nil
+	}
+	if err != nil {
+		return false, false, false, err
+	}
+	return true, active, allowed, nil
+}
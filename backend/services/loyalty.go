@@ -0,0 +1,37 @@
+package services
+
+import (
+	"log"
+
+	"github.com/insaansher/sherpos/backend/db"
+)
+
+// ExpireLoyaltyPoints writes off what is left of points past their expiry date,
+// one 'expire' ledger entry per customer. Returns the number of customers affected.
+func ExpireLoyaltyPoints() (int, error) {
+	res, err := db.DB.Exec(`WITH expired AS (
+            UPDATE loyalty_point_entries e SET remaining = 0
+            FROM (SELECT id, remaining FROM loyalty_point_entries WHERE remaining > 0 AND expires_at <= now() FOR UPDATE) old
+            WHERE e.id = old.id
+            RETURNING e.tenant_id, e.customer_id, old.remaining
+        )
+        INSERT INTO loyalty_point_entries (tenant_id, customer_id, entry_type, points, note)
+        SELECT tenant_id, customer_id, 'expire', -SUM(remaining), 'Points expired' FROM expired GROUP BY tenant_id, customer_id`)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// ProcessAllLoyaltyExpiry runs the expiry job across all tenants
+func ProcessAllLoyaltyExpiry() error {
+	n, err := ExpireLoyaltyPoints()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Expired loyalty points for %d customers", n)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"database/sql"
+
+	"github.com/insaansher/sherpos/backend/db"
+	"github.com/insaansher/sherpos/backend/models"
+)
+
+// DefaultPlanLimits and DefaultPlanFeatures mirror the plan_limits / plan_features
+// column defaults and apply to tenants that have not chosen a plan yet (trial).
+var (
+	DefaultPlanLimits   = models.PlanLimits{BranchLimit: 1, UserLimit: 1, PosDeviceLimit: 1}
+	DefaultPlanFeatures = models.PlanFeatures{OfflinePos: true}
+)
+
+// GetTenantEntitlements returns the limits and features of the tenant's current plan
+func GetTenantEntitlements(tenantID string) (models.PlanLimits, models.PlanFeatures, error) {
+	limits := DefaultPlanLimits
+	features := DefaultPlanFeatures
+
+	err := db.DB.QueryRow(`
+		SELECT COALESCE(l.branch_limit, $2), COALESCE(l.user_limit, $3), COALESCE(l.pos_device_limit, $4),
+		       COALESCE(f.multi_branch, false), COALESCE(f.stock_transfer, false), COALESCE(f.advanced_reports, false),
+		       COALESCE(f.manufacturing, false), COALESCE(f.reward_points, false), COALESCE(f.quotations, false),
+		       COALESCE(f.delivery_management, false), COALESCE(f.offline_pos, true)
+		FROM tenant_subscriptions ts
+		LEFT JOIN plan_limits l ON l.plan_id = ts.plan_id
+		LEFT JOIN plan_features f ON f.plan_id = ts.plan_id
+		WHERE ts.tenant_id = $1
+	`, tenantID, limits.BranchLimit, limits.UserLimit, limits.PosDeviceLimit).Scan(
+		&limits.BranchLimit, &limits.UserLimit, &limits.PosDeviceLimit,
+		&features.MultiBranch, &features.StockTransfer, &features.AdvancedReports,
+		&features.Manufacturing, &features.RewardPoints, &features.Quotations,
+		&features.DeliveryManagement, &features.OfflinePos,
+	)
+	if err == sql.ErrNoRows {
+		return DefaultPlanLimits, DefaultPlanFeatures, nil
+	}
+	if err != nil {
+		return limits, features, err
+	}
+	return limits, features, nil
+}
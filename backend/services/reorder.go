@@ -0,0 +1,199 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/insaansher/sherpos/backend/db"
+)
+
+// reorderLine is one stock item to order at a branch
+type reorderLine struct {
+	productID string
+	variantID string
+	name      string
+	costPrice float64
+	qty       float64
+}
+
+// reorderQty is how much to order of an item whose stock position (on hand plus
+// on order) is at or below its reorder level: the fixed reorder quantity, else
+// up to the max level, else back up to the reorder level itself
+func reorderQty(position, reorderLevel float64, maxLevel, fixedQty sql.NullFloat64) float64 {
+	if position > reorderLevel {
+		return 0
+	}
+	if fixedQty.Valid && fixedQty.Float64 > 0 {
+		return fixedQty.Float64
+	}
+	if maxLevel.Valid {
+		return roundQty(maxLevel.Float64 - position)
+	}
+	return roundQty(reorderLevel - position)
+}
+
+// GenerateReorderSuggestions raises suggested draft purchases for stock at or
+// below its reorder level, one per branch and preferred supplier. Quantities
+// outstanding on open purchases (earlier suggestions included) count as stock,
+// so running it again does not order the same shortfall twice, and items of a
+// dismissed suggestion are left out until their stock moves again. Lines are
+// priced without tax until the suggestion is confirmed. Returns the purchases created.
+func GenerateReorderSuggestions(tenantID string) ([]string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// One run per tenant at a time; also serialises the reference numbers
+	if _, err := tx.Exec("SELECT id FROM tenants WHERE id=$1 FOR UPDATE", tenantID); err != nil {
+		return nil, err
+	}
+
+	// Branch levels override the product's; products with variants are stocked per variant.
+	// Everything is in stock units: open lines count at their unit factor and
+	// suggestions are raised in the stock unit.
+	rows, err := tx.Query(`WITH levels AS (
+            SELECT p.id AS product_id, b.id AS branch_id, p.name, p.cost_price, p.preferred_supplier_id,
+                   COALESCE(r.reorder_level, p.reorder_level) AS reorder_level,
+                   CASE WHEN r.product_id IS NULL THEN p.max_level ELSE r.max_level END AS max_level,
+                   CASE WHEN r.product_id IS NULL THEN p.reorder_qty ELSE r.reorder_qty END AS reorder_qty
+            FROM products p
+            JOIN branches b ON b.tenant_id = p.tenant_id AND b.is_active
+            LEFT JOIN product_reorder_levels r ON r.product_id = p.id AND r.branch_id = b.id
+            WHERE p.tenant_id = $1 AND p.is_active AND p.product_type = 'standard'
+        )
+        SELECT l.product_id, COALESCE(v.id::text, ''), l.branch_id, COALESCE(l.preferred_supplier_id::text, ''),
+               l.name, COALESCE(NULLIF(l.cost_price, 0), s.avg_cost, 0), l.reorder_level, l.max_level, l.reorder_qty,
+               COALESCE(s.quantity, 0),
+               COALESCE((SELECT sum((pi.quantity - pi.quantity_received) * pi.unit_factor) FROM purchase_items pi JOIN purchases pu ON pu.id = pi.purchase_id
+                   WHERE pu.tenant_id = $1 AND pu.branch_id = l.branch_id AND pu.status IN ('draft', 'partially_received')
+                     AND pi.product_id = l.product_id AND pi.variant_id IS NOT DISTINCT FROM v.id), 0)
+        FROM levels l
+        LEFT JOIN product_variants v ON v.product_id = l.product_id AND COALESCE(v.is_active, true)
+        LEFT JOIN inventory_stock s ON s.product_id = l.product_id AND s.branch_id = l.branch_id AND s.variant_id IS NOT DISTINCT FROM v.id
+        WHERE l.reorder_level IS NOT NULL
+          AND NOT EXISTS(SELECT 1 FROM purchase_items pi JOIN purchases pu ON pu.id = pi.purchase_id
+              WHERE pu.tenant_id = $1 AND pu.branch_id = l.branch_id AND pu.dismissed_at IS NOT NULL
+                AND pi.product_id = l.product_id AND pi.variant_id IS NOT DISTINCT FROM v.id
+                AND pu.dismissed_at > COALESCE((SELECT max(g.created_at) FROM stock_ledger g
+                    WHERE g.tenant_id = $1 AND g.branch_id = l.branch_id AND g.product_id = l.product_id
+                      AND g.variant_id IS NOT DISTINCT FROM v.id), '-infinity'))
+        ORDER BY l.branch_id, l.preferred_supplier_id, l.name`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	type orderKey struct{ branchID, supplierID string }
+	var keys []orderKey
+	orders := map[orderKey][]reorderLine{}
+	for rows.Next() {
+		var l reorderLine
+		var k orderKey
+		var level, onHand, onOrder float64
+		var maxLevel, fixedQty sql.NullFloat64
+		if err := rows.Scan(&l.productID, &l.variantID, &k.branchID, &k.supplierID, &l.name, &l.costPrice,
+			&level, &maxLevel, &fixedQty, &onHand, &onOrder); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if l.qty = reorderQty(onHand+onOrder, level, maxLevel, fixedQty); l.qty <= 0 {
+			continue
+		}
+		if _, ok := orders[k]; !ok {
+			keys = append(keys, k)
+		}
+		orders[k] = append(orders[k], l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Number on from the highest AUTO- reference, so one typed by hand in the
+	// same pattern is never issued again
+	var last int64
+	err = tx.QueryRow(`SELECT COALESCE(MAX(SUBSTRING(reference_no FROM 6)::bigint), 0) FROM purchases
+        WHERE tenant_id=$1 AND reference_no ~ '^AUTO-[0-9]{1,18}$'`, tenantID).Scan(&last)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []string{}
+	for _, k := range keys {
+		lines := orders[k]
+		var subtotal float64
+		for i := range lines {
+			subtotal += roundMoney(lines[i].costPrice * lines[i].qty)
+		}
+		subtotal = roundMoney(subtotal)
+
+		last++
+		var purchaseID string
+		err := tx.QueryRow(`INSERT INTO purchases (tenant_id, branch_id, supplier_id, reference_no, subtotal, tax_total, grand_total, status, notes, prices_include_tax, is_suggested)
+            VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, 0, $5, 'draft', 'Suggested from reorder levels', false, true) RETURNING id`,
+			tenantID, k.branchID, k.supplierID, fmt.Sprintf("AUTO-%06d", last), subtotal).Scan(&purchaseID)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lines {
+			lineTotal := roundMoney(l.costPrice * l.qty)
+			_, err := tx.Exec(`INSERT INTO purchase_items (purchase_id, product_id, variant_id, name_snapshot, cost_price, quantity, line_total, net_amount, tax_amount, tax_details)
+                VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7, $7, 0, '[]')`,
+				purchaseID, l.productID, l.variantID, l.name, l.costPrice, l.qty, lineTotal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		created = append(created, purchaseID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ProcessAllReorderSuggestions runs the reorder job for every tenant that manages
+// reorder levels, skipping read-only and blocked subscriptions
+func ProcessAllReorderSuggestions() error {
+	rows, err := db.DB.Query(`SELECT t.id FROM tenants t
+        WHERE NOT EXISTS(SELECT 1 FROM tenant_subscriptions ts WHERE ts.tenant_id = t.id AND ts.status IN ('read_only', 'blocked'))
+          AND (EXISTS(SELECT 1 FROM products p WHERE p.tenant_id = t.id AND p.reorder_level IS NOT NULL)
+               OR EXISTS(SELECT 1 FROM product_reorder_levels r WHERE r.tenant_id = t.id))`)
+	if err != nil {
+		return err
+	}
+	var tenantIDs []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		tenantIDs = append(tenantIDs, id)
+	}
+	rows.Close()
+
+	total := 0
+	for _, id := range tenantIDs {
+		created, err := GenerateReorderSuggestions(id)
+		if err != nil {
+			log.Printf("Error generating reorder suggestions for tenant %s: %v", id, err)
+			continue
+		}
+		total += len(created)
+	}
+
+	if total > 0 {
+		log.Printf("Raised %d suggested purchases", total)
+	}
+	return nil
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func roundQty(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestReorderQty(t *testing.T) {
+	none := sql.NullFloat64{}
+	n := func(v float64) sql.NullFloat64 { return sql.NullFloat64{Float64: v, Valid: true} }
+
+	tests := []struct {
+		name     string
+		position float64
+		level    float64
+		maxLevel sql.NullFloat64
+		fixedQty sql.NullFloat64
+		want     float64
+	}{
+		{"above the level", 11, 10, n(50), n(24), 0},
+		{"at the level, up to the max level", 10, 10, n(30), none, 20},
+		{"at the level without a max is already there", 10, 10, none, none, 0},
+		{"below, back up to the level", 4, 10, none, none, 6},
+		{"below, up to the max level", 4, 10, n(50), none, 46},
+		{"fixed quantity wins over max", 4, 10, n(50), n(24), 24},
+		{"zero fixed quantity is ignored", 4, 10, n(50), n(0), 46},
+		{"negative stock", -3, 10, n(20), none, 23},
+		{"fractional stock", 2.5, 5, none, none, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reorderQty(tt.position, tt.level, tt.maxLevel, tt.fixedQty); got != tt.want {
+				t.Errorf("reorderQty(%v, %v, %v, %v) = %v, want %v", tt.position, tt.level, tt.maxLevel, tt.fixedQty, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+-- Branches: multi-outlet tenants with branch-scoped stock, users and documents
+
+-- 1) Branches
+CREATE TABLE IF NOT EXISTS branches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(20) NOT NULL, -- Short code, e.g. MAIN, CMB-01
+    address TEXT,
+    phone VARCHAR(50),
+    invoice_prefix VARCHAR(20), -- Used when invoices are numbered per branch
+    is_default BOOLEAN DEFAULT FALSE,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, code)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_branches_tenant_default ON branches(tenant_id) WHERE is_default;
+
+-- Every tenant has a default branch holding its existing stock
+INSERT INTO branches (tenant_id, name, code, is_default)
+SELECT t.id, 'Main Branch', 'MAIN', TRUE FROM tenants t
+WHERE NOT EXISTS (SELECT 1 FROM branches b WHERE b.tenant_id = t.id AND b.is_default);
+
+-- 2) Users assigned to branches (owners can use every branch)
+CREATE TABLE IF NOT EXISTS user_branches (
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) ON DELETE CASCADE NOT NULL,
+    is_primary BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, branch_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_branches_branch ON user_branches(branch_id);
+
+-- 3) Stock is held per branch. Existing rows move to the default branch.
+UPDATE inventory_stock i SET branch_id = b.id
+FROM branches b WHERE b.tenant_id = i.tenant_id AND b.is_default AND i.branch_id IS NULL;
+
+ALTER TABLE inventory_stock DROP CONSTRAINT IF EXISTS inventory_stock_branch_id_fkey;
+ALTER TABLE inventory_stock ADD CONSTRAINT inventory_stock_branch_id_fkey FOREIGN KEY (branch_id) REFERENCES branches(id) ON DELETE CASCADE;
+
+-- One stock row per product/variant/branch (replaces the per-tenant keys)
+ALTER TABLE inventory_stock DROP CONSTRAINT IF EXISTS inventory_stock_tenant_id_product_id_variant_id_key;
+DROP INDEX IF EXISTS uq_inventory_stock_item;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_inventory_stock_branch_item
+    ON inventory_stock (tenant_id, product_id,
+        COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid),
+        COALESCE(branch_id, '00000000-0000-0000-0000-000000000000'::uuid));
+
+-- 4) Branch on the ledger and every stock document
+ALTER TABLE stock_ledger ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+ALTER TABLE adjustments ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+ALTER TABLE sale_returns ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+ALTER TABLE purchase_returns ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL;
+
+UPDATE stock_ledger x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+UPDATE sales x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+UPDATE purchases x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+UPDATE adjustments x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+UPDATE sale_returns x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+UPDATE purchase_returns x SET branch_id = b.id FROM branches b WHERE b.tenant_id = x.tenant_id AND b.is_default AND x.branch_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_stock_ledger_tenant_branch ON stock_ledger(tenant_id, branch_id);
+CREATE INDEX IF NOT EXISTS idx_sales_tenant_branch ON sales(tenant_id, branch_id);
+CREATE INDEX IF NOT EXISTS idx_purchases_tenant_branch ON purchases(tenant_id, branch_id);
+
+-- 5) Optional per-branch invoice numbering (invoice_sequences already carries branch_id)
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS invoice_per_branch BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,49 @@
+-- Inventory Costing: weighted average or FIFO, chosen per tenant
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS costing_method VARCHAR(20) NOT NULL DEFAULT 'weighted_average';
+ALTER TABLE tenants DROP CONSTRAINT IF EXISTS tenants_costing_method_check;
+ALTER TABLE tenants ADD CONSTRAINT tenants_costing_method_check CHECK (costing_method IN ('weighted_average', 'fifo'));
+
+-- Value of the stock held per item and branch; avg_cost is kept as the last known unit cost
+-- once the item runs out. NULL = never costed, read as quantity x products.cost_price.
+ALTER TABLE inventory_stock ADD COLUMN IF NOT EXISTS stock_value NUMERIC(14, 4);
+ALTER TABLE inventory_stock ADD COLUMN IF NOT EXISTS avg_cost NUMERIC(14, 4);
+
+UPDATE inventory_stock i SET stock_value = i.quantity * COALESCE(p.cost_price, 0), avg_cost = COALESCE(p.cost_price, 0)
+FROM products p WHERE p.id = i.product_id AND i.stock_value IS NULL;
+
+-- Cost of every movement: unit_cost per unit, cost_amount signed like qty_change
+ALTER TABLE stock_ledger ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(14, 4);
+ALTER TABLE stock_ledger ADD COLUMN IF NOT EXISTS cost_amount NUMERIC(14, 4);
+
+-- Cost of goods sold per sale line
+ALTER TABLE sale_items ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(14, 4);
+
+-- Cost the goods left the source branch at, carried into the destination branch
+ALTER TABLE stock_transfer_items ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(14, 4);
+
+-- Cost layers: one per incoming movement, consumed oldest first
+CREATE TABLE IF NOT EXISTS cost_layers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE,
+    branch_id UUID REFERENCES branches(id) ON DELETE CASCADE NOT NULL,
+    ref_type VARCHAR(50) NOT NULL,
+    ref_id UUID,
+    unit_cost NUMERIC(14, 4) NOT NULL DEFAULT 0,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    quantity_remaining INT NOT NULL CHECK (quantity_remaining >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_cost_layers_open ON cost_layers(tenant_id, product_id, branch_id, created_at) WHERE quantity_remaining > 0;
+
+-- Opening layer for stock held before costing existed
+INSERT INTO cost_layers (tenant_id, product_id, variant_id, branch_id, ref_type, unit_cost, quantity, quantity_remaining)
+SELECT i.tenant_id, i.product_id, i.variant_id, i.branch_id, 'initial', COALESCE(i.avg_cost, 0), i.quantity, i.quantity
+FROM inventory_stock i
+WHERE i.quantity > 0 AND i.branch_id IS NOT NULL AND NOT EXISTS (
+    SELECT 1 FROM cost_layers l WHERE l.product_id = i.product_id AND l.branch_id = i.branch_id
+      AND l.variant_id IS NOT DISTINCT FROM i.variant_id
+);
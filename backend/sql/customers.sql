@@ -0,0 +1,23 @@
+-- Customers: who a sale was made to, for purchase history and lifetime value
+
+CREATE TABLE IF NOT EXISTS customers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    phone VARCHAR(50),
+    email VARCHAR(255),
+    address TEXT,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    notes TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A phone number identifies one customer per tenant (the POS looks them up by it)
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_phone ON customers(tenant_id, phone) WHERE phone IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_customers_email ON customers(tenant_id, lower(email));
+CREATE INDEX IF NOT EXISTS idx_customers_tags ON customers USING GIN (tags);
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS customer_id UUID REFERENCES customers(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_sales_customer ON sales(customer_id, created_at) WHERE customer_id IS NOT NULL;
@@ -0,0 +1,29 @@
+-- Goods Receipts: purchases are received in one or more deliveries (GRNs)
+
+ALTER TABLE purchases DROP CONSTRAINT IF EXISTS purchases_status_check;
+ALTER TABLE purchases ADD CONSTRAINT purchases_status_check CHECK (status IN ('draft', 'partially_received', 'received', 'cancelled'));
+
+CREATE TABLE IF NOT EXISTS goods_receipts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    purchase_id UUID REFERENCES purchases(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
+    receipt_number VARCHAR(50) NOT NULL, -- e.g. GRN-000001
+    notes TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, receipt_number)
+);
+
+CREATE TABLE IF NOT EXISTS goods_receipt_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    receipt_id UUID REFERENCES goods_receipts(id) ON DELETE CASCADE NOT NULL,
+    purchase_item_id UUID REFERENCES purchase_items(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    quantity INT NOT NULL CHECK (quantity > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_goods_receipts_purchase ON goods_receipts(purchase_id);
+CREATE INDEX IF NOT EXISTS idx_goods_receipt_items_receipt ON goods_receipt_items(receipt_id);
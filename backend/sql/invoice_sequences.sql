@@ -0,0 +1,51 @@
+-- Invoice Sequences: gap-free invoice numbering per tenant (and per branch)
+
+-- 1) Tenant setting: restart numbering every calendar year
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS invoice_reset_yearly BOOLEAN DEFAULT TRUE;
+
+-- 2) Sequence counters. A row is locked for the lifetime of the sale transaction,
+-- so a rolled back sale releases its number instead of leaving a gap.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID, -- Null = tenant-wide sequence
+    period_year INT NOT NULL, -- 0 when the tenant never resets numbering
+    last_number INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_invoice_sequences_scope
+    ON invoice_sequences (tenant_id, COALESCE(branch_id, '00000000-0000-0000-0000-000000000000'::uuid), period_year);
+
+-- 3) Continue numbering after invoices issued by the old COUNT(*) based generator.
+-- Runs once, while no sequence exists yet (migrations re-run on every boot).
+-- Seeds the sequence nextInvoiceNumber uses today: the current year (tenant
+-- timezone) for tenants resetting yearly, else period 0, counting the sales of
+-- that period, or past the highest number already issued with its prefix if
+-- that is higher (the old generator numbered every year's sales on).
+INSERT INTO invoice_sequences (tenant_id, period_year, last_number)
+SELECT p.tenant_id, p.period_year, GREATEST(
+        (SELECT COUNT(*) FROM sales s WHERE s.tenant_id = p.tenant_id
+            AND (p.period_year = 0 OR EXTRACT(YEAR FROM s.created_at AT TIME ZONE p.timezone)::int = p.period_year)),
+        (SELECT COALESCE(MAX(SUBSTRING(s.invoice_number FROM '-([0-9]+)$')::int), 0) FROM sales s WHERE s.tenant_id = p.tenant_id
+            AND s.invoice_number LIKE p.prefix || p.current_year || '-%'))
+FROM (
+    SELECT t.id AS tenant_id, COALESCE(t.timezone, 'UTC') AS timezone, COALESCE(t.invoice_prefix_default, 'INV-') AS prefix,
+        EXTRACT(YEAR FROM now() AT TIME ZONE COALESCE(t.timezone, 'UTC'))::int AS current_year,
+        CASE WHEN COALESCE(t.invoice_reset_yearly, true) THEN EXTRACT(YEAR FROM now() AT TIME ZONE COALESCE(t.timezone, 'UTC'))::int ELSE 0 END AS period_year
+    FROM tenants t
+    WHERE EXISTS(SELECT 1 FROM sales s WHERE s.tenant_id = t.id)
+) p
+WHERE NOT EXISTS(SELECT 1 FROM invoice_sequences)
+ON CONFLICT DO NOTHING;
+
+-- 4) Enforce uniqueness. Concurrent checkouts under the old generator could
+-- produce duplicates, so suffix every duplicate except the first one issued.
+UPDATE sales s SET invoice_number = s.invoice_number || '-' || SUBSTRING(s.id::text, 1, 4)
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY tenant_id, invoice_number ORDER BY created_at, id) AS rn
+    FROM sales
+) d
+WHERE s.id = d.id AND d.rn > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_sales_tenant_invoice ON sales(tenant_id, invoice_number);
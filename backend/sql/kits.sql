@@ -0,0 +1,30 @@
+-- Kits & Bundles: a kit product holds no stock of its own; selling one takes its components out of stock
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS product_type VARCHAR(20) NOT NULL DEFAULT 'standard';
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_product_type_check;
+ALTER TABLE products ADD CONSTRAINT products_product_type_check CHECK (product_type IN ('standard', 'kit'));
+
+CREATE TABLE IF NOT EXISTS product_kit_components (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    kit_product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    component_product_id UUID REFERENCES products(id) ON DELETE RESTRICT NOT NULL,
+    component_variant_id UUID REFERENCES product_variants(id) ON DELETE RESTRICT,
+    quantity NUMERIC(14, 3) NOT NULL CHECK (quantity > 0), -- Component stock units per kit
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_kit_components_kit ON product_kit_components(kit_product_id);
+
+-- What a kit sale line took out of stock, so returns put back the same components at the same cost
+CREATE TABLE IF NOT EXISTS sale_item_components (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    sale_item_id UUID REFERENCES sale_items(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    quantity NUMERIC(14, 3) NOT NULL, -- Stock units for the whole line
+    unit_cost NUMERIC(14, 4)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sale_item_components_item ON sale_item_components(sale_item_id);
@@ -0,0 +1,44 @@
+-- Lots: lot numbers and expiry dates for products that track them
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS track_lots BOOLEAN NOT NULL DEFAULT false;
+
+-- Lot-level stock per branch. Stock of a tracked product held outside any lot
+-- (received before tracking was switched on) is inventory_stock less its lots.
+CREATE TABLE IF NOT EXISTS stock_lots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE,
+    branch_id UUID REFERENCES branches(id) ON DELETE CASCADE NOT NULL,
+    lot_number VARCHAR(100) NOT NULL,
+    expiry_date DATE,
+    quantity INT NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_stock_lots_lot ON stock_lots(branch_id, product_id, COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid), lot_number);
+CREATE INDEX IF NOT EXISTS idx_stock_lots_expiry ON stock_lots(tenant_id, expiry_date) WHERE quantity > 0;
+
+ALTER TABLE stock_ledger ADD COLUMN IF NOT EXISTS lot_id UUID REFERENCES stock_lots(id) ON DELETE SET NULL;
+ALTER TABLE goods_receipt_items ADD COLUMN IF NOT EXISTS lot_id UUID REFERENCES stock_lots(id) ON DELETE SET NULL;
+ALTER TABLE adjustment_items ADD COLUMN IF NOT EXISTS lot_id UUID REFERENCES stock_lots(id) ON DELETE SET NULL;
+
+-- The lots a transfer line was dispatched from, so the goods arrive in the same
+-- lots at the destination. No lot_id = stock held outside any lot.
+CREATE TABLE IF NOT EXISTS stock_transfer_item_lots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    transfer_item_id UUID REFERENCES stock_transfer_items(id) ON DELETE CASCADE NOT NULL,
+    lot_id UUID REFERENCES stock_lots(id) ON DELETE SET NULL, -- Source lot
+    lot_number VARCHAR(100),
+    expiry_date DATE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    quantity_received INT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_transfer_item_lots_item ON stock_transfer_item_lots(transfer_item_id);
+
+-- Expired lots are written off with their own adjustment reason
+ALTER TABLE adjustments DROP CONSTRAINT IF EXISTS adjustments_reason_check;
+ALTER TABLE adjustments ADD CONSTRAINT adjustments_reason_check CHECK (reason IN ('damage', 'correction', 'count', 'theft', 'expired', 'other'));
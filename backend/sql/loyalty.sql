@@ -0,0 +1,44 @@
+-- Loyalty: customers earn points on what they pay and redeem them as a tender
+
+-- 1) Program rules, per tenant
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS loyalty_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS loyalty_points_per_unit NUMERIC(10, 4) NOT NULL DEFAULT 1; -- Earned per currency unit paid (before tax)
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS loyalty_point_value NUMERIC(10, 4) NOT NULL DEFAULT 0.01; -- Currency one point pays when redeemed
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS loyalty_min_redeem INT NOT NULL DEFAULT 0; -- Fewest points redeemable on a sale
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS loyalty_expiry_days INT; -- NULL = points never expire
+
+-- Earn multipliers by product category (products without a listed category earn at 1x)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category VARCHAR(100);
+
+CREATE TABLE IF NOT EXISTS loyalty_category_multipliers (
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    category VARCHAR(100) NOT NULL,
+    multiplier NUMERIC(6, 2) NOT NULL CHECK (multiplier >= 0),
+    PRIMARY KEY (tenant_id, category)
+);
+
+-- 2) Points ledger: every earn, redemption, reversal, expiry and manual adjustment.
+-- Positive entries keep what is left of them in remaining; spending draws on the
+-- entries expiring first, so the balance is the unexpired remaining.
+CREATE TABLE IF NOT EXISTS loyalty_point_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    customer_id UUID REFERENCES customers(id) ON DELETE CASCADE NOT NULL,
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('earn', 'redeem', 'reverse', 'expire', 'adjust')),
+    points INT NOT NULL, -- Signed
+    remaining INT NOT NULL DEFAULT 0 CHECK (remaining >= 0),
+    sale_id UUID REFERENCES sales(id) ON DELETE SET NULL,
+    sale_return_id UUID REFERENCES sale_returns(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    note TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_point_entries_customer ON loyalty_point_entries(customer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_loyalty_point_entries_open ON loyalty_point_entries(customer_id, expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS idx_loyalty_point_entries_sale ON loyalty_point_entries(sale_id) WHERE sale_id IS NOT NULL;
+
+-- 3) Points are a tender
+ALTER TABLE sale_payments DROP CONSTRAINT IF EXISTS sale_payments_method_check;
+ALTER TABLE sale_payments ADD CONSTRAINT sale_payments_method_check CHECK (method IN ('cash', 'card', 'store_credit', 'bank_transfer', 'mobile_wallet', 'points'));
@@ -0,0 +1,64 @@
+-- Manufacturing: bills of materials and production orders that turn ingredients into finished goods
+
+CREATE TABLE IF NOT EXISTS boms (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL, -- Finished good
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    output_qty NUMERIC(14, 3) NOT NULL DEFAULT 1 CHECK (output_qty > 0), -- Finished stock units one batch of the items makes
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    notes TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_boms_product ON boms(tenant_id, product_id);
+
+CREATE TABLE IF NOT EXISTS bom_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    bom_id UUID REFERENCES boms(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE RESTRICT NOT NULL, -- Ingredient
+    variant_id UUID REFERENCES product_variants(id) ON DELETE RESTRICT,
+    quantity NUMERIC(14, 3) NOT NULL CHECK (quantity > 0), -- Ingredient stock units per batch, before waste
+    waste_pct NUMERIC(5, 2) NOT NULL DEFAULT 0 CHECK (waste_pct >= 0 AND waste_pct < 100) -- Extra consumed on top, e.g. trimmings
+);
+
+CREATE INDEX IF NOT EXISTS idx_bom_items_bom ON bom_items(bom_id);
+
+CREATE TABLE IF NOT EXISTS production_orders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) NOT NULL, -- Where ingredients are used and goods produced
+    reference_no VARCHAR(100) NOT NULL, -- e.g. PRD-000001
+    bom_id UUID REFERENCES boms(id) ON DELETE SET NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    quantity NUMERIC(14, 3) NOT NULL CHECK (quantity > 0), -- Finished stock units to produce
+    status VARCHAR(30) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'completed', 'cancelled')),
+    total_cost NUMERIC(14, 4), -- Ingredients consumed, set on completion
+    unit_cost NUMERIC(14, 4),
+    lot_id UUID REFERENCES stock_lots(id) ON DELETE SET NULL,
+    notes TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    completed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, reference_no)
+);
+
+CREATE INDEX IF NOT EXISTS idx_production_orders_tenant_status ON production_orders(tenant_id, status);
+
+-- Ingredients of an order, scaled from the BOM (waste included) when the order is raised
+CREATE TABLE IF NOT EXISTS production_order_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    order_id UUID REFERENCES production_orders(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    quantity NUMERIC(14, 3) NOT NULL CHECK (quantity > 0),
+    unit_cost NUMERIC(14, 4) -- Set on completion
+);
+
+CREATE INDEX IF NOT EXISTS idx_production_order_items_order ON production_order_items(order_id);
@@ -0,0 +1,33 @@
+-- Offline sync conflicts: offline sales short of stock at sync time wait here for
+-- a manager instead of being rejected (the customer has already paid)
+
+-- 'hold' queues them; 'allow_negative' posts them at once, taking stock below zero
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS offline_stock_conflicts VARCHAR(20) NOT NULL DEFAULT 'hold';
+ALTER TABLE tenants DROP CONSTRAINT IF EXISTS tenants_offline_stock_conflicts_check;
+ALTER TABLE tenants ADD CONSTRAINT tenants_offline_stock_conflicts_check CHECK (offline_stock_conflicts IN ('hold', 'allow_negative'));
+
+CREATE TABLE IF NOT EXISTS offline_sync_conflicts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) NOT NULL, -- Branch the terminal synced for
+    local_sale_id UUID NOT NULL,
+    payload JSONB NOT NULL, -- The sale as the terminal sent it
+    reason TEXT NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL, -- First item short
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    available NUMERIC(14, 3),
+    requested NUMERIC(14, 3),
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved')),
+    resolution VARCHAR(20) CHECK (resolution IN ('negative_stock', 'adjustment', 'void')),
+    sale_id UUID REFERENCES sales(id) ON DELETE SET NULL, -- Posted sale (negative_stock, adjustment)
+    refund_amount NUMERIC(12, 2), -- Paid back (void)
+    refund_method VARCHAR(30),
+    resolution_note TEXT,
+    synced_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL, -- NULL when posted automatically
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, local_sale_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_offline_sync_conflicts_status ON offline_sync_conflicts(tenant_id, status, created_at);
@@ -0,0 +1,31 @@
+-- Offline catalog feed: every product, variant and stock row remembers the
+-- transaction that last wrote it (catalog_txid, kept by trigger so no write path
+-- can miss it). A delta returns the rows written by transactions at or after the
+-- cursor, the oldest transaction still running when the previous feed was read.
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS catalog_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS catalog_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE inventory_stock ADD COLUMN IF NOT EXISTS catalog_txid BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_products_tenant_catalog_txid ON products(tenant_id, catalog_txid);
+CREATE INDEX IF NOT EXISTS idx_product_variants_catalog_txid ON product_variants(product_id, catalog_txid);
+CREATE INDEX IF NOT EXISTS idx_inventory_stock_branch_catalog_txid ON inventory_stock(branch_id, catalog_txid);
+
+CREATE OR REPLACE FUNCTION set_catalog_txid() RETURNS trigger AS $$
+BEGIN
+    NEW.catalog_txid := txid_current();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_products_catalog_txid ON products;
+CREATE TRIGGER trg_products_catalog_txid BEFORE INSERT OR UPDATE ON products
+    FOR EACH ROW EXECUTE PROCEDURE set_catalog_txid();
+
+DROP TRIGGER IF EXISTS trg_product_variants_catalog_txid ON product_variants;
+CREATE TRIGGER trg_product_variants_catalog_txid BEFORE INSERT OR UPDATE ON product_variants
+    FOR EACH ROW EXECUTE PROCEDURE set_catalog_txid();
+
+DROP TRIGGER IF EXISTS trg_inventory_stock_catalog_txid ON inventory_stock;
+CREATE TRIGGER trg_inventory_stock_catalog_txid BEFORE INSERT OR UPDATE ON inventory_stock
+    FOR EACH ROW EXECUTE PROCEDURE set_catalog_txid();
@@ -0,0 +1,19 @@
+-- POS device enrollment: a terminal enrolls once (fingerprint, name, branch) and
+-- sends its device token with every POS call. Only the token's hash is stored.
+
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS name VARCHAR(100);
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES branches(id) ON DELETE SET NULL; -- Branch the terminal works in by default
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64) UNIQUE; -- SHA-256 of the device token; NULL once revoked
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE pos_devices ADD COLUMN IF NOT EXISTS revoked_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE pos_devices DROP CONSTRAINT IF EXISTS pos_devices_status_check;
+ALTER TABLE pos_devices ADD CONSTRAINT pos_devices_status_check CHECK (status IN ('active', 'revoked'));
+
+-- Devices recorded before enrollment have no token and cannot be used: don't count them.
+-- An owner or manager enrolls each terminal from the POS screen.
+UPDATE pos_devices SET status = 'revoked', revoked_at = COALESCE(revoked_at, now()) WHERE status = 'active' AND token_hash IS NULL;
+
+-- A fingerprint enrolls once per tenant
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pos_devices_tenant_fingerprint ON pos_devices(tenant_id, device_hash) WHERE status = 'active';
@@ -0,0 +1,23 @@
+-- Product Variants: variant-level stock, ledger, sales, purchases and returns
+
+-- 1) Variants can be retired without losing their stock history
+ALTER TABLE product_variants
+ADD COLUMN IF NOT EXISTS is_active BOOLEAN DEFAULT TRUE,
+ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_product_variants_tenant_sku
+    ON product_variants(tenant_id, sku) WHERE sku IS NOT NULL AND sku <> '';
+CREATE INDEX IF NOT EXISTS idx_product_variants_product ON product_variants(product_id);
+CREATE INDEX IF NOT EXISTS idx_product_variants_tenant_barcode ON product_variants(tenant_id, barcode);
+
+-- 2) One stock row per product/variant: see uq_inventory_stock_branch_item in
+-- branches.sql (UNIQUE(tenant_id, product_id, variant_id) misses NULL variants)
+
+-- 3) Variant on every document line and ledger entry (Null = product-level)
+ALTER TABLE stock_ledger ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE purchase_items ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE adjustment_items ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE sale_return_items ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE purchase_return_items ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_stock_ledger_tenant_variant ON stock_ledger(tenant_id, variant_id) WHERE variant_id IS NOT NULL;
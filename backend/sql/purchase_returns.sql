@@ -0,0 +1,38 @@
+-- Purchase Returns: returns against received purchase lines, refunded as supplier credit
+
+-- 1) How much of each purchase line has arrived
+ALTER TABLE purchase_items ADD COLUMN IF NOT EXISTS quantity_received INT NOT NULL DEFAULT 0;
+
+UPDATE purchase_items pi SET quantity_received = pi.quantity
+FROM purchases p WHERE p.id = pi.purchase_id AND p.status = 'received' AND pi.quantity_received = 0;
+
+-- 2) Each returned quantity points at the purchase line it comes from
+ALTER TABLE purchase_return_items
+ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+ADD COLUMN IF NOT EXISTS purchase_item_id UUID REFERENCES purchase_items(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS cost_price NUMERIC(12, 2) DEFAULT 0,
+ADD COLUMN IF NOT EXISTS refund_amount NUMERIC(12, 2) DEFAULT 0;
+
+UPDATE purchase_return_items ri SET tenant_id = r.tenant_id
+FROM purchase_returns r WHERE r.id = ri.purchase_return_id AND ri.tenant_id IS NULL;
+
+UPDATE purchase_return_items ri SET purchase_item_id = (
+    SELECT pi.id FROM purchase_items pi JOIN purchase_returns r ON r.purchase_id = pi.purchase_id
+    WHERE r.id = ri.purchase_return_id AND pi.product_id = ri.product_id AND pi.variant_id IS NOT DISTINCT FROM ri.variant_id
+    LIMIT 1)
+WHERE ri.purchase_item_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_purchase_return_items_item ON purchase_return_items(purchase_item_id);
+
+-- 3) Supplier credit notes: what the supplier owes us back, offset against what we owe them
+CREATE TABLE IF NOT EXISTS supplier_credit_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    supplier_id UUID REFERENCES suppliers(id) ON DELETE CASCADE NOT NULL,
+    purchase_return_id UUID REFERENCES purchase_returns(id) ON DELETE SET NULL,
+    amount NUMERIC(12, 2) NOT NULL CHECK (amount >= 0),
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_credit_notes_supplier ON supplier_credit_notes(tenant_id, supplier_id);
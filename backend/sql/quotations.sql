@@ -0,0 +1,46 @@
+-- Quotations: priced offers to a customer that convert into sales at the quoted prices
+
+CREATE TABLE IF NOT EXISTS quotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id), -- Where it was raised
+    reference_no VARCHAR(100) NOT NULL, -- e.g. QUO-000001
+    customer_id UUID REFERENCES customers(id) ON DELETE SET NULL,
+    status VARCHAR(30) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'accepted', 'expired', 'converted')),
+    valid_until DATE NOT NULL, -- Last day the prices hold
+    total_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    discount_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    tax_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    final_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    notes TEXT,
+    sale_id UUID REFERENCES sales(id) ON DELETE SET NULL, -- Set on conversion
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    converted_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE(tenant_id, reference_no)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quotations_tenant_status ON quotations(tenant_id, status, valid_until);
+CREATE INDEX IF NOT EXISTS idx_quotations_customer ON quotations(customer_id) WHERE customer_id IS NOT NULL;
+
+-- Lines as priced when quoted; conversion sells at these unit prices
+CREATE TABLE IF NOT EXISTS quotation_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    quotation_id UUID REFERENCES quotations(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    product_name VARCHAR(255) NOT NULL,
+    quantity NUMERIC(14, 3) NOT NULL CHECK (quantity > 0), -- In unit_id
+    unit_id UUID REFERENCES units(id) ON DELETE SET NULL,
+    unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1,
+    unit_price NUMERIC(12, 2) NOT NULL, -- Locked price per unit quoted
+    total_price NUMERIC(12, 2) NOT NULL,
+    discount_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    net_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    tax_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    tax_details JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_quotation_items_quotation ON quotation_items(quotation_id);
@@ -0,0 +1,25 @@
+-- Reorder Points: min/max levels per product (optionally per branch) and suggested purchases
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_level INT;  -- Reorder at or below this (NULL = not managed)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS max_level INT;      -- Order up to this
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_qty INT;    -- Fixed order quantity, overrides max_level
+ALTER TABLE products ADD COLUMN IF NOT EXISTS preferred_supplier_id UUID REFERENCES suppliers(id) ON DELETE SET NULL;
+
+-- Branch overrides of the product levels
+CREATE TABLE IF NOT EXISTS product_reorder_levels (
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) ON DELETE CASCADE NOT NULL,
+    reorder_level INT NOT NULL CHECK (reorder_level >= 0),
+    max_level INT,
+    reorder_qty INT,
+    PRIMARY KEY (product_id, branch_id)
+);
+
+-- Drafts raised by the reorder job stay suggestions until someone confirms them
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS is_suggested BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS idx_purchases_suggested ON purchases(tenant_id) WHERE is_suggested;
+
+-- Set when a suggestion is dismissed; its items are not suggested again at that
+-- branch until their stock moves
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS dismissed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,15 @@
+-- Sale origin: when a sale was really made and which terminal and cashier made it.
+-- created_at is when the server recorded the sale; for offline sales sold_at is
+-- the device's sale time (clock-corrected), which reports bucket by.
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS sold_at TIMESTAMP WITH TIME ZONE;
+UPDATE sales SET sold_at = created_at WHERE sold_at IS NULL;
+ALTER TABLE sales ALTER COLUMN sold_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE sales ALTER COLUMN sold_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sales_tenant_sold_at ON sales(tenant_id, sold_at);
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS device_id UUID REFERENCES pos_devices(id) ON DELETE SET NULL;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS device_created_at TIMESTAMP WITH TIME ZONE; -- Sale time by the device clock, as reported
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS device_clock_skew INT; -- Seconds the device clock was behind the server's at sync (negative = ahead)
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS device_time_rejected BOOLEAN NOT NULL DEFAULT false; -- Failed the sanity checks; sold_at is the receipt time
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS synced_by UUID REFERENCES users(id) ON DELETE SET NULL; -- Offline sales: who synced them (created_by rang them up)
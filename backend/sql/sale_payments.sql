@@ -0,0 +1,22 @@
+-- Sale Payments: multi-tender sales (part cash, part card, part store credit)
+CREATE TABLE IF NOT EXISTS sale_payments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    sale_id UUID REFERENCES sales(id) ON DELETE CASCADE NOT NULL,
+    line_no INT NOT NULL DEFAULT 0, -- Order the tenders were taken in
+    method VARCHAR(50) NOT NULL CHECK (method IN ('cash', 'card', 'store_credit', 'bank_transfer', 'mobile_wallet')),
+    amount NUMERIC(12, 2) NOT NULL, -- Applied to the sale (cash is net of change)
+    tendered NUMERIC(12, 2) NOT NULL, -- Handed over by the customer
+    reference VARCHAR(100), -- Card slip / transfer reference
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sale_payments_sale ON sale_payments(sale_id);
+CREATE INDEX IF NOT EXISTS idx_sale_payments_tenant_method ON sale_payments(tenant_id, method);
+
+-- Backfill single-tender history so every sale has a payment breakdown
+INSERT INTO sale_payments (tenant_id, sale_id, method, amount, tendered, created_at)
+SELECT s.tenant_id, s.id, s.payment_method, s.final_amount, GREATEST(s.payment_received, s.final_amount), s.created_at
+FROM sales s
+WHERE s.payment_method IN ('cash', 'card', 'store_credit', 'bank_transfer', 'mobile_wallet')
+  AND NOT EXISTS (SELECT 1 FROM sale_payments sp WHERE sp.sale_id = s.id);
@@ -0,0 +1,21 @@
+-- Sale Returns: returns are checked line by line against what was sold and refunded
+
+-- 1) Each returned quantity points at the sale line it comes from
+ALTER TABLE sale_return_items
+ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+ADD COLUMN IF NOT EXISTS sale_item_id UUID REFERENCES sale_items(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS refund_amount NUMERIC(12, 2) DEFAULT 0,
+ADD COLUMN IF NOT EXISTS restock BOOLEAN DEFAULT TRUE; -- False = written off (damaged, expired, ...)
+
+UPDATE sale_return_items ri SET tenant_id = r.tenant_id
+FROM sale_returns r WHERE r.id = ri.sale_return_id AND ri.tenant_id IS NULL;
+
+-- Older returns were not linked to a line: attach them to the first matching one
+UPDATE sale_return_items ri SET sale_item_id = (
+    SELECT si.id FROM sale_items si JOIN sale_returns r ON r.sale_id = si.sale_id
+    WHERE r.id = ri.sale_return_id AND si.product_id = ri.product_id AND si.variant_id IS NOT DISTINCT FROM ri.variant_id
+    LIMIT 1)
+WHERE ri.sale_item_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sale_return_items_sale_item ON sale_return_items(sale_item_id);
+CREATE INDEX IF NOT EXISTS idx_sale_returns_sale ON sale_returns(sale_id);
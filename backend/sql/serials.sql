@@ -0,0 +1,41 @@
+-- Serial Numbers: each unit of a serialized product is tracked by its serial
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS track_serials BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS product_serials (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    branch_id UUID REFERENCES branches(id) ON DELETE SET NULL, -- Where the unit is (or was last)
+    serial_number VARCHAR(100) NOT NULL,
+    status VARCHAR(30) NOT NULL DEFAULT 'in_stock' CHECK (status IN ('in_stock', 'sold', 'returned', 'returned_to_supplier')),
+    sale_item_id UUID REFERENCES sale_items(id) ON DELETE SET NULL, -- Last sale line the unit went out on
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, product_id, serial_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_serials_lookup ON product_serials(tenant_id, serial_number);
+CREATE INDEX IF NOT EXISTS idx_product_serials_stock ON product_serials(product_id, branch_id) WHERE status = 'in_stock';
+
+-- History of a unit: received, sold, returned
+CREATE TABLE IF NOT EXISTS serial_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    serial_id UUID REFERENCES product_serials(id) ON DELETE CASCADE NOT NULL,
+    event_type VARCHAR(30) NOT NULL CHECK (event_type IN ('purchase', 'sale', 'sale_return', 'purchase_return')),
+    ref_id UUID NOT NULL, -- Goods receipt, sale, sale return, purchase return or adjustment
+    branch_id UUID REFERENCES branches(id) ON DELETE SET NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_serial_events_serial ON serial_events(serial_id, created_at);
+
+-- Adjustments move serialized stock by serial: units found come (back) into
+-- stock, units lost or damaged are written off
+ALTER TABLE product_serials DROP CONSTRAINT IF EXISTS product_serials_status_check;
+ALTER TABLE product_serials ADD CONSTRAINT product_serials_status_check CHECK (status IN ('in_stock', 'sold', 'returned', 'returned_to_supplier', 'written_off'));
+ALTER TABLE serial_events DROP CONSTRAINT IF EXISTS serial_events_event_type_check;
+ALTER TABLE serial_events ADD CONSTRAINT serial_events_event_type_check CHECK (event_type IN ('purchase', 'sale', 'sale_return', 'purchase_return', 'adjustment'));
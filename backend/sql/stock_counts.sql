@@ -0,0 +1,52 @@
+-- Stock Counts: stock-take sessions with blind counts, posted as one adjustment
+
+CREATE TABLE IF NOT EXISTS stock_counts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    branch_id UUID REFERENCES branches(id) ON DELETE CASCADE NOT NULL,
+    reference_no VARCHAR(50) NOT NULL, -- e.g. SC-000001
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'approved', 'cancelled')),
+    freeze_stock BOOLEAN NOT NULL DEFAULT false, -- Block stock movements of counted items while open
+    notes TEXT,
+    adjustment_id UUID REFERENCES adjustments(id) ON DELETE SET NULL, -- Posted on approval
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    approved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP, -- Snapshot time
+    approved_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE(tenant_id, reference_no)
+);
+
+-- One open count per branch
+CREATE UNIQUE INDEX IF NOT EXISTS uq_stock_counts_open_branch ON stock_counts(branch_id) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS stock_count_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    count_id UUID REFERENCES stock_counts(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE,
+    expected_qty INT NOT NULL DEFAULT 0, -- Snapshot when the count was opened
+    counted_qty INT, -- Recorded on approval
+    qty_change INT   -- Posted on approval
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_stock_count_items_item ON stock_count_items(count_id, product_id, COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid));
+
+-- Blind counts: one per staff member and item, the latest submission wins.
+-- Where staff disagree a manager records the reconciled quantity before approval.
+CREATE TABLE IF NOT EXISTS stock_count_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    count_id UUID REFERENCES stock_counts(id) ON DELETE CASCADE NOT NULL,
+    item_id UUID REFERENCES stock_count_items(id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    counted_qty INT NOT NULL CHECK (counted_qty >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(item_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_count_entries_count ON stock_count_entries(count_id);
+
+-- The manager's quantity for items whose counters disagree; cleared when anyone recounts
+ALTER TABLE stock_count_items ADD COLUMN IF NOT EXISTS reconciled_qty INT CHECK (reconciled_qty >= 0);
+ALTER TABLE stock_count_items ADD COLUMN IF NOT EXISTS reconciled_by UUID REFERENCES users(id) ON DELETE SET NULL;
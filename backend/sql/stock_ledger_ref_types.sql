@@ -0,0 +1,8 @@
+-- Stock Ledger ref types: the one place the allowed ref_type values are listed.
+-- Features that add a new kind of stock movement extend this list.
+ALTER TABLE stock_ledger DROP CONSTRAINT IF EXISTS stock_ledger_ref_type_check;
+ALTER TABLE stock_ledger ADD CONSTRAINT stock_ledger_ref_type_check CHECK (ref_type IN (
+    'sale', 'purchase', 'sale_return', 'purchase_return', 'adjustment', 'initial',
+    'transfer_out', 'transfer_in', 'goods_receipt', 'production_out', 'production_in',
+    'sync_conflict'
+));
@@ -0,0 +1,34 @@
+-- Stock Transfers: moving stock between branches, with goods in transit in between
+
+CREATE TABLE IF NOT EXISTS stock_transfers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    reference_no VARCHAR(100) NOT NULL, -- e.g. TRF-000001
+    from_branch_id UUID REFERENCES branches(id) NOT NULL,
+    to_branch_id UUID REFERENCES branches(id) NOT NULL,
+    status VARCHAR(50) DEFAULT 'draft' CHECK (status IN ('draft', 'dispatched', 'partially_received', 'received', 'cancelled')),
+    notes TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    dispatched_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    dispatched_at TIMESTAMP WITH TIME ZONE,
+    received_at TIMESTAMP WITH TIME ZONE, -- Set when the transfer is closed
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (from_branch_id <> to_branch_id),
+    UNIQUE(tenant_id, reference_no)
+);
+
+CREATE TABLE IF NOT EXISTS stock_transfer_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    transfer_id UUID REFERENCES stock_transfers(id) ON DELETE CASCADE NOT NULL,
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE NOT NULL,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    quantity INT NOT NULL CHECK (quantity > 0), -- Sent
+    quantity_received INT NOT NULL DEFAULT 0,
+    quantity_short INT NOT NULL DEFAULT 0, -- Written off as lost in transit when the transfer is closed short
+    discrepancy_note TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_transfers_tenant_status ON stock_transfers(tenant_id, status);
+CREATE INDEX IF NOT EXISTS idx_stock_transfer_items_transfer ON stock_transfer_items(transfer_id);
@@ -0,0 +1,56 @@
+-- Tax Engine: tenant tax rates, tax classes and per-line tax snapshots
+
+-- 1) Tenant setting: whether selling prices already include tax
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN DEFAULT FALSE;
+
+-- 2) Tax Rates (e.g. "VAT 15%", "City Levy 2%")
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    rate NUMERIC(7, 4) NOT NULL CHECK (rate >= 0), -- Percent
+    is_compound BOOLEAN DEFAULT FALSE, -- Charged on price + the taxes applied before it
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 3) Tax Classes (e.g. "Standard", "Zero Rated", "Alcohol")
+CREATE TABLE IF NOT EXISTS tax_classes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, name)
+);
+
+-- 4) Rates applied by a class, in order (compound rates see the taxes before them)
+CREATE TABLE IF NOT EXISTS tax_class_rates (
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    tax_class_id UUID REFERENCES tax_classes(id) ON DELETE CASCADE NOT NULL,
+    tax_rate_id UUID REFERENCES tax_rates(id) ON DELETE CASCADE NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tax_class_id, tax_rate_id)
+);
+
+-- 5) Products carry a tax class (Null = untaxed)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS tax_class_id UUID REFERENCES tax_classes(id) ON DELETE SET NULL;
+
+-- 6) Per-line tax snapshots
+ALTER TABLE sale_items
+ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(12, 2) DEFAULT 0, -- Share of the sale discount
+ADD COLUMN IF NOT EXISTS net_amount NUMERIC(12, 2) DEFAULT 0, -- Taxable amount after discount
+ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(12, 2) DEFAULT 0,
+ADD COLUMN IF NOT EXISTS tax_details JSONB; -- [{tax_rate_id, name, rate, is_compound, taxable_amount, tax_amount}]
+
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE purchase_items
+ADD COLUMN IF NOT EXISTS net_amount NUMERIC(12, 2) DEFAULT 0,
+ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(12, 2) DEFAULT 0,
+ADD COLUMN IF NOT EXISTS tax_details JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_tax_rates_tenant ON tax_rates(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_tax_classes_tenant ON tax_classes(tenant_id);
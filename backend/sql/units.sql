@@ -0,0 +1,58 @@
+-- Units of Measure: decimal quantities and pack conversions
+
+CREATE TABLE IF NOT EXISTS units (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(50) NOT NULL,       -- e.g. Kilogram, Case
+    short_name VARCHAR(20) NOT NULL, -- e.g. kg, cs
+    allow_decimals BOOLEAN NOT NULL DEFAULT false, -- Stock held in this unit may be fractional (0.75 kg)
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant_id, short_name)
+);
+
+-- 1) Stock is held in the product's unit (Null = whole pieces); purchases and
+-- sales default to their own unit, a pack of factor stock units (a case of 24)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS unit_id UUID REFERENCES units(id) ON DELETE SET NULL;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS purchase_unit_id UUID REFERENCES units(id) ON DELETE SET NULL;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS purchase_unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_unit_id UUID REFERENCES units(id) ON DELETE SET NULL;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1;
+
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_unit_factors_check;
+ALTER TABLE products ADD CONSTRAINT products_unit_factors_check CHECK (purchase_unit_factor > 0 AND sale_unit_factor > 0);
+
+-- 2) Purchase and sale lines keep the unit they were entered in; their quantities
+-- (received, returned) are in that unit, stock moves in stock units
+ALTER TABLE purchase_items ADD COLUMN IF NOT EXISTS unit_id UUID REFERENCES units(id) ON DELETE SET NULL;
+ALTER TABLE purchase_items ADD COLUMN IF NOT EXISTS unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1;
+ALTER TABLE sale_items ADD COLUMN IF NOT EXISTS unit_id UUID REFERENCES units(id) ON DELETE SET NULL;
+ALTER TABLE sale_items ADD COLUMN IF NOT EXISTS unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1;
+
+-- 3) Decimal quantities (3 places) for stock, the ledger and every document line
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE table_schema = current_schema() AND data_type = 'integer'
+          AND (table_name, column_name) IN (
+              ('inventory_stock', 'quantity'),
+              ('stock_ledger', 'qty_change'), ('stock_ledger', 'qty_after'),
+              ('sale_items', 'quantity'),
+              ('purchase_items', 'quantity'), ('purchase_items', 'quantity_received'),
+              ('goods_receipt_items', 'quantity'),
+              ('adjustment_items', 'qty_change'),
+              ('sale_return_items', 'quantity'),
+              ('purchase_return_items', 'quantity'),
+              ('stock_transfer_items', 'quantity'), ('stock_transfer_items', 'quantity_received'), ('stock_transfer_items', 'quantity_short'),
+              ('stock_count_items', 'expected_qty'), ('stock_count_items', 'counted_qty'), ('stock_count_items', 'qty_change'),
+              ('stock_count_entries', 'counted_qty'),
+              ('stock_lots', 'quantity'),
+              ('cost_layers', 'quantity'), ('cost_layers', 'quantity_remaining'),
+              ('products', 'reorder_level'), ('products', 'max_level'), ('products', 'reorder_qty'),
+              ('product_reorder_levels', 'reorder_level'), ('product_reorder_levels', 'max_level'), ('product_reorder_levels', 'reorder_qty'))
+    LOOP
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE NUMERIC(14, 3)', col.table_name, col.column_name);
+    END LOOP;
+END $$;
@@ -102,21 +102,55 @@ func deleteTenant(tenantID string) error {
 		"pos_devices",
 		"audit_logs",
 		"offline_sync_map",
+		"offline_sync_conflicts",
+		"user_branches",
+		"loyalty_point_entries",
+		"quotation_items",
+		"quotations",
+		"sale_payments",
+		"sale_item_components",
+		"invoice_sequences",
+		"serial_events",
+		"product_serials",
 		"sale_return_items",
 		"sale_returns",
+		"supplier_credit_notes",
 		"purchase_return_items",
 		"purchase_returns",
+		"production_order_items",
+		"production_orders",
+		"bom_items",
+		"boms",
+		"stock_transfer_item_lots",
+		"stock_transfer_items",
+		"stock_transfers",
+		"stock_count_entries",
+		"stock_count_items",
+		"stock_counts",
 		"adjustment_items",
 		"adjustments",
 		"stock_ledger",
+		"cost_layers",
+		"stock_lots",
+		"goods_receipt_items",
+		"goods_receipts",
 		"purchase_items",
 		"purchases",
+		"product_reorder_levels",
 		"suppliers",
 		"sale_items",
 		"sales",
+		"customers",
 		"inventory_stock",
+		"product_kit_components",
 		"product_variants",
 		"products",
+		"loyalty_category_multipliers",
+		"units",
+		"tax_class_rates",
+		"tax_classes",
+		"tax_rates",
+		"branches",
 		"users",
 	}
 
@@ -0,0 +1,27 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// StartLoyaltyWorker periodically expires loyalty points past their expiry date
+func StartLoyaltyWorker() {
+	log.Println("Loyalty expiry worker started")
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	// Run immediately on start
+	if err := services.ProcessAllLoyaltyExpiry(); err != nil {
+		log.Printf("Error expiring loyalty points: %v", err)
+	}
+
+	for range ticker.C {
+		if err := services.ProcessAllLoyaltyExpiry(); err != nil {
+			log.Printf("Error expiring loyalty points: %v", err)
+		}
+	}
+}
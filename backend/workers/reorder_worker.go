@@ -0,0 +1,27 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/insaansher/sherpos/backend/services"
+)
+
+// StartReorderWorker periodically turns low stock into suggested purchases
+func StartReorderWorker() {
+	log.Println("Reorder suggestion worker started")
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	// Run immediately on start
+	if err := services.ProcessAllReorderSuggestions(); err != nil {
+		log.Printf("Error processing reorder suggestions: %v", err)
+	}
+
+	for range ticker.C {
+		if err := services.ProcessAllReorderSuggestions(); err != nil {
+			log.Printf("Error processing reorder suggestions: %v", err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import { CartProvider } from "@/components/pos/useCartStore";
 import ProductList from "@/components/pos/ProductList";
 import CartPanel from "@/components/pos/CartPanel";
 import SyncCenter from "@/components/pos/SyncCenter";
+import DeviceEnrollment from "@/components/pos/DeviceEnrollment";
 import { NotificationProvider } from "@/components/ui/toast";
 
 export default function POSPage() {
@@ -22,6 +23,7 @@ export default function POSPage() {
                     </div>
                 </div>
                 <SyncCenter />
+                <DeviceEnrollment />
             </CartProvider>
         </NotificationProvider>
     );
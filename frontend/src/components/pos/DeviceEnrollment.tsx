@@ -0,0 +1,63 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import { useQueryClient } from "@tanstack/react-query";
+import { MonitorSmartphone } from "lucide-react";
+import api from "@/lib/api";
+import { getDeviceName } from "@/lib/device";
+import { posApi } from "./posApi";
+import { Button, Input, Card } from "@/components/ui/primitives";
+
+// Blocks the POS until this terminal is enrolled. Only an owner or manager can
+// enroll it; a revoked terminal stays blocked until an owner deals with it.
+export default function DeviceEnrollment() {
+    const queryClient = useQueryClient();
+    const [refused, setRefused] = useState<string | null>(null);
+    const [name, setName] = useState("");
+    const [error, setError] = useState<string | null>(null);
+    const [enrolling, setEnrolling] = useState(false);
+
+    useEffect(() => {
+        setName(getDeviceName());
+        api.get("/pos/ping").catch((err) => {
+            if (err.response?.status === 403 && err.response.data?.code === "DEVICE_NOT_REGISTERED") {
+                setRefused(err.response.data.error);
+            }
+        });
+    }, []);
+
+    if (!refused) return null;
+
+    const enroll = async () => {
+        setEnrolling(true);
+        setError(null);
+        try {
+            await posApi.enrollDevice(name);
+            setRefused(null);
+            queryClient.invalidateQueries({ queryKey: ["pos"] });
+        } catch (err: any) {
+            setError(err.response?.data?.error || err.message);
+        } finally {
+            setEnrolling(false);
+        }
+    };
+
+    return (
+        <div className="fixed inset-0 z-[60] bg-background/90 backdrop-blur-sm flex items-center justify-center">
+            <Card className="w-[420px] p-8 space-y-5">
+                <div className="flex items-center gap-3">
+                    <MonitorSmartphone className="text-primary" />
+                    <h2 className="text-xl font-bold tracking-tight">Enroll this terminal</h2>
+                </div>
+                <p className="text-sm text-muted-foreground">
+                    {refused}. An owner or manager must enroll it before it can take sales.
+                </p>
+                <Input value={name} onChange={(e) => setName(e.target.value)} placeholder="Terminal name" maxLength={100} />
+                {error && <p className="text-sm text-destructive">{error}</p>}
+                <Button className="w-full" disabled={enrolling || !name.trim()} onClick={enroll}>
+                    {enrolling ? "Enrolling..." : "Enroll terminal"}
+                </Button>
+            </Card>
+        </div>
+    );
+}
@@ -1,4 +1,5 @@
 import api from "@/lib/api";
+import { getDeviceFingerprint, getDeviceName, setDeviceToken } from "@/lib/device";
 
 export interface POSProduct {
     id: string;
@@ -49,5 +50,12 @@ export const posApi = {
     createSale: async (data: CreateSaleRequest) => {
         const res = await api.post("/pos/sales", data);
         return res.data;
+    },
+
+    // Enrolls this terminal (owner/manager only) and keeps its device token
+    enrollDevice: async (name: string = getDeviceName()) => {
+        const res = await api.post("/pos/devices/enroll", { fingerprint: getDeviceFingerprint(), name });
+        setDeviceToken(res.data.token);
+        return res.data.device;
     }
 };
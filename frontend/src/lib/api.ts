@@ -1,4 +1,5 @@
 import axios from "axios";
+import { DEVICE_TOKEN_HEADER, getDeviceToken } from "./device";
 
 const api = axios.create({
     baseURL: "http://localhost:8080/api/v1",
@@ -13,4 +14,17 @@ const api = axios.create({
 // But Gin generic CSRF often looks for header.
 // Let's simplified: If we have the cookie, we try to read it.
 
+const ENROLL_URL = "/pos/devices/enroll";
+
+const isPosCall = (url?: string) => !!url && url.startsWith("/pos/") && url !== ENROLL_URL;
+
+// POS calls carry the terminal's device token
+api.interceptors.request.use((config) => {
+    const token = getDeviceToken();
+    if (token && isPosCall(config.url)) {
+        config.headers.set(DEVICE_TOKEN_HEADER, token);
+    }
+    return config;
+});
+
 export default api;
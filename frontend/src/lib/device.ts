@@ -0,0 +1,36 @@
+// POS device enrollment: the backend requires every /pos call to carry the
+// terminal's device token (X-Device-Token). An owner or manager enrolls the
+// terminal once (see DeviceEnrollment), keyed by a fingerprint kept for this
+// browser profile.
+
+const FINGERPRINT_KEY = "sherpos-device-fingerprint";
+const TOKEN_KEY = "sherpos-device-token";
+
+export const DEVICE_TOKEN_HEADER = "X-Device-Token";
+
+export function getDeviceToken(): string | null {
+    if (typeof window === "undefined") return null;
+    return localStorage.getItem(TOKEN_KEY);
+}
+
+export function setDeviceToken(token: string | null) {
+    if (token) {
+        localStorage.setItem(TOKEN_KEY, token);
+    } else {
+        localStorage.removeItem(TOKEN_KEY);
+    }
+}
+
+export function getDeviceFingerprint(): string {
+    let fingerprint = localStorage.getItem(FINGERPRINT_KEY);
+    if (!fingerprint) {
+        fingerprint = crypto.randomUUID();
+        localStorage.setItem(FINGERPRINT_KEY, fingerprint);
+    }
+    return fingerprint;
+}
+
+export function getDeviceName(): string {
+    const platform = (navigator as any).userAgentData?.platform || navigator.platform || "Browser";
+    return `POS terminal (${platform})`.slice(0, 100);
+}